@@ -0,0 +1,60 @@
+package main
+
+import (
+	"aime/pkg/aime"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// backup writes a backup of the registry while the server may be running.
+func backup(args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	dir := fs.String("dir", "./backups/", "directory of the backups, outside of the DB")
+	keep := fs.Int("keep", 0, "number of backups to keep, 0 keeps all")
+	fs.Parse(args)
+
+	db := openDB()
+
+	filename, err := db.WriteBackup(*dir, *keep)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(filename)
+}
+
+// restore replaces the registry with a backup. The server must be stopped.
+func restore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	verify := fs.Bool("verify", false, "only verify the backup")
+	config := fs.Bool("config", false, "restore the config files as well")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if *verify {
+		man, _, err := aime.ReadBackup(fs.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Backup of %s with %d files is valid\n", man.CreatedAt.Format("2006-01-02 15:04:05"), len(man.Files))
+		return
+	}
+
+	configDir := ""
+	if *config {
+		configDir = "."
+	}
+
+	man, err := aime.RestoreBackup(fs.Arg(0), "./db/", configDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Restored backup of %s, the previous DB is in ./db.before-restore\n", man.CreatedAt.Format("2006-01-02 15:04:05"))
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// fsck prints the problems of the DB. It exits with 1 if problems are left
+// unrepaired.
+func fsck(args []string) {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repair := fs.Bool("repair", false, "repair what can be repaired safely")
+	fs.Parse(args)
+
+	db := openDB()
+
+	left := 0
+	for _, p := range db.Check(*repair) {
+		fmt.Println(p)
+		if !p.Repaired {
+			left++
+		}
+	}
+
+	if left > 0 {
+		fmt.Fprintf(os.Stderr, "%d problems left\n", left)
+		os.Exit(1)
+	}
+}
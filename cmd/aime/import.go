@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// importArchive restores a report from an archive downloaded from
+// /report/{id}/archive.
+func importArchive(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	newID := fs.Bool("new-id", false, "import the report under a new ID")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	archive, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db := openDB()
+
+	rp, err := db.ImportArchive(archive, *newID)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Imported report %s with %d revisions\n", rp.ID, rp.Revisions)
+}
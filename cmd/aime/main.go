@@ -2,31 +2,135 @@ package main
 
 import (
 	"aime/pkg/aime"
+	"fmt"
 	"log"
+	"os"
+	"time"
 )
 
+const usage = `Usage: aime [command]
+
+Commands:
+  serve           Start the registry server (default)
+  mirror <url>    Start a read-only server mirroring the registry API at url
+  suggest-groups  Print proposed keyword groups for near-duplicate keywords
+  import [-new-id] <archive.zip>
+                  Restore a report from its archive, optionally under a new ID
+  backup [-dir backups] [-keep n]
+                  Write a backup of the registry, keeping the newest n backups
+  restore [-verify] [-config] <backup.zip>
+                  Verify a backup and replace the registry with it
+  fsck [-repair]  Check the DB for inconsistencies and repair what is safe
+  migrate [-dry-run]
+                  Bring the stored reports, revisions and issues to the current schema
+`
+
 func main() {
+	cmd := "serve"
+	if len(os.Args) > 1 {
+		cmd = os.Args[1]
+	}
+
+	switch cmd {
+	case "serve":
+		serve()
+	case "mirror":
+		if len(os.Args) < 3 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		mirror(os.Args[2])
+	case "suggest-groups":
+		suggestGroups()
+	case "import":
+		importArchive(os.Args[2:])
+	case "backup":
+		backup(os.Args[2:])
+	case "restore":
+		restore(os.Args[2:])
+	case "fsck":
+		fsck(os.Args[2:])
+	case "migrate":
+		migrate(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func openDB() *aime.DB {
+	st, err := aime.ReadSettings("./config.yaml")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	kwg, err := aime.ReadKeywordGroups("./keyword-groups.yaml")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var tx *aime.Taxonomy
+	if st.Taxonomy != "" {
+		tx, err = aime.ReadTaxonomy(st.Taxonomy)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	db := &aime.DB{
-		KeywordGroups: aime.ReadKeywordGroups("./keyword-groups.yaml"),
-		Dir:           "./db/",
+		KeywordGroups:     kwg,
+		KeywordGroupsFile: "./keyword-groups.yaml",
+		Settings:          st,
+		Taxonomy:          tx,
+		Dir:               "./db/",
+		ConfigFiles:       []string{"./config.yaml", "./questionnaire.yaml", "./keyword-groups.yaml"},
+	}
+	if st.Taxonomy != "" {
+		db.ConfigFiles = append(db.ConfigFiles, st.Taxonomy)
 	}
 	db.Create("./questionnaire.yaml")
 
+	return db
+}
+
+func serve() {
+	db := openDB()
+
+	// Issues stored before a migration would be published again
+	if files := db.Outdated(); len(files) > 0 {
+		for _, f := range files {
+			log.Println(f)
+		}
+		log.Fatalf("%d documents are behind the schema, run `aime migrate` first\n", len(files))
+	}
+
 	es := aime.NewEmailSender("<EMAIL HOST>", 587, "<EMAIL USERNAME>", "<EMAIL PASSWORD>")
 	if err := es.LoadTemplates("./templates/"); err != nil {
 		log.Fatal(err)
 	}
 
 	srv := aime.Server{
-		Port: 9000,
-		DB:   db,
-		ES:   es,
+		Port:       9000,
+		DB:         db,
+		ES:         es,
+		AdminToken: "<ADMIN TOKEN>",
+	}
+
+	for name, count := range db.BuildIndexes() {
+		log.Printf("Found %d %s\n", count, name)
 	}
 
-	kl, cl := db.BuildKeywordList([]string{"MD", "5"}, []string{"P", "3", "1"})
+	db.BuildSimilarities()
+
+	stopWatching := db.WatchKeywordGroups(5 * time.Second)
+	defer stopWatching()
+
+	if n := db.ResumeDeliveries(); n > 0 {
+		log.Printf("Resumed %d webhook deliveries\n", n)
+	}
 
-	log.Printf("Found %d categories\n", cl)
-	log.Printf("Found %d keywords\n", kl)
+	stopPublishing := db.WatchIssues(time.Minute)
+	defer stopPublishing()
 
 	srv.Start()
 }
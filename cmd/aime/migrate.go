@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// migrate prints the documents that are behind the current schema and
+// rewrites them unless it is a dry run.
+func migrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only print the migrations")
+	fs.Parse(args)
+
+	db := openDB()
+
+	failed := 0
+	files := db.Migrate(*dryRun)
+	for _, f := range files {
+		fmt.Println(f)
+		if f.Err != nil {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d documents failed\n", failed, len(files))
+		os.Exit(1)
+	}
+	if *dryRun {
+		fmt.Printf("%d documents to migrate\n", len(files))
+	} else {
+		fmt.Printf("%d documents migrated\n", len(files))
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"aime/pkg/aime"
+	"log"
+	"time"
+)
+
+// mirror serves a read-only copy of the public reports of an upstream
+// registry and keeps it in sync.
+func mirror(upstream string) {
+	db := openDB()
+
+	m := &aime.Mirror{
+		Upstream: upstream,
+		DB:       db,
+		Wait:     50 * time.Second,
+	}
+
+	n, err := m.Sync()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Synced %d reports from %s\n", n, upstream)
+
+	srv := aime.Server{
+		Port:       9000,
+		DB:         db,
+		AdminToken: "<ADMIN TOKEN>",
+		ReadOnly:   true,
+	}
+
+	for name, count := range db.BuildIndexes() {
+		log.Printf("Found %d %s\n", count, name)
+	}
+
+	db.BuildSimilarities()
+
+	stopMirroring := m.Watch(time.Minute)
+	defer stopMirroring()
+
+	srv.Start()
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"gopkg.in/yaml.v2"
+	"log"
+	"os"
+)
+
+// suggestGroups prints proposed keyword groups in the format of
+// keyword-groups.yaml, ready to be reviewed and appended by a curator.
+func suggestGroups() {
+	db := openDB()
+
+	kwgBytes, err := yaml.Marshal(db.SuggestKeywordGroups())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	os.Stdout.Write(kwgBytes)
+}
@@ -0,0 +1,336 @@
+package aime
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// A report archive is a zip file holding everything to move a report to
+// another instance: the report, all revisions, all issues with their answers
+// and the documents the revisions refer to. Every revision is also rendered as
+// HTML, so that it can be read without a registry. The manifest lists the
+// checksum of every other file of the archive.
+
+const archiveFormat = "aime-report-archive"
+
+var (
+	ErrInvalidArchive = errors.New("invalid report archive")
+	ErrReportExists   = errors.New("report already exists")
+)
+
+// safeName matches the report IDs and document names the DB generates.
+var safeName = regexp.MustCompile(`^[0-9A-Za-z]+(\.pdf)?$`)
+
+type ArchiveManifest struct {
+	Format     string        `json:"format"`
+	Version    int           `json:"version"`
+	ReportID   string        `json:"reportId"`
+	ExportedAt time.Time     `json:"exportedAt"`
+	Files      []ArchiveFile `json:"files"`
+}
+
+type ArchiveFile struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// documentRefs appends the names of the documents uploaded as answers of file
+// questions.
+func documentRefs(q Question, a interface{}, refs []string) []string {
+	switch q.Type {
+	case "file":
+		if name, _ := a.(string); name != "" {
+			refs = append(refs, name)
+		}
+	case "complex":
+		compl, _ := a.(map[string]interface{})
+		for _, child := range q.Children {
+			refs = documentRefs(child, compl[child.ID], refs)
+		}
+	case "list":
+		list, _ := a.([]interface{})
+		if q.Child != nil {
+			for _, ae := range list {
+				refs = documentRefs(*q.Child, ae, refs)
+			}
+		}
+	}
+	return refs
+}
+
+// ExportArchive writes the archive of a report. Unless secrets is set, the
+// email addresses and tokens of the issue authors are left out.
+func (db *DB) ExportArchive(w io.Writer, id string, secrets bool) error {
+	rp := db.GetReport(id)
+	if rp == nil {
+		return os.ErrNotExist
+	}
+
+	zw := zip.NewWriter(w)
+	man := ArchiveManifest{
+		Format:     archiveFormat,
+		Version:    1,
+		ReportID:   rp.ID,
+		ExportedAt: time.Now(),
+		Files:      []ArchiveFile{},
+	}
+	add := func(name string, b []byte) error {
+		fw, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(b); err != nil {
+			return err
+		}
+		man.Files = append(man.Files, ArchiveFile{name, len(b), checksum(b)})
+		return nil
+	}
+
+	rpBytes := marshalDocument(SchemaReport, UnsafeReport(*rp))
+	if err := add("report.json", rpBytes); err != nil {
+		return err
+	}
+
+	docs := map[string]bool{}
+	for ver := 1; ver <= rp.Revisions; ver++ {
+		revBytes, err := ioutil.ReadFile(db.revisionFilePath(id, ver))
+		if err != nil {
+			return err
+		}
+		if err := add(fmt.Sprintf("revisions/%04d.json", ver), revBytes); err != nil {
+			return err
+		}
+
+		rev := Revision{}
+		unmarshalDocument(SchemaRevision, revBytes, &rev)
+		if err := add(fmt.Sprintf("revisions/%04d.html", ver), db.RenderHTML(rp, &rev)); err != nil {
+			return err
+		}
+
+		var ans interface{}
+		json.Unmarshal(rev.Answers, &ans)
+		for _, name := range documentRefs(db.questions, ans, nil) {
+			docs[name] = true
+		}
+	}
+
+	for cid := 1; cid <= rp.Comments; cid++ {
+		iss := db.GetIssue(id, cid)
+		if iss == nil {
+			continue
+		}
+		if !secrets {
+			iss.Email = ""
+			iss.Token = ""
+		}
+		issBytes := marshalDocument(SchemaIssue, UnsafeIssue(*iss))
+		if err := add(fmt.Sprintf("issues/%04d.json", cid), issBytes); err != nil {
+			return err
+		}
+	}
+
+	var names []string
+	for name := range docs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// Documents that were never uploaded cannot be moved
+		docBytes := db.ReadDocument(name)
+		if docBytes == nil || !safeName.MatchString(name) {
+			continue
+		}
+		if err := add("documents/"+name, docBytes); err != nil {
+			return err
+		}
+	}
+
+	manBytes, _ := json.MarshalIndent(man, "", "  ")
+	fw, err := zw.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(manBytes); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// readArchive reads and verifies all files of an archive.
+func readArchive(archive []byte) (*ArchiveManifest, map[string][]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, nil, ErrInvalidArchive
+	}
+
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, nil, ErrInvalidArchive
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, nil, ErrInvalidArchive
+		}
+		files[f.Name] = b
+	}
+
+	man := &ArchiveManifest{}
+	if json.Unmarshal(files["manifest.json"], man) != nil || man.Format != archiveFormat || man.Version != 1 {
+		return nil, nil, ErrInvalidArchive
+	}
+	delete(files, "manifest.json")
+
+	if len(man.Files) != len(files) {
+		return nil, nil, fmt.Errorf("%w: files not in manifest", ErrInvalidArchive)
+	}
+	for _, af := range man.Files {
+		b, ok := files[af.Name]
+		if !ok || len(b) != af.Size || checksum(b) != af.SHA256 {
+			return nil, nil, fmt.Errorf("%w: checksum mismatch of %s", ErrInvalidArchive, af.Name)
+		}
+	}
+
+	return man, files, nil
+}
+
+// ImportArchive restores a report from its archive, under a new ID if newID
+// is set. Documents that already exist are kept.
+func (db *DB) ImportArchive(archive []byte, newID bool) (*Report, error) {
+	_, files, err := readArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	rp := Report{}
+	if unmarshalDocument(SchemaReport, files["report.json"], (*UnsafeReport)(&rp)) != nil || !safeName.MatchString(rp.ID) {
+		return nil, ErrInvalidArchive
+	}
+
+	// The rendered revisions are not imported, they are rendered anew
+	var revs []*Revision
+	for ver := 1; ver <= rp.Revisions; ver++ {
+		rev := &Revision{}
+		if unmarshalDocument(SchemaRevision, files[fmt.Sprintf("revisions/%04d.json", ver)], rev) != nil || rev.Version != ver {
+			return nil, fmt.Errorf("%w: revision %d is missing", ErrInvalidArchive, ver)
+		}
+		revs = append(revs, rev)
+	}
+	var issues []Issue
+	for cid := 1; cid <= rp.Comments; cid++ {
+		issBytes, ok := files[fmt.Sprintf("issues/%04d.json", cid)]
+		if !ok {
+			continue
+		}
+		iss := Issue{}
+		if unmarshalDocument(SchemaIssue, issBytes, (*UnsafeIssue)(&iss)) != nil || iss.ID != cid {
+			return nil, fmt.Errorf("%w: issue %d is invalid", ErrInvalidArchive, cid)
+		}
+		issues = append(issues, iss)
+	}
+
+	db.writeMutex.RLock()
+	defer db.writeMutex.RUnlock()
+
+	if newID {
+		rp.ID = generateRandomString(6)
+	} else if db.ExistsReport(rp.ID) {
+		return nil, ErrReportExists
+	}
+	if rp.Token == "" {
+		rp.Token = generateRandomString(16)
+	}
+
+	for name, b := range files {
+		dir, doc := path.Split(name)
+		if dir != "documents/" {
+			continue
+		}
+		if !safeName.MatchString(doc) {
+			return nil, fmt.Errorf("%w: invalid document %s", ErrInvalidArchive, doc)
+		}
+		if old := db.ReadDocument(doc); old != nil {
+			if !bytes.Equal(old, b) {
+				return nil, fmt.Errorf("%w: document %s differs", ErrInvalidArchive, doc)
+			}
+			continue
+		}
+		os.MkdirAll(filepath.Join(db.Dir, "documents"), os.ModePerm)
+		if err := ioutil.WriteFile(db.documentPath(doc), b, os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+
+	// The report is assembled aside and moved in place at once, so that a
+	// failed import leaves no partial report behind
+	tmp, err := ioutil.TempDir(db.Dir, "import-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	tmpDB := &DB{Dir: tmp}
+	os.MkdirAll(tmpDB.revisionPath(rp.ID), os.ModePerm)
+	os.MkdirAll(tmpDB.commentPath(rp.ID), os.ModePerm)
+	for _, rev := range revs {
+		rev.ReportID = rp.ID
+		revBytes := marshalDocument(SchemaRevision, rev)
+		if err := ioutil.WriteFile(tmpDB.revisionFilePath(rp.ID, rev.Version), revBytes, os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+	for _, iss := range issues {
+		iss.ReportID = rp.ID
+		// Archives exported by the owner have no tokens of the issue authors
+		if iss.Token == "" {
+			iss.Token = generateRandomString(16)
+		}
+		issBytes := marshalDocument(SchemaIssue, UnsafeIssue(iss))
+		if err := ioutil.WriteFile(tmpDB.commentFilePath(rp.ID, iss.ID), issBytes, os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+	rpBytes := marshalDocument(SchemaReport, UnsafeReport(rp))
+	if err := ioutil.WriteFile(filepath.Join(tmpDB.reportPath(rp.ID), "report.json"), rpBytes, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	os.MkdirAll(filepath.Join(db.Dir, "reports"), os.ModePerm)
+	if db.ExistsReport(rp.ID) {
+		return nil, ErrReportExists
+	}
+	if err := os.Rename(tmpDB.reportPath(rp.ID), db.reportPath(rp.ID)); err != nil {
+		return nil, err
+	}
+
+	db.recordChange(Change{Type: EventReportCreated, ReportID: rp.ID})
+	for _, rev := range revs {
+		db.updateSimilarity(rev)
+		db.recordChange(Change{Type: EventRevisionCreated, ReportID: rp.ID, Version: rev.Version})
+	}
+	go db.BuildIndexes()
+
+	return &rp, nil
+}
@@ -0,0 +1,114 @@
+package aime
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServer_Archive(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	doc := db.UploadDocument([]byte("%PDF-1.4 checklist"))
+	db.UploadDocument([]byte("%PDF-1.4 unrelated"))
+
+	rp := db.CreateReport("owner@test.de", true)
+	db.CreateRevision(rp.ID, "owner@test.de", json.RawMessage(`{"MD":{"1":"First"}}`), rp.Token, true)
+	db.CreateRevision(rp.ID, "owner@test.de", json.RawMessage(`{"MD":{"1":"Second","9":[{"2":"`+doc+`"}]}}`), rp.Token, true)
+	iss := db.CreateIssue(rp.ID, "Jane", "jane@test.de", []string{"MD"}, "Question", 0)
+	db.ValidateIssue(rp.ID, iss.ID, iss.Token)
+	db.CreateAnswer(rp.ID, iss.ID, "Answer", rp.Token)
+
+	srv := Server{DB: db, AdminToken: "admin"}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	if resp, _ := http.Get(ts.URL + "/report/" + rp.ID + "/archive"); resp.StatusCode != 403 {
+		t.Fatal(resp.StatusCode)
+	}
+
+	get := func(pw string) []byte {
+		resp, _ := http.Get(ts.URL + "/report/" + rp.ID + "/archive?p=" + pw)
+		if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "application/zip" {
+			t.Fatal(resp.StatusCode)
+		}
+		archive, _ := ioutil.ReadAll(resp.Body)
+		return archive
+	}
+
+	// The owner gets no secrets of the issue authors
+	man, files, err := readArchive(get(rp.Token))
+	if err != nil || man.ReportID != rp.ID {
+		t.Fatal(man, err)
+	}
+	if len(files) != 7 || files["documents/"+doc] == nil || files["revisions/0002.json"] == nil {
+		t.Fatal(man.Files)
+	}
+	if html := string(files["revisions/0002.html"]); !bytes.HasPrefix(files["revisions/0001.html"], []byte("<!DOCTYPE html>")) || !strings.Contains(html, "Second") {
+		t.Fatal(html)
+	}
+	oi := UnsafeIssue{}
+	json.Unmarshal(files["issues/0001.json"], &oi)
+	if oi.Email != "" || oi.Token != "" || len(oi.Answers) != 1 {
+		t.Fatal(oi)
+	}
+
+	archive := get("admin")
+
+	// Importing under the same ID needs the report to be gone
+	if _, err := db.ImportArchive(archive, false); err != ErrReportExists {
+		t.Fatal(err)
+	}
+
+	resp, _ := http.Post(ts.URL+"/admin/reports/import?newId=1&p=admin", "application/zip", bytes.NewReader(archive))
+	if resp.StatusCode != 200 {
+		t.Fatal(resp.StatusCode)
+	}
+	respBytes, _ := ioutil.ReadAll(resp.Body)
+	ir := ImportArchiveResponse{}
+	json.Unmarshal(respBytes, &ir)
+	if ir.ID == rp.ID || ir.Revisions != 2 {
+		t.Fatal(ir)
+	}
+
+	irp := db.GetReport(ir.ID)
+	if irp == nil || irp.Token != rp.Token || irp.Email != "owner@test.de" {
+		t.Fatal(irp)
+	}
+	if rev := db.GetRevision(ir.ID, 1); rev == nil || rev.ReportID != ir.ID || string(rev.Answers) != `{"MD":{"1":"First"}}` {
+		t.Fatal(rev)
+	}
+	if ii := db.GetIssue(ir.ID, 1); ii == nil || ii.ReportID != ir.ID || ii.Token != iss.Token || ii.Pending() {
+		t.Fatal(ii)
+	}
+
+	// Restoring a deleted report keeps its ID
+	db.DeleteReport(rp.ID)
+	if irp, err := db.ImportArchive(archive, false); err != nil || irp.ID != rp.ID || !db.ExistsReport(rp.ID) {
+		t.Fatal(irp, err)
+	}
+}
+
+func TestReadArchive(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	fw, _ := zw.Create("report.json")
+	fw.Write([]byte(`{"id":"abc"}`))
+	fw, _ = zw.Create("manifest.json")
+	fw.Write([]byte(`{"format":"aime-report-archive","version":1,"files":[{"name":"report.json","size":12,"sha256":"00"}]}`))
+	zw.Close()
+
+	if _, _, err := readArchive(buf.Bytes()); err == nil {
+		t.Fatal("checksum mismatch not detected")
+	}
+	if _, _, err := readArchive([]byte("no zip")); err != ErrInvalidArchive {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,342 @@
+package aime
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A backup is a zip file of the whole DB directory and the config files. The
+// DB is first copied aside while its writers wait, so that no write is half
+// done in the copy. The backup is the state of the DB as of the change in its
+// manifest.
+
+const backupFormat = "aime-backup"
+
+var ErrInvalidBackup = errors.New("invalid backup")
+
+type BackupManifest struct {
+	Format    string        `json:"format"`
+	Version   int           `json:"version"`
+	CreatedAt time.Time     `json:"createdAt"`
+	Change    int64         `json:"change"`
+	Files     []ArchiveFile `json:"files"`
+}
+
+// backupSkipped reports whether a path relative to the DB directory is left
+// out of backups, which are caches and unfinished imports.
+func backupSkipped(rel string) bool {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) == 1 && strings.HasPrefix(parts[0], "import-") {
+		return true
+	}
+	return len(parts) >= 3 && parts[0] == "reports" && parts[2] == "pdf"
+}
+
+// copyComplete copies a file. JSON files that are being written are not valid
+// yet, they are read again until they are. Webhook deliveries are written
+// while a backup is taken.
+func copyComplete(src string, dst string) error {
+	var b []byte
+	var err error
+	for i := 0; i < 20; i++ {
+		b, err = ioutil.ReadFile(src)
+		if err != nil || !strings.HasSuffix(src, ".json") || json.Valid(b) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(src, ".json") && !json.Valid(b) {
+		return fmt.Errorf("%s is incomplete", src)
+	}
+
+	os.MkdirAll(filepath.Dir(dst), os.ModePerm)
+	return ioutil.WriteFile(dst, b, os.ModePerm)
+}
+
+// copyTree copies a directory of the DB, given relative to the DB directory,
+// into the same place below dst. Files deleted meanwhile are skipped.
+func (db *DB) copyTree(rel string, dst string) error {
+	return filepath.Walk(filepath.Join(db.Dir, rel), func(p string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(db.Dir, p)
+		if info.IsDir() {
+			if backupSkipped(rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if err := copyComplete(p, filepath.Join(dst, rel)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+// snapshot copies the DB into dir and returns the change it is consistent
+// with. Writers wait until the copy is complete.
+func (db *DB) snapshot(dir string) (int64, error) {
+	db.writeMutex.Lock()
+	defer db.writeMutex.Unlock()
+
+	if err := db.copyTree(".", dir); err != nil {
+		return 0, err
+	}
+	return db.LastChange(), nil
+}
+
+// Backup writes a backup of the DB and the config files.
+func (db *DB) Backup(w io.Writer) (*BackupManifest, error) {
+	tmp, err := ioutil.TempDir("", "aime-backup-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	last, err := db.snapshot(filepath.Join(tmp, "db"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range db.ConfigFiles {
+		// Relative paths are kept, so the files can be restored in place
+		rel := filepath.Clean(f)
+		if filepath.IsAbs(rel) || strings.HasPrefix(rel, "..") {
+			rel = filepath.Base(rel)
+		}
+		if err := copyComplete(f, filepath.Join(tmp, "config", rel)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	man := &BackupManifest{
+		Format:    backupFormat,
+		Version:   1,
+		CreatedAt: time.Now(),
+		Change:    last,
+		Files:     []ArchiveFile{},
+	}
+
+	zw := zip.NewWriter(w)
+	err = filepath.Walk(tmp, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(tmp, p)
+		name := filepath.ToSlash(rel)
+
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		fw, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(b); err != nil {
+			return err
+		}
+		man.Files = append(man.Files, ArchiveFile{name, len(b), checksum(b)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	manBytes, _ := json.MarshalIndent(man, "", "  ")
+	fw, err := zw.Create("manifest.json")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(manBytes); err != nil {
+		return nil, err
+	}
+
+	return man, zw.Close()
+}
+
+// WriteBackup writes a backup into dir, together with its checksum in the
+// format of sha256sum. With keep > 0, only the newest keep backups are kept.
+func (db *DB) WriteBackup(dir string, keep int) (string, error) {
+	os.MkdirAll(dir, os.ModePerm)
+
+	name := "aime-backup-" + time.Now().UTC().Format("20060102T150405.000Z") + ".zip"
+	filename := filepath.Join(dir, name)
+
+	// The backup and its checksum are written under temporary names first,
+	// a failed backup leaves none of its files behind
+	files := []string{filename + ".tmp", filename, filename + ".sha256.tmp", filename + ".sha256"}
+	cleanup := func(err error) (string, error) {
+		for _, f := range files {
+			os.Remove(f)
+		}
+		return "", err
+	}
+
+	f, err := os.Create(filename + ".tmp")
+	if err != nil {
+		return cleanup(err)
+	}
+	h := sha256.New()
+	_, err = db.Backup(io.MultiWriter(f, h))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return cleanup(err)
+	}
+	if err := os.Rename(filename+".tmp", filename); err != nil {
+		return cleanup(err)
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil)) + "  " + name + "\n"
+	if err := ioutil.WriteFile(filename+".sha256.tmp", []byte(sum), os.ModePerm); err != nil {
+		return cleanup(err)
+	}
+	if err := os.Rename(filename+".sha256.tmp", filename+".sha256"); err != nil {
+		return cleanup(err)
+	}
+
+	if keep > 0 {
+		backups, _ := filepath.Glob(filepath.Join(dir, "aime-backup-*.zip"))
+		// The timestamps in the names sort chronologically
+		sort.Strings(backups)
+		for i := 0; i < len(backups)-keep; i++ {
+			os.Remove(backups[i])
+			os.Remove(backups[i] + ".sha256")
+		}
+	}
+
+	return filename, nil
+}
+
+// ReadBackup verifies a backup file against its checksum file, if there is
+// one, and every file against the manifest.
+func ReadBackup(filename string) (*BackupManifest, map[string][]byte, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if sum, err := ioutil.ReadFile(filename + ".sha256"); err == nil {
+		fields := strings.Fields(string(sum))
+		if len(fields) == 0 || fields[0] != checksum(b) {
+			return nil, nil, fmt.Errorf("%w: checksum mismatch of %s", ErrInvalidBackup, filename)
+		}
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, nil, ErrInvalidBackup
+	}
+
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, nil, ErrInvalidBackup
+		}
+		fb, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %s is damaged", ErrInvalidBackup, f.Name)
+		}
+		files[f.Name] = fb
+	}
+
+	man := &BackupManifest{}
+	if json.Unmarshal(files["manifest.json"], man) != nil || man.Format != backupFormat || man.Version != 1 {
+		return nil, nil, ErrInvalidBackup
+	}
+	delete(files, "manifest.json")
+
+	if len(man.Files) != len(files) {
+		return nil, nil, fmt.Errorf("%w: files not in manifest", ErrInvalidBackup)
+	}
+	for _, af := range man.Files {
+		fb, ok := files[af.Name]
+		if !ok || len(fb) != af.Size || checksum(fb) != af.SHA256 {
+			return nil, nil, fmt.Errorf("%w: checksum mismatch of %s", ErrInvalidBackup, af.Name)
+		}
+		// Names must stay within the restored directories
+		clean := path.Clean(af.Name)
+		if clean != af.Name || path.IsAbs(clean) || strings.HasPrefix(clean, "../") ||
+			!(strings.HasPrefix(clean, "db/") || strings.HasPrefix(clean, "config/")) {
+			return nil, nil, fmt.Errorf("%w: invalid name %s", ErrInvalidBackup, af.Name)
+		}
+	}
+
+	return man, files, nil
+}
+
+// RestoreBackup replaces the DB directory with the one of a verified backup.
+// The current directory is kept next to it with the suffix ".before-restore".
+// With configDir set, the config files are restored there as well. The server
+// must not be running.
+func RestoreBackup(filename string, dbDir string, configDir string) (*BackupManifest, error) {
+	man, files, err := ReadBackup(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	dbDir = filepath.Clean(dbDir)
+	tmp := dbDir + ".restore"
+	os.RemoveAll(tmp)
+	os.MkdirAll(tmp, os.ModePerm)
+
+	for name, b := range files {
+		if rel := strings.TrimPrefix(name, "db/"); rel != name {
+			dst := filepath.Join(tmp, filepath.FromSlash(rel))
+			os.MkdirAll(filepath.Dir(dst), os.ModePerm)
+			if err := ioutil.WriteFile(dst, b, os.ModePerm); err != nil {
+				os.RemoveAll(tmp)
+				return nil, err
+			}
+		}
+	}
+
+	old := dbDir + ".before-restore"
+	os.RemoveAll(old)
+	if err := os.Rename(dbDir, old); err != nil && !os.IsNotExist(err) {
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, dbDir); err != nil {
+		os.Rename(old, dbDir)
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+
+	if configDir != "" {
+		for name, b := range files {
+			if rel := strings.TrimPrefix(name, "config/"); rel != name {
+				dst := filepath.Join(configDir, filepath.FromSlash(rel))
+				os.MkdirAll(filepath.Dir(dst), os.ModePerm)
+				if err := ioutil.WriteFile(dst, b, os.ModePerm); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return man, nil
+}
@@ -0,0 +1,139 @@
+package aime
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_Backup(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	tmp, _ := ioutil.TempDir("", "aime-test-")
+	defer os.RemoveAll(tmp)
+
+	config := filepath.Join(tmp, "config.yaml")
+	ioutil.WriteFile(config, []byte("titleField: MD.1\n"), os.ModePerm)
+	db.ConfigFiles = []string{config}
+
+	rp := db.CreateReport("", true)
+	db.CreateRevision(rp.ID, "", json.RawMessage(`{"MD":{"1":"First"}}`), rp.Token, true)
+	doc := db.UploadDocument([]byte("%PDF-1.4"))
+	db.RenderPDF(rp, db.GetRevision(rp.ID, 1))
+	os.MkdirAll(filepath.Join(db.Dir, "import-123"), os.ModePerm)
+
+	// Reports keep changing while backups are taken
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			crp := db.CreateReport("", true)
+			db.CreateRevision(crp.ID, "", json.RawMessage("{}"), crp.Token, true)
+			db.CreateRevision(rp.ID, "", json.RawMessage(`{"MD":{"1":"Next"}}`), rp.Token, true)
+		}
+	}()
+
+	dir := filepath.Join(tmp, "backups")
+	var filename string
+	for i := 0; i < 3; i++ {
+		var err error
+		filename, err = db.WriteBackup(dir, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+
+	if backups, _ := filepath.Glob(filepath.Join(dir, "aime-backup-*")); len(backups) != 4 {
+		t.Fatal(backups)
+	}
+
+	man, files, err := ReadBackup(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files["db/documents/"+doc] == nil || files["config/"+filepath.Base(config)] == nil {
+		t.Fatal(man.Files)
+	}
+	for name := range files {
+		if filepath.Base(filepath.Dir(name)) == "pdf" || filepath.Dir(name) == "db/import-123" {
+			t.Fatal(name)
+		}
+	}
+
+	restored := filepath.Join(tmp, "db")
+	if _, err := RestoreBackup(filename, restored, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every restored report has all of its revisions
+	rdb := &DB{Dir: restored}
+	n := 0
+	for rev := range rdb.GetLatestRevisions(true) {
+		rrp := rdb.GetReport(rev.ReportID)
+		for ver := 1; ver <= rrp.Revisions; ver++ {
+			if rdb.GetRevision(rrp.ID, ver) == nil {
+				t.Fatal(rrp, ver)
+			}
+		}
+		// and no revision the report does not count yet
+		if rdb.ExistsRevision(rrp.ID, rrp.Revisions+1) {
+			t.Fatal(rrp)
+		}
+		n++
+	}
+	if n == 0 || rdb.LastChange() != man.Change {
+		t.Fatal(n, rdb.LastChange(), man.Change)
+	}
+
+	// Damaged backups are not restored
+	b, _ := ioutil.ReadFile(filename)
+	b[len(b)/2] ^= 0xff
+	ioutil.WriteFile(filename, b, os.ModePerm)
+	if _, err := RestoreBackup(filename, restored, ""); err == nil {
+		t.Fatal("damaged backup restored")
+	}
+	if _, err := os.Stat(restored + ".before-restore"); err == nil {
+		t.Fatal("DB replaced by damaged backup")
+	}
+}
+
+func TestServer_Backup(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	rp := db.CreateReport("", true)
+	db.CreateRevision(rp.ID, "", json.RawMessage("{}"), rp.Token, true)
+
+	srv := Server{DB: db, AdminToken: "admin"}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	if resp, _ := http.Get(ts.URL + "/admin/backup"); resp.StatusCode != 403 {
+		t.Fatal(resp.StatusCode)
+	}
+
+	resp, _ := http.Get(ts.URL + "/admin/backup?p=admin")
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "application/zip" {
+		t.Fatal(resp.StatusCode)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+
+	tmp, _ := ioutil.TempDir("", "aime-test-")
+	defer os.RemoveAll(tmp)
+	filename := filepath.Join(tmp, "backup.zip")
+	ioutil.WriteFile(filename, b, os.ModePerm)
+
+	_, files, err := ReadBackup(filename)
+	if err != nil || !bytes.Contains(files["db/reports/"+rp.ID+"/report.json"], []byte(rp.ID)) {
+		t.Fatal(err)
+	}
+}
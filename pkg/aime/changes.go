@@ -0,0 +1,172 @@
+package aime
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The change log numbers every mutation of the registry, so downstream systems
+// can ask for what changed since the last change they saw. Changes are stored
+// one file per sequence number and refer to the changed objects by ID only.
+// The log records every change, GET /changes only serves those of public
+// reports and published issues.
+
+// Changes besides the webhook events
+const (
+	ChangeVisibility    = "visibility.changed"
+	ChangeReportDeleted = "report.deleted"
+)
+
+// maxChangeWait caps the long-poll of GET /changes.
+const maxChangeWait = 60 * time.Second
+
+type Change struct {
+	Seq       int64     `json:"seq"`
+	Type      string    `json:"type"`
+	ReportID  string    `json:"reportId"`
+	Version   int       `json:"version,omitempty"`
+	IssueID   int       `json:"issueId,omitempty"`
+	AnswerID  int       `json:"answerId,omitempty"`
+	Public    *bool     `json:"isPublic,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (db *DB) changePath() string {
+	return filepath.Join(db.Dir, "changes")
+}
+
+func (db *DB) changeFilePath(seq int64) string {
+	return filepath.Join(db.changePath(), fmt.Sprintf("%010d.json", seq))
+}
+
+// loadChangeSeq reads the last sequence number once. The caller holds
+// changeMutex.
+func (db *DB) loadChangeSeq() {
+	if db.changeSeqLoaded {
+		return
+	}
+	db.changeSeqLoaded = true
+
+	files, _ := ioutil.ReadDir(db.changePath())
+	for _, f := range files {
+		seq, err := strconv.ParseInt(strings.TrimSuffix(f.Name(), ".json"), 10, 64)
+		if err == nil && seq > db.changeSeq {
+			db.changeSeq = seq
+		}
+	}
+}
+
+// recordChange appends a change to the log and wakes up waiting readers.
+func (db *DB) recordChange(c Change) {
+	db.changeMutex.Lock()
+	defer db.changeMutex.Unlock()
+
+	db.loadChangeSeq()
+
+	c.Seq = db.changeSeq + 1
+	c.CreatedAt = time.Now()
+
+	os.MkdirAll(db.changePath(), os.ModePerm)
+	cBytes, _ := json.Marshal(c)
+	if ioutil.WriteFile(db.changeFilePath(c.Seq), cBytes, os.ModePerm) != nil {
+		return
+	}
+	db.changeSeq = c.Seq
+
+	if db.changeSignal != nil {
+		close(db.changeSignal)
+		db.changeSignal = nil
+	}
+}
+
+// LastChange returns the sequence number of the latest change, 0 if there is
+// none.
+func (db *DB) LastChange() int64 {
+	db.changeMutex.Lock()
+	defer db.changeMutex.Unlock()
+
+	db.loadChangeSeq()
+	return db.changeSeq
+}
+
+// publicChange reports whether a change may be served to anyone. Changes of
+// hidden reports and unpublished issues are left out, hiding a report is kept,
+// so that downstream systems can drop it.
+func (db *DB) publicChange(c Change) bool {
+	switch c.Type {
+	case ChangeVisibility:
+		return true
+	case ChangeReportDeleted:
+		return c.Public != nil && *c.Public
+	case EventIssueCreated, EventIssueConfirmed:
+		return false
+	}
+
+	rp := db.GetReport(c.ReportID)
+	if rp == nil || !rp.Public {
+		return false
+	}
+
+	switch c.Type {
+	case EventRevisionCreated:
+		rev := db.GetRevision(c.ReportID, c.Version)
+		return rev != nil && rev.Public
+	case EventIssuePublished, EventAnswerCreated:
+		iss := db.GetIssue(c.ReportID, c.IssueID)
+		return iss != nil && !iss.Deleted && !iss.PublishedAt.IsZero()
+	}
+	return true
+}
+
+// GetChanges returns up to limit changes after since.
+func (db *DB) GetChanges(since int64, limit int) []Change {
+	last := db.LastChange()
+
+	cs := []Change{}
+	for seq := since + 1; seq <= last && len(cs) < limit; seq++ {
+		cBytes, err := ioutil.ReadFile(db.changeFilePath(seq))
+		if err != nil {
+			continue
+		}
+		c := Change{}
+		if json.Unmarshal(cBytes, &c) == nil {
+			cs = append(cs, c)
+		}
+	}
+	return cs
+}
+
+// WaitChanges blocks until there are changes after since, the timeout passed
+// or done is closed. It reports whether there are changes.
+func (db *DB) WaitChanges(since int64, timeout time.Duration, done <-chan struct{}) bool {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	for {
+		db.changeMutex.Lock()
+		db.loadChangeSeq()
+		if db.changeSeq > since {
+			db.changeMutex.Unlock()
+			return true
+		}
+		if db.changeSignal == nil {
+			db.changeSignal = make(chan struct{})
+		}
+		signal := db.changeSignal
+		db.changeMutex.Unlock()
+
+		select {
+		case <-signal:
+		case <-t.C:
+			return false
+		case <-done:
+			return false
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package aime
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDB_GetChanges(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	rp := db.CreateReport("", true)
+	db.CreateRevision(rp.ID, "", json.RawMessage("{}"), rp.Token, true)
+	db.CreateRevision(rp.ID, "", json.RawMessage("{}"), rp.Token, false)
+	iss := db.CreateIssue(rp.ID, "Jane", "jane@test.de", []string{"MD"}, "Question", 0)
+	db.ValidateIssue(rp.ID, iss.ID, iss.Token)
+	db.CreateAnswer(rp.ID, iss.ID, "Answer", rp.Token)
+
+	cs := db.GetChanges(0, 100)
+	types := []string{EventReportCreated, EventRevisionCreated, EventRevisionCreated, ChangeVisibility,
+		EventIssueCreated, EventIssueConfirmed, EventAnswerCreated}
+	if len(cs) != len(types) {
+		t.Fatal(cs)
+	}
+	for i, c := range cs {
+		if c.Seq != int64(i+1) || c.Type != types[i] || c.ReportID != rp.ID {
+			t.Fatal(c)
+		}
+	}
+	if *cs[3].Public || cs[3].Version != 2 || cs[6].AnswerID != 1 {
+		t.Fatal(cs[3], cs[6])
+	}
+
+	// Sequence numbers survive restarts
+	db2 := &DB{Dir: "./test"}
+	if db2.LastChange() != 7 {
+		t.Fatal(db2.LastChange())
+	}
+	db2.DeleteReport(rp.ID)
+	if cs := db2.GetChanges(6, 100); len(cs) != 2 || cs[1].Seq != 8 || cs[1].Type != ChangeReportDeleted {
+		t.Fatal(cs)
+	}
+}
+
+func TestServer_Changes(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	rp := db.CreateReport("", true)
+	db.CreateRevision(rp.ID, "", json.RawMessage("{}"), rp.Token, true)
+
+	srv := Server{DB: db}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	get := func(query string) ChangesResponse {
+		resp, _ := http.Get(ts.URL + "/changes?" + query)
+		if resp.StatusCode != 200 {
+			t.Fatal(resp.StatusCode)
+		}
+		respBytes, _ := ioutil.ReadAll(resp.Body)
+		cr := ChangesResponse{}
+		json.Unmarshal(respBytes, &cr)
+		return cr
+	}
+
+	if cr := get("since=0&limit=1"); len(cr.Changes) != 1 || cr.Last != 1 || !cr.More {
+		t.Fatal(cr)
+	}
+	if cr := get("since=1"); len(cr.Changes) != 1 || cr.Last != 2 || cr.More {
+		t.Fatal(cr)
+	}
+
+	// Long-poll until the next change
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		db.CreateRevision(rp.ID, "", json.RawMessage("{}"), rp.Token, true)
+	}()
+	start := time.Now()
+	cr := get("since=2&wait=5")
+	if len(cr.Changes) != 1 || cr.Changes[0].Version != 2 || time.Since(start) > 4*time.Second {
+		t.Fatal(cr)
+	}
+
+	// Without changes, the long-poll returns empty after the timeout
+	if cr := get("since=" + strconv.FormatInt(cr.Last, 10) + "&wait=1"); len(cr.Changes) != 0 || cr.Last != 3 {
+		t.Fatal(cr)
+	}
+
+	// Hidden reports and unverified issues are left out
+	hp := db.CreateReport("", false)
+	db.CreateRevision(hp.ID, "", json.RawMessage("{}"), hp.Token, false)
+	iss := db.CreateIssue(rp.ID, "Jane", "jane@test.de", []string{"MD"}, "Question", 0)
+	db.ValidateIssue(rp.ID, iss.ID, iss.Token)
+	if cr := get("since=3"); len(cr.Changes) != 0 || cr.Last != 7 || cr.More {
+		t.Fatal(cr)
+	}
+
+	// Hiding a report is served, its hidden revision is not
+	db.CreateRevision(rp.ID, "", json.RawMessage("{}"), rp.Token, false)
+	db.DeleteReport(hp.ID)
+	cr = get("since=7")
+	if len(cr.Changes) != 1 || cr.Changes[0].Type != ChangeVisibility || *cr.Changes[0].Public || cr.Last != 10 {
+		t.Fatal(cr)
+	}
+
+	if resp, _ := http.Get(ts.URL + "/changes?limit=0"); resp.StatusCode != 400 {
+		t.Fatal(resp.StatusCode)
+	}
+}
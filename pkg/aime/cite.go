@@ -0,0 +1,344 @@
+package aime
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var orcidRegexp = regexp.MustCompile(`(\d{4}-\d{4}-\d{4}-\d{3}[\dX])`)
+
+type citationAuthor struct {
+	given       string
+	family      string
+	affiliation string
+	orcid       string
+}
+
+// citation holds the bibliographic data of a revision. A pinned citation
+// refers to the specific revision, otherwise to the report.
+type citation struct {
+	id          string
+	version     int
+	pinned      bool
+	title       string
+	description string
+	keywords    []string
+	authors     []citationAuthor
+	date        time.Time
+	url         string
+}
+
+// splitName splits a name into given and family names, either "Doe, Jane" or
+// "Jane Doe" where the last word is the family name.
+func splitName(name string) (string, string) {
+	name = strings.Join(strings.Fields(name), " ")
+	if i := strings.Index(name, ","); i >= 0 {
+		return strings.TrimSpace(name[i+1:]), strings.TrimSpace(name[:i])
+	}
+	if i := strings.LastIndex(name, " "); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+// normalizeOrcid returns the ORCID iD as URL or "" if there is none.
+func normalizeOrcid(o string) string {
+	m := orcidRegexp.FindString(strings.ToUpper(o))
+	if m == "" {
+		return ""
+	}
+	return "https://orcid.org/" + m
+}
+
+// splitListField splits a field of list elements into the path of the list
+// and the path within the elements, e.g. "MD.6.*.1" into "MD.6" and "1".
+func splitListField(field string) ([]string, []string) {
+	ids := splitField(field)
+	for i, id := range ids {
+		if id == "*" {
+			return ids[:i], ids[i+1:]
+		}
+	}
+	return ids, nil
+}
+
+// listFieldIDs returns the path of a field within the elements of the list at
+// listIDs. It reports false if the field is not part of the elements.
+func listFieldIDs(listIDs []string, field string) ([]string, bool) {
+	l, ids := splitListField(field)
+	if ids == nil || strings.Join(l, ".") != strings.Join(listIDs, ".") {
+		return nil, false
+	}
+	return ids, true
+}
+
+func (db *DB) citation(rev *Revision, pinned bool) citation {
+	st := db.Settings.withDefaults()
+
+	c := citation{
+		id:          rev.ReportID,
+		version:     rev.Version,
+		pinned:      pinned,
+		title:       ExtractField(db.questions, rev.Answers, splitField(st.TitleField)),
+		description: ExtractField(db.questions, rev.Answers, splitField(st.DescriptionField)),
+		date:        rev.CreatedAt.UTC(),
+		url:         reportURL + rev.ReportID,
+	}
+	if pinned {
+		c.url += "/" + strconv.Itoa(rev.Version)
+	}
+
+	kwIndex := &index{definition: st.index("keywords"), entries: map[string]*indexEntry{}}
+	c.keywords = kwIndex.values(db.questions, db.GetKeywordGroups(), rev.Answers)
+
+	// Name, affiliation and ORCID of an author are read from the same element
+	// of the list of authors, so that missing fields of one author do not
+	// shift the others
+	listIDs, nameIDs := splitListField(st.AuthorsField)
+	var ans interface{}
+	json.Unmarshal(rev.Answers, &ans)
+	child, elements := extractList(db.questions, ans, listIDs)
+	if child == nil {
+		for _, n := range ExtractFields(db.questions, rev.Answers, splitField(st.AuthorsField)) {
+			if strings.TrimSpace(n) == "" {
+				continue
+			}
+			a := citationAuthor{}
+			a.given, a.family = splitName(n)
+			c.authors = append(c.authors, a)
+		}
+		return c
+	}
+
+	affiliationIDs, withAffiliation := listFieldIDs(listIDs, st.AffiliationField)
+	orcidIDs, withOrcid := listFieldIDs(listIDs, st.OrcidField)
+	for _, e := range elements {
+		n := extractField(*child, e, nameIDs, ", ")
+		if strings.TrimSpace(n) == "" {
+			continue
+		}
+		a := citationAuthor{}
+		a.given, a.family = splitName(n)
+		if withAffiliation {
+			a.affiliation = strings.TrimSpace(extractField(*child, e, affiliationIDs, ", "))
+		}
+		if withOrcid {
+			a.orcid = normalizeOrcid(extractField(*child, e, orcidIDs, ", "))
+		}
+		c.authors = append(c.authors, a)
+	}
+
+	return c
+}
+
+func (c citation) key() string {
+	if c.pinned {
+		return fmt.Sprintf("aime_%s_v%d", c.id, c.version)
+	}
+	return "aime_" + c.id
+}
+
+var bibtexEscaper = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	"{", `\{`,
+	"}", `\}`,
+	"&", `\&`,
+	"%", `\%`,
+	"$", `\$`,
+	"#", `\#`,
+	"_", `\_`,
+	"~", `\textasciitilde{}`,
+	"^", `\textasciicircum{}`,
+)
+
+func (c citation) bibtex() string {
+	var authors []string
+	for _, a := range c.authors {
+		if a.given == "" {
+			authors = append(authors, bibtexEscaper.Replace(a.family))
+		} else {
+			authors = append(authors, bibtexEscaper.Replace(a.family+", "+a.given))
+		}
+	}
+
+	note := "AIMe report " + c.id
+	if c.pinned {
+		note += ", version " + strconv.Itoa(c.version)
+	}
+
+	var b strings.Builder
+	b.WriteString("@misc{" + c.key() + ",\n")
+	b.WriteString("  title = {{" + bibtexEscaper.Replace(c.title) + "}},\n")
+	if len(authors) > 0 {
+		b.WriteString("  author = {" + strings.Join(authors, " and ") + "},\n")
+	}
+	b.WriteString("  year = {" + strconv.Itoa(c.date.Year()) + "},\n")
+	b.WriteString("  month = {" + strings.ToLower(c.date.Month().String()[:3]) + "},\n")
+	b.WriteString("  publisher = {AIMe Registry},\n")
+	b.WriteString("  howpublished = {\\url{" + c.url + "}},\n")
+	b.WriteString("  url = {" + c.url + "},\n")
+	if c.pinned {
+		b.WriteString("  version = {" + strconv.Itoa(c.version) + "},\n")
+	}
+	if len(c.keywords) > 0 {
+		b.WriteString("  keywords = {" + bibtexEscaper.Replace(strings.Join(c.keywords, ", ")) + "},\n")
+	}
+	b.WriteString("  note = {" + note + "}\n")
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// ris writes the citation in the RIS format, whose lines end with CRLF.
+func (c citation) ris() string {
+	var b strings.Builder
+	line := func(tag string, val string) {
+		val = strings.Join(strings.Fields(val), " ")
+		if val != "" {
+			b.WriteString(tag + "  - " + val + "\r\n")
+		}
+	}
+
+	line("TY", "ELEC")
+	line("ID", c.key())
+	line("TI", c.title)
+	for _, a := range c.authors {
+		if a.given == "" {
+			line("AU", a.family)
+		} else {
+			line("AU", a.family+", "+a.given)
+		}
+	}
+	line("PY", strconv.Itoa(c.date.Year()))
+	line("DA", c.date.Format("2006/01/02"))
+	line("PB", "AIMe Registry")
+	line("UR", c.url)
+	if c.pinned {
+		line("ET", "Version "+strconv.Itoa(c.version))
+	}
+	line("AB", c.description)
+	for _, k := range c.keywords {
+		line("KW", k)
+	}
+	line("N1", "AIMe report "+c.id)
+	b.WriteString("ER  - \r\n")
+	return b.String()
+}
+
+type cslName struct {
+	Family string `json:"family"`
+	Given  string `json:"given,omitempty"`
+	ORCID  string `json:"ORCID,omitempty"`
+}
+
+type cslDate struct {
+	DateParts [][]int `json:"date-parts"`
+}
+
+type cslItem struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Title     string    `json:"title"`
+	Author    []cslName `json:"author,omitempty"`
+	Issued    cslDate   `json:"issued"`
+	Publisher string    `json:"publisher"`
+	Number    string    `json:"number"`
+	Version   string    `json:"version,omitempty"`
+	URL       string    `json:"URL"`
+	Abstract  string    `json:"abstract,omitempty"`
+	Keyword   string    `json:"keyword,omitempty"`
+}
+
+// csl returns the citation as CSL-JSON, which is a list of items.
+func (c citation) csl() []byte {
+	it := cslItem{
+		ID:        c.key(),
+		Type:      "report",
+		Title:     c.title,
+		Issued:    cslDate{[][]int{{c.date.Year(), int(c.date.Month()), c.date.Day()}}},
+		Publisher: "AIMe Registry",
+		Number:    c.id,
+		URL:       c.url,
+		Abstract:  c.description,
+		Keyword:   strings.Join(c.keywords, ", "),
+	}
+	if c.pinned {
+		it.Version = strconv.Itoa(c.version)
+	}
+	for _, a := range c.authors {
+		it.Author = append(it.Author, cslName{a.family, a.given, a.orcid})
+	}
+
+	cslBytes, _ := json.MarshalIndent([]cslItem{it}, "", "  ")
+	return cslBytes
+}
+
+type cffAuthor struct {
+	FamilyNames string `yaml:"family-names"`
+	GivenNames  string `yaml:"given-names,omitempty"`
+	Affiliation string `yaml:"affiliation,omitempty"`
+	Orcid       string `yaml:"orcid,omitempty"`
+}
+
+type cffCitation struct {
+	CffVersion   string      `yaml:"cff-version"`
+	Message      string      `yaml:"message"`
+	Type         string      `yaml:"type"`
+	Title        string      `yaml:"title"`
+	Authors      []cffAuthor `yaml:"authors"`
+	Version      string      `yaml:"version,omitempty"`
+	DateReleased string      `yaml:"date-released"`
+	URL          string      `yaml:"url"`
+	Abstract     string      `yaml:"abstract,omitempty"`
+	Keywords     []string    `yaml:"keywords,omitempty"`
+}
+
+// cff returns the citation as CITATION.cff file (Citation File Format 1.2.0).
+func (c citation) cff() []byte {
+	cf := cffCitation{
+		CffVersion:   "1.2.0",
+		Message:      "If you use this AI, please cite its AIMe report as below.",
+		Type:         "software",
+		Title:        c.title,
+		Authors:      []cffAuthor{},
+		DateReleased: c.date.Format("2006-01-02"),
+		URL:          c.url,
+		Abstract:     c.description,
+		Keywords:     c.keywords,
+	}
+	if c.pinned {
+		cf.Version = strconv.Itoa(c.version)
+	}
+	for _, a := range c.authors {
+		cf.Authors = append(cf.Authors, cffAuthor{a.family, a.given, a.affiliation, a.orcid})
+	}
+
+	cffBytes, _ := yaml.Marshal(cf)
+	return cffBytes
+}
+
+// citationFormats maps the format parameter to the content type and the file
+// extension.
+var citationFormats = map[string][2]string{
+	"bibtex": {"application/x-bibtex", "bib"},
+	"ris":    {"application/x-research-info-systems", "ris"},
+	"csl":    {"application/vnd.citationstyles.csl+json", "json"},
+	"cff":    {"application/x-yaml", "cff"},
+}
+
+func (c citation) format(format string) []byte {
+	switch format {
+	case "ris":
+		return []byte(c.ris())
+	case "csl":
+		return c.csl()
+	case "cff":
+		return c.cff()
+	default:
+		return []byte(c.bibtex())
+	}
+}
@@ -0,0 +1,100 @@
+package aime
+
+import (
+	"encoding/json"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSplitName(t *testing.T) {
+	if g, f := splitName("Jane  van Doe"); g != "Jane van" || f != "Doe" {
+		t.Fatal(g, f)
+	}
+	if g, f := splitName("Doe, Jane"); g != "Jane" || f != "Doe" {
+		t.Fatal(g, f)
+	}
+	if g, f := splitName("Plato"); g != "" || f != "Plato" {
+		t.Fatal(g, f)
+	}
+}
+
+func TestNormalizeOrcid(t *testing.T) {
+	if normalizeOrcid("0000-0002-1694-233x") != "https://orcid.org/0000-0002-1694-233X" {
+		t.Fatal()
+	}
+	if normalizeOrcid("https://orcid.org/0000-0002-1825-0097") != "https://orcid.org/0000-0002-1825-0097" {
+		t.Fatal()
+	}
+	if normalizeOrcid("none") != "" {
+		t.Fatal()
+	}
+}
+
+func TestServer_Cite(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	rp := db.CreateReport("", true)
+	jn := "{\"MD\":{\"1\":\"Heart_failure & ECG\",\"3\":\"Predicts heart failure\",\"6\":[" +
+		"{\"1\":\"Max Poe\"},{\"1\":\"Jane Doe\",\"2\":\"University\",\"4\":\"0000-0002-1825-0097\"},{\"1\":\"John Roe\"}]}}"
+	db.CreateRevision(rp.ID, "", json.RawMessage(jn), rp.Token, true)
+	db.CreateRevision(rp.ID, "", json.RawMessage(jn), rp.Token, true)
+
+	srv := Server{DB: db}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	get := func(path string) (int, string) {
+		resp, _ := http.Get(ts.URL + path)
+		respBytes, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(respBytes)
+	}
+
+	code, bib := get("/report/" + rp.ID + "/1/cite?format=bibtex")
+	if code != 200 {
+		t.Fatal(code)
+	}
+	if !strings.Contains(bib, "@misc{aime_"+rp.ID+"_v1,") || !strings.Contains(bib, `Heart\_failure \& ECG`) {
+		t.Fatal(bib)
+	}
+	if !strings.Contains(bib, "author = {Poe, Max and Doe, Jane and Roe, John}") || !strings.Contains(bib, "url = {"+reportURL+rp.ID+"/1}") {
+		t.Fatal(bib)
+	}
+
+	code, ris := get("/report/" + rp.ID + "/cite?format=ris")
+	if code != 200 || !strings.Contains(ris, "UR  - "+reportURL+rp.ID+"\r\n") || !strings.HasSuffix(ris, "ER  - \r\n") {
+		t.Fatal(ris)
+	}
+
+	code, csl := get("/report/" + rp.ID + "/2/cite?format=csl")
+	if code != 200 {
+		t.Fatal(code)
+	}
+	items := []cslItem{}
+	json.Unmarshal([]byte(csl), &items)
+	if len(items) != 1 || items[0].Version != "2" || items[0].Author[0].ORCID != "" || items[0].Author[1].ORCID != "https://orcid.org/0000-0002-1825-0097" {
+		t.Fatal(csl)
+	}
+
+	code, cff := get("/report/" + rp.ID + "/2/cite?format=cff")
+	if code != 200 {
+		t.Fatal(code)
+	}
+	cf := cffCitation{}
+	yaml.Unmarshal([]byte(cff), &cf)
+	if cf.CffVersion != "1.2.0" || cf.Version != "2" || len(cf.Authors) != 3 || cf.Authors[0].Affiliation != "" || cf.Authors[1].Affiliation != "University" {
+		t.Fatal(cff)
+	}
+
+	if code, _ := get("/report/" + rp.ID + "/1/cite?format=doc"); code != 400 {
+		t.Fatal(code)
+	}
+	if code, _ := get("/report/" + rp.ID + "/3/cite"); code != 404 {
+		t.Fatal(code)
+	}
+}
@@ -0,0 +1,419 @@
+package aime
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var ErrInvalidCondition = errors.New("invalid condition")
+
+// Conditions of the questionnaire are JavaScript expressions over the answer
+// of the enclosing question, "val". Only the subset used by questionnaires is
+// supported: property access (val['1'], ?.value, .length), comparisons,
+// literals, !, && and || as well as find and some with an arrow function, e.g.
+// "val['1'].find((o) => o.value === 'other')".
+
+type condToken struct {
+	kind  string // "op", "ident", "str", "num"
+	value string
+}
+
+func tokenizeCondition(src string) ([]condToken, error) {
+	var ts []condToken
+	ops := []string{"===", "!==", "==", "!=", ">=", "<=", "&&", "||", "?.", "=>", ">", "<", "!", "(", ")", "[", "]", "."}
+
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		if unicode.IsSpace(c) {
+			i++
+			continue
+		}
+
+		if c == '\'' || c == '"' {
+			j := i + 1
+			for j < len(src) && src[j] != src[i] {
+				j++
+			}
+			if j >= len(src) {
+				return nil, ErrInvalidCondition
+			}
+			ts = append(ts, condToken{"str", src[i+1 : j]})
+			i = j + 1
+			continue
+		}
+
+		if unicode.IsDigit(c) {
+			j := i
+			for j < len(src) && (unicode.IsDigit(rune(src[j])) || src[j] == '.') {
+				j++
+			}
+			ts = append(ts, condToken{"num", src[i:j]})
+			i = j
+			continue
+		}
+
+		if unicode.IsLetter(c) || c == '_' || c == '$' {
+			j := i
+			for j < len(src) && (unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j])) || src[j] == '_' || src[j] == '$') {
+				j++
+			}
+			ts = append(ts, condToken{"ident", src[i:j]})
+			i = j
+			continue
+		}
+
+		found := false
+		for _, op := range ops {
+			if strings.HasPrefix(src[i:], op) {
+				ts = append(ts, condToken{"op", op})
+				i += len(op)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, ErrInvalidCondition
+		}
+	}
+
+	return ts, nil
+}
+
+// condParser evaluates while parsing. Values are those of encoding/json, nil
+// stands for both null and undefined.
+type condParser struct {
+	tokens []condToken
+	pos    int
+	vars   map[string]interface{}
+}
+
+func (p *condParser) peek() condToken {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return condToken{}
+}
+
+func (p *condParser) accept(kind string, value string) bool {
+	t := p.peek()
+	if t.kind == kind && t.value == value {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *condParser) expect(kind string, value string) error {
+	if !p.accept(kind, value) {
+		return ErrInvalidCondition
+	}
+	return nil
+}
+
+func truthy(v interface{}) bool {
+	switch tv := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return tv
+	case float64:
+		return tv != 0
+	case string:
+		return tv != ""
+	}
+	return true
+}
+
+// strictEqual compares primitive values, objects and lists are never equal.
+func strictEqual(a, b interface{}) bool {
+	switch a.(type) {
+	case nil, bool, float64, string:
+		return a == b
+	}
+	return false
+}
+
+func (p *condParser) parseOr() (interface{}, error) {
+	v, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("op", "||") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if !truthy(v) {
+			v = r
+		}
+	}
+	return v, nil
+}
+
+func (p *condParser) parseAnd() (interface{}, error) {
+	v, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("op", "&&") {
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if truthy(v) {
+			v = r
+		}
+	}
+	return v, nil
+}
+
+func (p *condParser) parseUnary() (interface{}, error) {
+	if p.accept("op", "!") {
+		v, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return !truthy(v), nil
+	}
+	return p.parseComparison()
+}
+
+func (p *condParser) parseComparison() (interface{}, error) {
+	l, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	if t.kind != "op" {
+		return l, nil
+	}
+	switch t.value {
+	case "===", "==", "!==", "!=", ">", "<", ">=", "<=":
+		p.pos++
+	default:
+		return l, nil
+	}
+
+	r, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t.value {
+	case "===", "==":
+		return strictEqual(l, r), nil
+	case "!==", "!=":
+		return !strictEqual(l, r), nil
+	}
+
+	lf, lok := l.(float64)
+	rf, rok := r.(float64)
+	if !lok || !rok {
+		return false, nil
+	}
+	switch t.value {
+	case ">":
+		return lf > rf, nil
+	case "<":
+		return lf < rf, nil
+	case ">=":
+		return lf >= rf, nil
+	}
+	return lf <= rf, nil
+}
+
+func (p *condParser) parsePrimary() (interface{}, error) {
+	t := p.peek()
+
+	var v interface{}
+	switch {
+	case p.accept("op", "("):
+		var err error
+		v, err = p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("op", ")"); err != nil {
+			return nil, err
+		}
+	case t.kind == "str":
+		p.pos++
+		v = t.value
+	case t.kind == "num":
+		p.pos++
+		f, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, ErrInvalidCondition
+		}
+		v = f
+	case t.kind == "ident" && (t.value == "true" || t.value == "false"):
+		p.pos++
+		v = t.value == "true"
+	case t.kind == "ident" && (t.value == "null" || t.value == "undefined"):
+		p.pos++
+		v = nil
+	case t.kind == "ident":
+		p.pos++
+		var ok bool
+		v, ok = p.vars[t.value]
+		if !ok {
+			return nil, ErrInvalidCondition
+		}
+	default:
+		return nil, ErrInvalidCondition
+	}
+
+	return p.parseAccessors(v)
+}
+
+func property(v interface{}, name string) interface{} {
+	switch tv := v.(type) {
+	case map[string]interface{}:
+		return tv[name]
+	case []interface{}:
+		if name == "length" {
+			return float64(len(tv))
+		}
+		if i, err := strconv.Atoi(name); err == nil && i >= 0 && i < len(tv) {
+			return tv[i]
+		}
+	case string:
+		if name == "length" {
+			return float64(len([]rune(tv)))
+		}
+	}
+	return nil
+}
+
+func (p *condParser) parseAccessors(v interface{}) (interface{}, error) {
+	for {
+		switch {
+		case p.accept("op", "["):
+			t := p.peek()
+			if t.kind != "str" && t.kind != "num" {
+				return nil, ErrInvalidCondition
+			}
+			p.pos++
+			if err := p.expect("op", "]"); err != nil {
+				return nil, err
+			}
+			v = property(v, t.value)
+		case p.accept("op", "."), p.accept("op", "?."):
+			t := p.peek()
+			if t.kind != "ident" {
+				return nil, ErrInvalidCondition
+			}
+			p.pos++
+			if t.value == "find" || t.value == "some" {
+				var err error
+				v, err = p.parseCallback(v, t.value)
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				v = property(v, t.value)
+			}
+		default:
+			return v, nil
+		}
+	}
+}
+
+// parseCallback evaluates find or some with an arrow function "(o) => expr"
+// or "o => expr" for every element of the list.
+func (p *condParser) parseCallback(v interface{}, method string) (interface{}, error) {
+	if err := p.expect("op", "("); err != nil {
+		return nil, err
+	}
+	paren := p.accept("op", "(")
+	param := p.peek()
+	if param.kind != "ident" {
+		return nil, ErrInvalidCondition
+	}
+	p.pos++
+	if paren {
+		if err := p.expect("op", ")"); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect("op", "=>"); err != nil {
+		return nil, err
+	}
+
+	start := p.pos
+	list, _ := v.([]interface{})
+
+	// Parse the body once without elements to find its end
+	outer, hadOuter := p.vars[param.value]
+	p.vars[param.value] = nil
+	if _, err := p.parseOr(); err != nil {
+		return nil, err
+	}
+	end := p.pos
+
+	var res interface{}
+	if method == "some" {
+		res = false
+	}
+	for _, e := range list {
+		p.vars[param.value] = e
+		p.pos = start
+		r, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if truthy(r) {
+			if method == "some" {
+				res = true
+			} else {
+				res = e
+			}
+			break
+		}
+	}
+	p.pos = end
+
+	if hadOuter {
+		p.vars[param.value] = outer
+	} else {
+		delete(p.vars, param.value)
+	}
+
+	if err := p.expect("op", ")"); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// evalCondition reports whether a question with the condition is shown for
+// the answer of the enclosing question.
+func evalCondition(cond string, val interface{}) (bool, error) {
+	ts, err := tokenizeCondition(cond)
+	if err != nil {
+		return false, err
+	}
+
+	p := &condParser{tokens: ts, vars: map[string]interface{}{"val": val}}
+	v, err := p.parseOr()
+	if err != nil {
+		return false, err
+	}
+	if p.pos != len(ts) {
+		return false, ErrInvalidCondition
+	}
+
+	return truthy(v), nil
+}
+
+// shown reports whether a question is shown. Questions with conditions that
+// cannot be evaluated are shown.
+func (q Question) shown(val interface{}) bool {
+	if q.Condition == "" {
+		return true
+	}
+	ok, err := evalCondition(q.Condition, val)
+	return ok || err != nil
+}
@@ -0,0 +1,67 @@
+package aime
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEvalCondition(t *testing.T) {
+	var val interface{}
+	json.Unmarshal([]byte("{\"1\":{\"custom\":false,\"value\":\"yes\"},\"2\":[{\"custom\":false,\"value\":\"a\"},{\"custom\":true,\"value\":\"other\"}],\"3\":true}"), &val)
+
+	te := []struct {
+		cond string
+		res  bool
+	}{
+		{"val['3'] === true", true},
+		{"val['1']?.value === 'yes'", true},
+		{"val['1'].value === 'no'", false},
+		{"val['2'].length > 0", true},
+		{"val['2'].find((o) => o.value === 'other')", true},
+		{"val['2'].find(o => o.value === 'b')", false},
+		{"val['1'].value === 'yes' && val['2'].find((o) => o.value === 'other')", true},
+		{"val['4']?.value === 'yes' || !val['3']", false},
+		{"val['4'].find((o) => o.value === 'other')", false},
+		{"val['2'].some((o) => o.custom)", true},
+	}
+	for _, e := range te {
+		res, err := evalCondition(e.cond, val)
+		if err != nil || res != e.res {
+			t.Fatal(e.cond, res, err)
+		}
+	}
+
+	if _, err := evalCondition("val['1'] = 1", val); err == nil {
+		t.Fatal()
+	}
+	if _, err := evalCondition("window.alert('x')", val); err == nil {
+		t.Fatal()
+	}
+}
+
+func TestQuestion_shown(t *testing.T) {
+	q := LoadQuestions("../../questionnaire.yaml")
+
+	var n int
+	var walk func(q Question)
+	walk = func(q Question) {
+		if q.Condition != "" {
+			if _, err := evalCondition(q.Condition, map[string]interface{}{}); err != nil {
+				t.Fatal(q.Condition, err)
+			}
+			n++
+		}
+		for _, ch := range q.Children {
+			walk(ch)
+		}
+		if q.Child != nil {
+			walk(*q.Child)
+		}
+	}
+	walk(q)
+
+	// All conditions of the questionnaire are supported
+	if n == 0 {
+		t.Fatal()
+	}
+}
@@ -3,36 +3,54 @@ package aime
 import (
 	"encoding/json"
 	"fmt"
-	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
-type keyword struct {
-	keyword string
-	reports []string
-}
-
-type category struct {
-	category string
-	reports  []string
-}
-
 type DB struct {
-	Dir           string
-	KeywordGroups KeywordGroups
+	Dir               string
+	KeywordGroups     KeywordGroups
+	KeywordGroupsFile string
+	Settings          Settings
+	Taxonomy          *Taxonomy
+	// ConfigFiles are included in backups
+	ConfigFiles []string
 
 	questions Question
 
-	keywordSet  map[string]*keyword
-	categorySet map[string]*category
-	mutex       sync.Mutex
+	indexes map[string]*index
+	mutex   sync.Mutex
+
+	similarity      *similarityIndex
+	similarityMutex sync.Mutex
+
+	// keywordGroupsMutex serializes changes of the keyword groups,
+	// keywordGroupsWritten is what they last wrote to KeywordGroupsFile
+	keywordGroupsMutex   sync.Mutex
+	keywordGroupsWritten []byte
+
+	webhookMutex sync.Mutex
+	webhooks     webhookQueue
+
+	// issueMutex serializes the changes of issues
+	issueMutex sync.Mutex
+
+	// writeMutex is held for reading by the writers of reports, issues,
+	// documents and webhooks, and for writing while a backup copies the DB.
+	// It is taken before issueMutex and webhookMutex.
+	writeMutex sync.RWMutex
+
+	broker eventBroker
+
+	changeMutex     sync.Mutex
+	changeSeq       int64
+	changeSeqLoaded bool
+	changeSignal    chan struct{}
 }
 
 type Report struct {
@@ -93,6 +111,9 @@ type Issue struct {
 	Verified bool `json:"confirmed"`
 	Deleted  bool `json:"-"`
 
+	// PublishedAt is set once the issue is no longer pending
+	PublishedAt time.Time `json:"-"`
+
 	Email string `json:"-"`
 	Token string `json:"-"`
 }
@@ -114,6 +135,8 @@ type UnsafeIssue struct {
 	Verified bool `json:"verified"`
 	Deleted  bool `json:"deleted"`
 
+	PublishedAt time.Time `json:"publishedAt"`
+
 	Email string `json:"email"`
 	Token string `json:"token"`
 }
@@ -123,9 +146,11 @@ var _ = Issue(UnsafeIssue{})
 var _ = UnsafeIssue(Issue{})
 
 type KeywordGroup struct {
-	Sources       []string `yaml:"sources"`
-	Targets       []string `yaml:"targets"`
-	CaseSensitive bool     `yaml:"caseSensitive"`
+	// ID stays the same when other groups are added or deleted
+	ID            int      `yaml:"id,omitempty" json:"-"`
+	Sources       []string `yaml:"sources" json:"sources"`
+	Targets       []string `yaml:"targets" json:"targets"`
+	CaseSensitive bool     `yaml:"caseSensitive" json:"caseSensitive"`
 }
 
 type KeywordGroups []KeywordGroup
@@ -162,6 +187,9 @@ func (db *DB) Delete() {
 // Report
 
 func (db *DB) CreateReport(email string, public bool) *Report {
+	db.writeMutex.RLock()
+	defer db.writeMutex.RUnlock()
+
 	id := generateRandomString(6)
 
 	rpPath := db.reportPath(id)
@@ -179,6 +207,8 @@ func (db *DB) CreateReport(email string, public bool) *Report {
 
 	db.SetReport(*rp)
 
+	db.recordChange(Change{Type: EventReportCreated, ReportID: id})
+
 	return rp
 }
 
@@ -198,7 +228,7 @@ func (db *DB) GetReport(id string) *Report {
 		return nil
 	}
 	rp := UnsafeReport{}
-	err = json.Unmarshal(rpBytes, &rp)
+	err = unmarshalDocument(SchemaReport, rpBytes, &rp)
 	if err != nil {
 		return nil
 	}
@@ -211,12 +241,29 @@ func (db *DB) SetReport(rp Report) {
 		panic("no token")
 	}
 
-	rpBytes, _ := json.Marshal(UnsafeReport(rp))
+	rpBytes := marshalDocument(SchemaReport, UnsafeReport(rp))
 	ioutil.WriteFile(filepath.Join(db.reportPath(rp.ID), "report.json"), rpBytes, os.ModePerm)
 }
 
 func (db *DB) DeleteReport(id string) {
+	db.writeMutex.RLock()
+	defer db.writeMutex.RUnlock()
+
+	db.deleteReport(id)
+}
+
+// deleteReport deletes a report. The caller holds writeMutex.
+func (db *DB) deleteReport(id string) {
+	public := false
+	if rp := db.GetReport(id); rp != nil {
+		public = rp.Public
+	}
+
 	os.RemoveAll(db.reportPath(id))
+
+	db.deleteSimilarity(id)
+
+	db.recordChange(Change{Type: ChangeReportDeleted, ReportID: id, Public: &public})
 }
 
 // Search
@@ -277,11 +324,20 @@ func clean(w string) string {
 	return strings.Join(ws2, " ")
 }
 
+// transform replaces the sources of the keyword groups by their targets. The
+// values keep the order in which they first appear.
 func (kwg KeywordGroups) transform(sources []string) []string {
 	if kwg == nil {
 		return sources
 	}
 	targetMap := map[string]bool{}
+	targets := []string{}
+	add := func(t string) {
+		if !targetMap[t] {
+			targetMap[t] = true
+			targets = append(targets, t)
+		}
+	}
 	for _, s1 := range sources {
 		replaced := false
 		for _, g := range kwg {
@@ -295,74 +351,25 @@ func (kwg KeywordGroups) transform(sources []string) []string {
 				}
 				if s1i == s2 {
 					for _, t := range g.Targets {
-						targetMap[t] = true
+						add(t)
 					}
 					replaced = true
 				}
 			}
 		}
 		if !replaced {
-			targetMap[clean(s1)] = true
+			add(clean(s1))
 		}
 	}
-	targets := []string{}
-	for t := range targetMap {
-		targets = append(targets, t)
-	}
 	return targets
 }
 
-func (db *DB) BuildKeywordList(fk []string, fc []string) (int, int) {
-	db.mutex.Lock()
-
-	c := db.GetLatestRevisions(false)
-
-	db.keywordSet = map[string]*keyword{}
-	db.categorySet = map[string]*category{}
-
-	for rev := range c {
-		ks := db.KeywordGroups.transform(ExtractFields(db.questions, rev.Answers, fk))
-		for _, kw := range ks {
-			if kp, ok := db.keywordSet[kw]; ok {
-				kp.reports = append(kp.reports, rev.ReportID)
-				db.keywordSet[kw] = kp
-			} else {
-				db.keywordSet[kw] = &keyword{
-					keyword: kw,
-					reports: []string{rev.ReportID},
-				}
-			}
-		}
-		c := ExtractField(db.questions, rev.Answers, fc)
-		if c != "" {
-			if kp, ok := db.categorySet[c]; ok {
-				kp.reports = append(kp.reports, rev.ReportID)
-				db.categorySet[c] = kp
-			} else {
-				db.categorySet[c] = &category{
-					category: c,
-					reports:  []string{rev.ReportID},
-				}
-			}
-		}
-	}
-
-	db.mutex.Unlock()
-
-	return len(db.keywordSet), len(db.categorySet)
-}
-
-func (db *DB) GetKeyword(k string) *keyword {
-	return db.keywordSet[k]
-}
-
-func (db *DB) GetCategory(k string) *category {
-	return db.categorySet[k]
-}
-
 // Revision
 
 func (db *DB) CreateRevision(id string, email string, answers json.RawMessage, password string, public bool) *Revision {
+	db.writeMutex.RLock()
+	defer db.writeMutex.RUnlock()
+
 	rp := db.GetReport(id)
 	if rp == nil {
 		return nil
@@ -386,40 +393,30 @@ func (db *DB) CreateRevision(id string, email string, answers json.RawMessage, p
 		Public:    public,
 	}
 
-	revBytes, _ := json.Marshal(rev)
+	revBytes := marshalDocument(SchemaRevision, rev)
 
 	ioutil.WriteFile(revPath, revBytes, os.ModePerm)
 
+	visibilityChanged := ver > 1 && rp.Public != public
+
 	rp.Email = email
 	rp.Revisions = ver
 	rp.Public = public
 
 	db.SetReport(*rp)
 
-	go db.BuildKeywordList([]string{"MD", "5"}, []string{"P", "3", "1"})
-
-	return rev
-}
-
-func ReadKeywordGroups(filename string) KeywordGroups {
-	var kwg KeywordGroups
+	go db.BuildIndexes()
 
-	kwgBytes, _ := ioutil.ReadFile(filename)
+	db.updateSimilarity(rev)
 
-	yaml.Unmarshal(kwgBytes, &kwg)
+	db.recordChange(Change{Type: EventRevisionCreated, ReportID: id, Version: ver})
+	if visibilityChanged {
+		db.recordChange(Change{Type: ChangeVisibility, ReportID: id, Version: ver, Public: &public})
+	}
 
-	return kwg
-}
+	db.publish(reportTopic(id), strconv.Itoa(ver), "revision", newReportEvent(*rp, *rev))
 
-func (db *DB) GetKeywords() []*keyword {
-	var k []*keyword
-	for _, kw := range db.keywordSet {
-		k = append(k, kw)
-	}
-	sort.Slice(k, func(i, j int) bool {
-		return len(k[j].reports) < len(k[i].reports)
-	})
-	return k
+	return rev
 }
 
 func (db *DB) ExistsRevision(id string, ver int) bool {
@@ -433,7 +430,7 @@ func (db *DB) GetRevision(id string, ver int) *Revision {
 		return nil
 	}
 	rev := &Revision{}
-	err = json.Unmarshal(revBytes, &rev)
+	err = unmarshalDocument(SchemaRevision, revBytes, rev)
 	if err != nil {
 		return nil
 	}
@@ -459,6 +456,9 @@ func (db *DB) ReadDocument(fileName string) []byte {
 }
 
 func (db *DB) UploadDocument(fileBytes []byte) string {
+	db.writeMutex.RLock()
+	defer db.writeMutex.RUnlock()
+
 	fileName := generateRandomString(12) + ".pdf"
 	_ = ioutil.WriteFile(db.documentPath(fileName), fileBytes, os.ModePerm)
 	return fileName
@@ -467,6 +467,11 @@ func (db *DB) UploadDocument(fileBytes []byte) string {
 // Issue
 
 func (db *DB) CreateIssue(id string, name string, email string, field []string, content string, cType int) *Issue {
+	db.writeMutex.RLock()
+	defer db.writeMutex.RUnlock()
+	db.issueMutex.Lock()
+	defer db.issueMutex.Unlock()
+
 	rep := db.GetReport(id)
 	if rep == nil {
 		return nil
@@ -497,10 +502,17 @@ func (db *DB) CreateIssue(id string, name string, email string, field []string,
 
 	db.SetReport(*rep)
 
+	db.recordChange(Change{Type: EventIssueCreated, ReportID: id, IssueID: cid})
+
 	return &c
 }
 
 func (db *DB) ValidateIssue(id string, comment int, token string) bool {
+	db.writeMutex.RLock()
+	defer db.writeMutex.RUnlock()
+	db.issueMutex.Lock()
+	defer db.issueMutex.Unlock()
+
 	c := db.GetIssue(id, comment)
 	if c == nil {
 		return false
@@ -514,10 +526,19 @@ func (db *DB) ValidateIssue(id string, comment int, token string) bool {
 
 	db.SetIssue(*c)
 
+	db.publishStatus(*c)
+
+	db.recordChange(Change{Type: EventIssueConfirmed, ReportID: id, IssueID: comment})
+
 	return true
 }
 
 func (db *DB) CreateAnswer(id string, comment int, content string, token string) *Answer {
+	db.writeMutex.RLock()
+	defer db.writeMutex.RUnlock()
+	db.issueMutex.Lock()
+	defer db.issueMutex.Unlock()
+
 	rep := db.GetReport(id)
 	if rep == nil {
 		return nil
@@ -549,6 +570,10 @@ func (db *DB) CreateAnswer(id string, comment int, content string, token string)
 
 	db.SetIssue(*c)
 
+	db.publish(issueTopic(id, comment), strconv.Itoa(a.ID), "answer", a)
+
+	db.recordChange(Change{Type: EventAnswerCreated, ReportID: id, IssueID: comment, AnswerID: a.ID})
+
 	return &a
 }
 
@@ -563,7 +588,7 @@ func (db *DB) GetIssue(id string, comment int) *Issue {
 		return nil
 	}
 	c := UnsafeIssue{}
-	err = json.Unmarshal(comBytes, &c)
+	err = unmarshalDocument(SchemaIssue, comBytes, &c)
 	if err != nil {
 		return nil
 	}
@@ -571,8 +596,10 @@ func (db *DB) GetIssue(id string, comment int) *Issue {
 	return &sc
 }
 
+// SetIssue writes an issue. Callers that change a stored issue hold issueMutex
+// from reading to writing it.
 func (db *DB) SetIssue(c Issue) {
-	comBytes, _ := json.Marshal(UnsafeIssue(c))
+	comBytes := marshalDocument(SchemaIssue, UnsafeIssue(c))
 	ioutil.WriteFile(db.commentFilePath(c.ReportID, c.ID), comBytes, os.ModePerm)
 }
 
@@ -610,6 +637,9 @@ func (db *DB) GetReportIssues(reportID string, includePending bool) chan *Issue
 // Join consortium
 
 func (db *DB) AddContribution(answers []byte) {
+	db.writeMutex.RLock()
+	defer db.writeMutex.RUnlock()
+
 	os.MkdirAll(db.contributionPath(), os.ModePerm)
 	ioutil.WriteFile(db.contributionFilePath(), answers, os.ModePerm)
 }
@@ -632,6 +662,10 @@ func (db *DB) revisionFilePath(id string, ver int) string {
 	return filepath.Join(db.revisionPath(id), fmt.Sprintf("%04d.json", ver))
 }
 
+func (db *DB) pdfFilePath(id string, ver int) string {
+	return filepath.Join(db.Dir, "reports", id, "pdf", fmt.Sprintf("%04d.pdf", ver))
+}
+
 func (db *DB) commentPath(id string) string {
 	return filepath.Join(db.Dir, "reports", id, "comments")
 }
@@ -3,6 +3,7 @@ package aime
 import (
 	"encoding/json"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -202,12 +203,12 @@ func TestDB_Keywords(t *testing.T) {
 		db.CreateRevision(r.ID, "", json.RawMessage(jn), r.Token, t.public)
 	}
 
-	kw, ct := db.BuildKeywordList([]string{"MD", "5"}, []string{"P", "3", "1"})
+	counts := db.BuildIndexes()
 
-	if kw != 4 {
+	if counts["keywords"] != 4 {
 		t.Fatal()
 	}
-	if ct != 2 {
+	if counts["categories"] != 2 {
 		t.Fatal()
 	}
 
@@ -238,6 +239,48 @@ func TestDB_Keywords(t *testing.T) {
 	}
 }
 
+func TestDB_Categories(t *testing.T) {
+	db := DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	te := []struct {
+		category string
+		custom   string
+	}{
+		{"cf", ""},
+		{"cf", ""},
+		{"other", "Survival  analysis"},
+		{"other", "survival analysis "},
+		{"other", ""},
+	}
+
+	for _, t := range te {
+		jn := "{\"P\":{\"3\":{\"1\":{\"custom\":false,\"value\":\"" + t.category + "\"},\"2\":\"" + t.custom + "\"}}}"
+		r := db.CreateReport("", true)
+		db.CreateRevision(r.ID, "", json.RawMessage(jn), r.Token, true)
+	}
+
+	if ct := db.BuildIndexes()["categories"]; ct != 3 {
+		t.Fatal(ct)
+	}
+
+	if len(db.GetCategory("survival analysis").reports) != 2 {
+		t.Fatal()
+	}
+	if len(db.GetCategory("Other").reports) != 1 {
+		t.Fatal()
+	}
+
+	cs := db.GetCategories()
+	if cs[0].value != "Classification" && cs[0].value != "survival analysis" {
+		t.Fatal()
+	}
+	if cs[2].value != "Other" {
+		t.Fatal()
+	}
+}
+
 func TestDB_Comments(t *testing.T) {
 	db := DB{Dir: "./test"}
 	db.Create("../../questionnaire.yaml")
@@ -385,6 +428,26 @@ func TestDB_Answers(t *testing.T) {
 	if len(rcs) != 1 {
 		t.Fatal()
 	}
+
+	// Concurrent answers and publication do not overwrite each other
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			db.CreateAnswer(rep1.ID, c1.ID, "Another answer", c1.Token)
+		}()
+		go func() {
+			defer wg.Done()
+			db.PublishIssue(rep1.ID, c1.ID)
+		}()
+	}
+	wg.Wait()
+
+	c1 = db.GetIssue(rep1.ID, c1.ID)
+	if len(c1.Answers) != 12 || c1.PublishedAt.IsZero() {
+		t.Fatal(len(c1.Answers))
+	}
 }
 
 func TestKeywordGroups_transform_1(t *testing.T) {
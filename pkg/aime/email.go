@@ -4,12 +4,19 @@ import (
 	"bytes"
 	"crypto/tls"
 	"gopkg.in/gomail.v2"
+	"io"
 	"io/ioutil"
 	"path/filepath"
 	"strings"
 	"text/template"
 )
 
+// mailAttachment is a file attached to a mail.
+type mailAttachment struct {
+	name    string
+	content []byte
+}
+
 type emailSender struct {
 	createReport   *template.Template
 	createRevision *template.Template
@@ -166,14 +173,14 @@ func (e *emailSender) createAnswerMail(report Report, issue Issue, answer Answer
 	return string(buf.Bytes())
 }
 
-func (e *emailSender) SendReportMail(report Report) error {
+func (e *emailSender) SendReportMail(report Report, attachments ...mailAttachment) error {
 	txt := e.createReportMail(report)
-	return e.SendMail(report.Email, "Your AIMe report", txt)
+	return e.SendMail(report.Email, "Your AIMe report", txt, attachments...)
 }
 
-func (e *emailSender) SendRevisionMail(report Report, revision Revision) error {
+func (e *emailSender) SendRevisionMail(report Report, revision Revision, attachments ...mailAttachment) error {
 	txt := e.createRevisionMail(report, revision)
-	return e.SendMail(report.Email, "New revision of your AIMe report", txt)
+	return e.SendMail(report.Email, "New revision of your AIMe report", txt, attachments...)
 }
 
 func (e *emailSender) SendIssueConfirmationMail(report Report, issue Issue) error {
@@ -197,7 +204,7 @@ func (e *emailSender) SendAnswerMail(report Report, issue Issue, answer Answer)
 	return e.SendMail(to, "New response in AIMe report issue", txt)
 }
 
-func (e *emailSender) SendMail(to, subject, content string) error {
+func newMail(to, subject, content string, attachments ...mailAttachment) *gomail.Message {
 	m := gomail.NewMessage()
 	m.SetHeader("From", "\"AIMe Registry\" <info@aime-registry.org>")
 	m.SetHeader("To", to)
@@ -205,5 +212,17 @@ func (e *emailSender) SendMail(to, subject, content string) error {
 
 	m.SetBody("text/plain", content)
 
-	return e.dialer.DialAndSend(m)
+	for _, a := range attachments {
+		content := a.content
+		m.Attach(a.name, gomail.SetCopyFunc(func(w io.Writer) error {
+			_, err := w.Write(content)
+			return err
+		}))
+	}
+
+	return m
+}
+
+func (e *emailSender) SendMail(to, subject, content string, attachments ...mailAttachment) error {
+	return e.dialer.DialAndSend(newMail(to, subject, content, attachments...))
 }
@@ -0,0 +1,130 @@
+package aime
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Live events are published to the subscribers of a topic, which are the
+// clients connected to the event streams of a report or an issue.
+
+// sseKeepAlive is the interval of comments that keep idle streams open.
+var sseKeepAlive = 30 * time.Second
+
+type streamEvent struct {
+	id   string
+	name string
+	data []byte
+}
+
+type eventBroker struct {
+	mutex       sync.Mutex
+	subscribers map[string]map[chan streamEvent]bool
+}
+
+func reportTopic(id string) string {
+	return "report/" + id
+}
+
+func issueTopic(id string, issue int) string {
+	return "report/" + id + "/issue/" + strconv.Itoa(issue)
+}
+
+// subscribe returns a channel of the events of the topic and the function to
+// unsubscribe.
+func (db *DB) subscribe(topic string) (chan streamEvent, func()) {
+	b := &db.broker
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.subscribers == nil {
+		b.subscribers = map[string]map[chan streamEvent]bool{}
+	}
+	if b.subscribers[topic] == nil {
+		b.subscribers[topic] = map[chan streamEvent]bool{}
+	}
+	ch := make(chan streamEvent, 16)
+	b.subscribers[topic][ch] = true
+
+	return ch, func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+
+		delete(b.subscribers[topic], ch)
+		if len(b.subscribers[topic]) == 0 {
+			delete(b.subscribers, topic)
+		}
+	}
+}
+
+// publish sends an event to all subscribers of the topic. Subscribers that do
+// not keep up miss events rather than blocking the publisher.
+func (db *DB) publish(topic string, id string, name string, data interface{}) {
+	b := &db.broker
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.subscribers[topic]) == 0 {
+		return
+	}
+
+	dataBytes, _ := json.Marshal(data)
+	for ch := range b.subscribers[topic] {
+		select {
+		case ch <- streamEvent{id, name, dataBytes}:
+		default:
+		}
+	}
+}
+
+func (db *DB) publishStatus(iss Issue) {
+	db.publish(issueTopic(iss.ReportID, iss.ID), "", "status", IssueStatusEvent{
+		Verified:     iss.Verified,
+		Pending:      iss.Pending(),
+		PendingUntil: iss.VerifiedAt.Add(pendingTime),
+	})
+}
+
+// serveEvents streams the events of a topic as Server-Sent Events until the
+// client disconnects.
+func (s *Server) serveEvents(w http.ResponseWriter, topic string) {
+	events, unsubscribe := s.DB.subscribe(topic)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	st, err := openStream(w, 200)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	defer st.Close()
+
+	fmt.Fprint(st, ": connected\n\n")
+	st.Flush()
+
+	t := time.NewTicker(sseKeepAlive)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-st.Done():
+			return
+		case <-t.C:
+			fmt.Fprint(st, ": keep-alive\n\n")
+		case e := <-events:
+			if e.id != "" {
+				fmt.Fprintf(st, "id: %s\n", e.id)
+			}
+			fmt.Fprintf(st, "event: %s\ndata: %s\n\n", e.name, e.data)
+		}
+		if st.Flush() != nil {
+			return
+		}
+	}
+}
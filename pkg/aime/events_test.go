@@ -0,0 +1,161 @@
+package aime
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvent reads the next event of a stream, skipping comments.
+func readEvent(t *testing.T, br *bufio.Reader) (string, string) {
+	var name, data string
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "" && name != "":
+			return name, data
+		case strings.HasPrefix(line, "event: "):
+			name = line[7:]
+		case strings.HasPrefix(line, "data: "):
+			data = line[6:]
+		}
+	}
+}
+
+func getEvents(t *testing.T, url string) (*http.Response, *bufio.Reader) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatal(resp.StatusCode)
+	}
+	br := bufio.NewReader(resp.Body)
+	// Subscribed once the first comment arrives
+	if line, _ := br.ReadString('\n'); line != ": connected\n" {
+		t.Fatal(line)
+	}
+	br.ReadString('\n')
+	return resp, br
+}
+
+func TestServer_IssueEvents(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	rp := db.CreateReport("", true)
+	db.CreateRevision(rp.ID, "", json.RawMessage("{}"), rp.Token, true)
+	iss := db.CreateIssue(rp.ID, "Jane", "jane@test.de", []string{"MD"}, "Question", 0)
+
+	srv := Server{DB: db}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	path := ts.URL + "/report/" + rp.ID + "/issue/" + strconv.Itoa(iss.ID) + "/events"
+
+	// Pending issues are only streamed with a password
+	if resp, _ := http.Get(path); resp.StatusCode != 403 {
+		t.Fatal(resp.StatusCode)
+	}
+	if resp, _ := http.Get(path + "?p=wrong"); resp.StatusCode != 403 {
+		t.Fatal(resp.StatusCode)
+	}
+
+	resp, br := getEvents(t, path+"?p="+iss.Token)
+	defer resp.Body.Close()
+
+	db.ValidateIssue(rp.ID, iss.ID, iss.Token)
+	if name, data := readEvent(t, br); name != "status" || !strings.Contains(data, `"confirmed":true,"pending":true`) {
+		t.Fatal(name, data)
+	}
+
+	db.CreateAnswer(rp.ID, iss.ID, "Answer", rp.Token)
+	name, data := readEvent(t, br)
+	a := Answer{}
+	json.Unmarshal([]byte(data), &a)
+	if name != "answer" || a.Content != "Answer" || !a.Owner {
+		t.Fatal(name, data)
+	}
+}
+
+func TestServer_ReportEvents(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	rp := db.CreateReport("", true)
+	db.CreateRevision(rp.ID, "", json.RawMessage("{}"), rp.Token, true)
+
+	srv := Server{DB: db}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	if resp, _ := http.Get(ts.URL + "/report/nope/events"); resp.StatusCode != 404 {
+		t.Fatal(resp.StatusCode)
+	}
+
+	resp, br := getEvents(t, ts.URL+"/report/"+rp.ID+"/events")
+	defer resp.Body.Close()
+
+	db.CreateRevision(rp.ID, "", json.RawMessage("{}"), rp.Token, false)
+	name, data := readEvent(t, br)
+	ev := ReportEvent{}
+	json.Unmarshal([]byte(data), &ev)
+	if name != "revision" || ev.Version != 2 || ev.Public {
+		t.Fatal(name, data)
+	}
+}
+
+func TestServer_StreamsOutliveWriteTimeout(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	rp := db.CreateReport("", true)
+	db.CreateRevision(rp.ID, "", json.RawMessage("{}"), rp.Token, true)
+
+	timeout := writeTimeout
+	writeTimeout = 100 * time.Millisecond
+	defer func() { writeTimeout = timeout }()
+
+	// The server is configured as by Start
+	srv := Server{DB: db}
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = srv.httpServer()
+	ts.Start()
+	defer ts.Close()
+
+	if resp, _ := http.Get(ts.URL + "/report/" + rp.ID); resp.StatusCode != 200 {
+		t.Fatal(resp.StatusCode)
+	}
+
+	resp, br := getEvents(t, ts.URL+"/report/"+rp.ID+"/events")
+	defer resp.Body.Close()
+
+	time.Sleep(3 * writeTimeout)
+	db.CreateRevision(rp.ID, "", json.RawMessage("{}"), rp.Token, true)
+	if name, _ := readEvent(t, br); name != "revision" {
+		t.Fatal(name)
+	}
+
+	// Long-polls outlive it as well
+	last := strconv.FormatInt(db.LastChange(), 10)
+	cresp, err := http.Get(ts.URL + "/changes?since=" + last + "&wait=1")
+	if err != nil || cresp.StatusCode != 200 {
+		t.Fatal(err)
+	}
+	cr := ChangesResponse{}
+	if err := json.NewDecoder(cresp.Body).Decode(&cr); err != nil || cr.Last != db.LastChange() {
+		t.Fatal(err, cr)
+	}
+}
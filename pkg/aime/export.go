@@ -0,0 +1,175 @@
+package aime
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportRecord is a line of the JSONL export.
+type ExportRecord struct {
+	ID        string          `json:"id"`
+	Version   int             `json:"version"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Answers   json.RawMessage `json:"answers"`
+}
+
+// GetPublicRevisions returns the public latest revisions or, with all, every
+// public revision of the reports whose latest revision is public. Hidden
+// reports are left out entirely, including their earlier public revisions.
+func (db *DB) GetPublicRevisions(all bool) chan *Revision {
+	if !all {
+		return db.GetLatestRevisions(false)
+	}
+
+	r := make(chan *Revision)
+	go func(r chan *Revision) {
+		defer close(r)
+		files, err := ioutil.ReadDir(filepath.Join(db.Dir, "reports"))
+		if err != nil {
+			return
+		}
+		for _, f := range files {
+			if !f.IsDir() {
+				continue
+			}
+			rp := db.GetReport(f.Name())
+			if rp == nil {
+				continue
+			}
+			if latest := db.GetRevision(rp.ID, rp.Revisions); latest == nil || !latest.Public {
+				continue
+			}
+			for ver := 1; ver <= rp.Revisions; ver++ {
+				if rev := db.GetRevision(rp.ID, ver); rev != nil && rev.Public {
+					r <- rev
+				}
+			}
+		}
+	}(r)
+	return r
+}
+
+// ExportJSONL writes one ExportRecord per line.
+func (db *DB) ExportJSONL(w io.Writer, all bool, flush func()) error {
+	revs := db.GetPublicRevisions(all)
+	// Drain the channel on errors, so the reading goroutine ends
+	defer func() {
+		for range revs {
+		}
+	}()
+
+	for rev := range revs {
+		recBytes, _ := json.Marshal(ExportRecord{
+			ID:        rev.ReportID,
+			Version:   rev.Version,
+			CreatedAt: rev.CreatedAt,
+			Answers:   rev.Answers,
+		})
+		if _, err := w.Write(append(recBytes, '\n')); err != nil {
+			return err
+		}
+		flush()
+	}
+	return nil
+}
+
+// listLengths records the longest answer of every list question, with the
+// path of the list as key.
+func listLengths(q Question, a interface{}, path string, lengths map[string]int) {
+	switch q.Type {
+	case "complex":
+		compl, _ := a.(map[string]interface{})
+		for _, child := range q.Children {
+			listLengths(child, compl[child.ID], joinPath(path, child.ID), lengths)
+		}
+	case "list":
+		if q.Child == nil {
+			return
+		}
+		list, _ := a.([]interface{})
+		if len(list) > lengths[path] {
+			lengths[path] = len(list)
+		}
+		// Elements share the lengths of their nested lists
+		for _, ae := range list {
+			listLengths(*q.Child, ae, joinPath(path, "*"), lengths)
+		}
+	}
+}
+
+// leafPaths returns the paths of all leaf questions with list indices expanded
+// up to the given lengths.
+func leafPaths(q Question, path string, pattern string, lengths map[string]int) []string {
+	switch q.Type {
+	case "complex":
+		var ps []string
+		for _, child := range q.Children {
+			ps = append(ps, leafPaths(child, joinPath(path, child.ID), joinPath(pattern, child.ID), lengths)...)
+		}
+		return ps
+	case "list":
+		if q.Child == nil {
+			return nil
+		}
+		var ps []string
+		for i := 1; i <= lengths[pattern]; i++ {
+			ps = append(ps, leafPaths(*q.Child, joinPath(path, strconv.Itoa(i)), joinPath(pattern, "*"), lengths)...)
+		}
+		return ps
+	}
+	return []string{path}
+}
+
+var exportColumns = []string{"id", "version", "createdAt"}
+
+// ExportCSV writes one row per revision and one column per leaf question.
+// Multiple values are joined by "|" like ExtractField does. The revisions are
+// read twice: first to find the columns, then to write the rows.
+func (db *DB) ExportCSV(w io.Writer, all bool, flush func()) error {
+	lengths := map[string]int{}
+	for rev := range db.GetPublicRevisions(all) {
+		var ans interface{}
+		json.Unmarshal(rev.Answers, &ans)
+		listLengths(db.questions, ans, "", lengths)
+	}
+	paths := leafPaths(db.questions, "", "", lengths)
+
+	cw := csv.NewWriter(w)
+	cw.Write(append(append([]string{}, exportColumns...), paths...))
+
+	revs := db.GetPublicRevisions(all)
+	defer func() {
+		for range revs {
+		}
+	}()
+
+	for rev := range revs {
+		var ans interface{}
+		json.Unmarshal(rev.Answers, &ans)
+
+		values := map[string][]string{}
+		for _, f := range flattenAnswers(db.questions, ans, "") {
+			values[f.path] = append(values[f.path], f.value)
+		}
+
+		row := []string{rev.ReportID, strconv.Itoa(rev.Version), rev.CreatedAt.UTC().Format(time.RFC3339)}
+		for _, p := range paths {
+			row = append(row, strings.Join(values[p], "|"))
+		}
+		cw.Write(row)
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+		flush()
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
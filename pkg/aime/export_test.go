@@ -0,0 +1,93 @@
+package aime
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLeafPaths(t *testing.T) {
+	q := Question{Type: "complex", Children: []Question{
+		{ID: "1", Type: "string"},
+		{ID: "2", Type: "list", Child: &Question{Type: "complex", Children: []Question{
+			{ID: "1", Type: "string"},
+			{ID: "2", Type: "list", Child: &Question{Type: "string"}},
+		}}},
+	}}
+
+	var a interface{}
+	json.Unmarshal([]byte(`{"2":[{"1":"a"},{"2":["x","y"]}]}`), &a)
+	lengths := map[string]int{}
+	listLengths(q, a, "", lengths)
+
+	ps := leafPaths(q, "", "", lengths)
+	exp := []string{"1", "2.1.1", "2.1.2.1", "2.1.2.2", "2.2.1", "2.2.2.1", "2.2.2.2"}
+	if len(ps) != len(exp) {
+		t.Fatal(ps)
+	}
+	for i := range exp {
+		if ps[i] != exp[i] {
+			t.Fatal(ps)
+		}
+	}
+}
+
+func TestServer_Export(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	r1 := db.CreateReport("", true)
+	db.CreateRevision(r1.ID, "", json.RawMessage("{\"MD\":{\"1\":\"First\"}}"), r1.Token, true)
+	db.CreateRevision(r1.ID, "", json.RawMessage("{\"MD\":{\"1\":\"First, again\",\"5\":[{\"custom\":false,\"value\":\"omics\"},"+
+		"{\"custom\":true,\"value\":\"ecg\"}],\"6\":[{\"1\":\"Jane\"},{\"1\":\"John\"}]}}"), r1.Token, true)
+	r2 := db.CreateReport("", false)
+	db.CreateRevision(r2.ID, "", json.RawMessage("{\"MD\":{\"1\":\"Hidden\"}}"), r2.Token, false)
+	// Earlier public revisions of hidden reports are not exported either
+	r3 := db.CreateReport("", true)
+	db.CreateRevision(r3.ID, "", json.RawMessage("{\"MD\":{\"1\":\"Public\"}}"), r3.Token, true)
+	db.CreateRevision(r3.ID, "", json.RawMessage("{\"MD\":{\"1\":\"Hidden now\"}}"), r3.Token, false)
+
+	srv := Server{DB: db}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	count := func(path string) int {
+		resp, _ := http.Get(ts.URL + path)
+		n := 0
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			rec := ExportRecord{}
+			if json.Unmarshal(sc.Bytes(), &rec) != nil || rec.ID != r1.ID {
+				t.Fatal(sc.Text())
+			}
+			n++
+		}
+		return n
+	}
+	if n := count("/export/reports.jsonl"); n != 1 {
+		t.Fatal(n)
+	}
+	if n := count("/export/reports.jsonl?revisions=all"); n != 2 {
+		t.Fatal(n)
+	}
+
+	resp, _ := http.Get(ts.URL + "/export/reports.csv")
+	rows, err := csv.NewReader(resp.Body).ReadAll()
+	if err != nil || len(rows) != 2 {
+		t.Fatal(err, rows)
+	}
+	row := map[string]string{}
+	for i, c := range rows[0] {
+		row[c] = rows[1][i]
+	}
+	if row["id"] != r1.ID || row["MD.1"] != "First, again" || row["MD.5"] != "omics|ecg" || row["MD.6.2.1"] != "John" {
+		t.Fatal(row)
+	}
+	if _, ok := row["MD.6.3.1"]; ok {
+		t.Fatal(rows[0])
+	}
+}
@@ -0,0 +1,281 @@
+package aime
+
+import (
+	"encoding/xml"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const feedSize = 50
+
+type feedEntry struct {
+	id        string
+	title     string
+	link      string
+	authors   []string
+	published time.Time
+	updated   time.Time
+	summary   string
+}
+
+type feed struct {
+	id      string
+	title   string
+	link    string
+	self    string
+	entries []feedEntry
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID        string       `xml:"id"`
+	Title     string       `xml:"title"`
+	Links     []atomLink   `xml:"link"`
+	Authors   []atomPerson `xml:"author"`
+	Published string       `xml:"published"`
+	Updated   string       `xml:"updated"`
+	Summary   string       `xml:"summary,omitempty"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomPerson  `xml:"author"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Creators    []string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Description string   `xml:"description,omitempty"`
+}
+
+type rssChannel struct {
+	Title string `xml:"title"`
+	// Self comes first, so that it is not read as the link of the channel
+	Self          *atomLink `xml:"http://www.w3.org/2005/Atom link"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+// finish sorts the entries, newest first, and keeps the most recent ones.
+func (f *feed) finish() {
+	sort.Slice(f.entries, func(i, j int) bool {
+		return f.entries[i].updated.After(f.entries[j].updated)
+	})
+	if len(f.entries) > feedSize {
+		f.entries = f.entries[:feedSize]
+	}
+}
+
+func (f feed) updated() time.Time {
+	var u time.Time
+	for _, e := range f.entries {
+		if e.updated.After(u) {
+			u = e.updated
+		}
+	}
+	return u
+}
+
+func (f feed) atom() []byte {
+	af := atomFeed{
+		ID:      f.id,
+		Title:   f.title,
+		Links:   []atomLink{{Href: f.link}, {Href: f.self, Rel: "self"}},
+		Author:  atomPerson{Name: "AIMe Registry"},
+		Updated: f.updated().UTC().Format(time.RFC3339),
+	}
+	for _, e := range f.entries {
+		ae := atomEntry{
+			ID:        e.id,
+			Title:     e.title,
+			Links:     []atomLink{{Href: e.link}},
+			Published: e.published.UTC().Format(time.RFC3339),
+			Updated:   e.updated.UTC().Format(time.RFC3339),
+			Summary:   e.summary,
+		}
+		for _, a := range e.authors {
+			if a != "" {
+				ae.Authors = append(ae.Authors, atomPerson{Name: a})
+			}
+		}
+		af.Entries = append(af.Entries, ae)
+	}
+	b, _ := xml.MarshalIndent(af, "", "  ")
+	return append([]byte(xml.Header), b...)
+}
+
+func (f feed) rss() []byte {
+	rf := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         f.title,
+			Self:          &atomLink{Href: f.self, Rel: "self"},
+			Link:          f.link,
+			Description:   f.title,
+			LastBuildDate: f.updated().UTC().Format(time.RFC1123Z),
+		},
+	}
+	for _, e := range f.entries {
+		ri := rssItem{
+			Title:       e.title,
+			Link:        e.link,
+			GUID:        rssGUID{Value: e.id},
+			PubDate:     e.updated.UTC().Format(time.RFC1123Z),
+			Description: e.summary,
+		}
+		for _, a := range e.authors {
+			if a != "" {
+				ri.Creators = append(ri.Creators, a)
+			}
+		}
+		rf.Channel.Items = append(rf.Channel.Items, ri)
+	}
+	b, _ := xml.MarshalIndent(rf, "", "  ")
+	return append([]byte(xml.Header), b...)
+}
+
+func (s *Server) revisionEntry(rp *Report, rev *Revision) feedEntry {
+	st := s.DB.Settings.withDefaults()
+	title := ExtractField(s.DB.questions, rev.Answers, splitField(st.TitleField))
+	return feedEntry{
+		id:        reportURL + rp.ID + "/" + strconv.Itoa(rev.Version),
+		title:     title + " (revision " + strconv.Itoa(rev.Version) + ")",
+		link:      reportURL + rp.ID + "/" + strconv.Itoa(rev.Version),
+		authors:   ExtractFields(s.DB.questions, rev.Answers, splitField(st.AuthorsField)),
+		published: rev.CreatedAt,
+		updated:   rev.CreatedAt,
+	}
+}
+
+// reportsFeed lists the public reports, newest first.
+func (s *Server) reportsFeed(format string) feed {
+	f := feed{
+		id:    apiURL + "feeds/reports.atom",
+		title: "AIMe registry: new reports",
+		link:  registryURL,
+		self:  apiURL + "feeds/reports." + format,
+	}
+	st := s.DB.Settings.withDefaults()
+	for rev := range s.DB.GetLatestRevisions(false) {
+		rp := s.DB.GetReport(rev.ReportID)
+		if rp == nil {
+			continue
+		}
+		f.entries = append(f.entries, feedEntry{
+			id:        reportURL + rp.ID,
+			title:     ExtractField(s.DB.questions, rev.Answers, splitField(st.TitleField)),
+			link:      reportURL + rp.ID,
+			authors:   ExtractFields(s.DB.questions, rev.Answers, splitField(st.AuthorsField)),
+			published: rp.CreatedAt,
+			updated:   rp.CreatedAt,
+		})
+	}
+	f.finish()
+	return f
+}
+
+// revisionsFeed lists the public revisions of all public reports.
+func (s *Server) revisionsFeed(format string) feed {
+	f := feed{
+		id:    apiURL + "feeds/revisions.atom",
+		title: "AIMe registry: new revisions",
+		link:  registryURL,
+		self:  apiURL + "feeds/revisions." + format,
+	}
+	for latest := range s.DB.GetLatestRevisions(false) {
+		rp := s.DB.GetReport(latest.ReportID)
+		if rp == nil {
+			continue
+		}
+		for i := 1; i <= latest.Version; i++ {
+			rev := s.DB.GetRevision(rp.ID, i)
+			if rev == nil || !rev.Public {
+				continue
+			}
+			f.entries = append(f.entries, s.revisionEntry(rp, rev))
+		}
+	}
+	f.finish()
+	return f
+}
+
+// reportFeed lists the public revisions and the public issues of a report.
+// It returns nil if the report does not exist or is hidden.
+func (s *Server) reportFeed(id string, format string) *feed {
+	rp := s.DB.GetReport(id)
+	if rp == nil {
+		return nil
+	}
+	latest := s.DB.GetRevision(id, rp.Revisions)
+	if latest == nil || !latest.Public {
+		return nil
+	}
+
+	st := s.DB.Settings.withDefaults()
+	f := &feed{
+		id:    reportURL + id,
+		title: "AIMe report " + id + ": " + ExtractField(s.DB.questions, latest.Answers, splitField(st.TitleField)),
+		link:  reportURL + id,
+		self:  apiURL + "report/" + id + "/feed." + format,
+	}
+
+	for i := 1; i <= rp.Revisions; i++ {
+		rev := s.DB.GetRevision(id, i)
+		if rev == nil || !rev.Public {
+			continue
+		}
+		f.entries = append(f.entries, s.revisionEntry(rp, rev))
+	}
+
+	for iss := range s.DB.GetReportIssues(id, false) {
+		updated := iss.CreatedAt
+		for _, a := range iss.Answers {
+			if a.CreatedAt.After(updated) {
+				updated = a.CreatedAt
+			}
+		}
+		link := registryURL + "report/" + id + "/issue/" + strconv.Itoa(iss.ID)
+		f.entries = append(f.entries, feedEntry{
+			id:        link,
+			title:     "Issue " + strconv.Itoa(iss.ID) + " by " + iss.Name,
+			link:      link,
+			authors:   []string{iss.Name},
+			published: iss.CreatedAt,
+			updated:   updated,
+			summary:   iss.Content,
+		})
+	}
+
+	f.finish()
+	return f
+}
@@ -0,0 +1,85 @@
+package aime
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer_Feeds(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	r1 := db.CreateReport("", true)
+	db.CreateRevision(r1.ID, "", json.RawMessage("{\"MD\":{\"1\":\"First\",\"6\":[{\"1\":\"Jane Doe\"}]}}"), r1.Token, true)
+	db.CreateRevision(r1.ID, "", json.RawMessage("{\"MD\":{\"1\":\"First\",\"6\":[{\"1\":\"Jane Doe\"}]}}"), r1.Token, true)
+	r2 := db.CreateReport("", false)
+	db.CreateRevision(r2.ID, "", json.RawMessage("{\"MD\":{\"1\":\"Hidden\"}}"), r2.Token, false)
+
+	iss := db.CreateIssue(r1.ID, "John", "john@example.org", []string{"MD", "1"}, "Unclear title", 0)
+	db.ValidateIssue(r1.ID, iss.ID, iss.Token)
+	db.CreateAnswer(r1.ID, iss.ID, "Fixed", r1.Token)
+
+	srv := Server{DB: db}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, _ := http.Get(ts.URL + "/feeds/reports.atom")
+	if resp.StatusCode != 200 {
+		t.Fatal()
+	}
+	respBytes, _ := ioutil.ReadAll(resp.Body)
+	af := atomFeed{}
+	if err := xml.Unmarshal(respBytes, &af); err != nil {
+		t.Fatal(err)
+	}
+	if len(af.Links) != 2 || af.Links[1].Rel != "self" || af.Links[1].Href != apiURL+"feeds/reports.atom" {
+		t.Fatal(string(respBytes))
+	}
+	if len(af.Entries) != 1 || af.Entries[0].Title != "First" || af.Entries[0].Links[0].Href != reportURL+r1.ID {
+		t.Fatal(string(respBytes))
+	}
+	if len(af.Entries[0].Authors) != 1 || af.Entries[0].Authors[0].Name != "Jane Doe" {
+		t.Fatal(string(respBytes))
+	}
+
+	resp, _ = http.Get(ts.URL + "/feeds/revisions.rss")
+	if resp.StatusCode != 200 {
+		t.Fatal()
+	}
+	respBytes, _ = ioutil.ReadAll(resp.Body)
+	rf := rssFeed{}
+	if err := xml.Unmarshal(respBytes, &rf); err != nil {
+		t.Fatal(err)
+	}
+	if rf.Channel.Link != registryURL || rf.Channel.Self == nil || rf.Channel.Self.Href != apiURL+"feeds/revisions.rss" {
+		t.Fatal(string(respBytes))
+	}
+	if len(rf.Channel.Items) != 2 || rf.Channel.Items[0].Link != reportURL+r1.ID+"/2" {
+		t.Fatal(string(respBytes))
+	}
+
+	resp, _ = http.Get(ts.URL + "/report/" + r1.ID + "/feed.atom")
+	if resp.StatusCode != 200 {
+		t.Fatal()
+	}
+	respBytes, _ = ioutil.ReadAll(resp.Body)
+	af = atomFeed{}
+	xml.Unmarshal(respBytes, &af)
+	if len(af.Entries) != 3 {
+		t.Fatal(string(respBytes))
+	}
+	// The answered issue is the most recent entry
+	if af.Entries[0].Summary != "Unclear title" {
+		t.Fatal(string(respBytes))
+	}
+
+	resp, _ = http.Get(ts.URL + "/report/" + r2.ID + "/feed.rss")
+	if resp.StatusCode != 404 {
+		t.Fatal()
+	}
+}
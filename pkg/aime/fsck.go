@@ -0,0 +1,285 @@
+package aime
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The check finds inconsistencies of the files of the DB. Only problems that can
+// be repaired without losing or guessing data are repaired: counters that
+// disagree with complete numbering, IDs that disagree with the file names,
+// missing tokens and stale PDF caches.
+
+// Kinds of problems
+const (
+	ProblemInvalidJSON      = "invalid-json"
+	ProblemOrphan           = "orphan"
+	ProblemGap              = "gap"
+	ProblemCounter          = "counter"
+	ProblemMismatchedID     = "mismatched-id"
+	ProblemMissingToken     = "missing-token"
+	ProblemDanglingDocument = "dangling-document"
+)
+
+type Problem struct {
+	Kind     string
+	ReportID string
+	// Path is relative to the DB directory
+	Path     string
+	Message  string
+	Repaired bool
+}
+
+func (p Problem) String() string {
+	s := p.Path + ": " + p.Message
+	if p.Repaired {
+		s += " (repaired)"
+	}
+	return s
+}
+
+type checker struct {
+	db     *DB
+	repair bool
+
+	problems []Problem
+	// refs maps the referenced documents to the referencing files
+	refs map[string][]string
+}
+
+func (c *checker) add(kind string, id string, path string, repaired bool, format string, a ...interface{}) {
+	rel, err := filepath.Rel(c.db.Dir, path)
+	if err != nil {
+		rel = path
+	}
+	c.problems = append(c.problems, Problem{
+		Kind:     kind,
+		ReportID: id,
+		Path:     filepath.ToSlash(rel),
+		Message:  fmt.Sprintf(format, a...),
+		Repaired: repaired,
+	})
+}
+
+// numbered lists the files named by number with the extension in a directory.
+// Other files are orphans.
+func (c *checker) numbered(id string, dir string, ext string) map[int]string {
+	files := map[int]string{}
+	fis, _ := ioutil.ReadDir(dir)
+	for _, fi := range fis {
+		p := filepath.Join(dir, fi.Name())
+		n, err := strconv.Atoi(strings.TrimSuffix(fi.Name(), ext))
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ext) || err != nil || n <= 0 || fmt.Sprintf("%04d", n)+ext != fi.Name() {
+			c.add(ProblemOrphan, id, p, false, "unexpected file")
+			continue
+		}
+		files[n] = p
+	}
+	return files
+}
+
+// counter checks a counter against the numbered files. It returns the
+// repaired counter, which is only changed if the files up to the last one are
+// all there and valid.
+func (c *checker) counter(id string, path string, name string, count int, files map[int]string, valid map[int]bool) int {
+	max := 0
+	for n := range files {
+		if n > max {
+			max = n
+		}
+	}
+
+	// Files missing after the last one are lost either way, so the counter
+	// may still be lowered
+	complete := true
+	for n := 1; n <= max || n <= count; n++ {
+		if files[n] == "" {
+			c.add(ProblemGap, id, path, false, "%s %d is missing", name, n)
+		}
+		if n <= max && !valid[n] {
+			complete = false
+		}
+	}
+
+	if count != max {
+		repaired := c.repair && complete
+		c.add(ProblemCounter, id, path, repaired, "counter of %ss is %d, found %d", name, count, max)
+		if repaired {
+			return max
+		}
+	}
+	return count
+}
+
+func (c *checker) checkReport(id string) {
+	db := c.db
+	rpPath := filepath.Join(db.reportPath(id), "report.json")
+
+	rpBytes, err := ioutil.ReadFile(rpPath)
+	if err != nil {
+		c.add(ProblemOrphan, id, db.reportPath(id), false, "report without report.json")
+		return
+	}
+	urp := UnsafeReport{}
+	if err := unmarshalDocument(SchemaReport, rpBytes, &urp); err != nil {
+		c.add(ProblemInvalidJSON, id, rpPath, false, "report does not parse: %v", err)
+		return
+	}
+	rp := Report(urp)
+	changed := false
+
+	if rp.ID != id {
+		c.add(ProblemMismatchedID, id, rpPath, c.repair, "report has ID %q", rp.ID)
+		rp.ID = id
+		changed = true
+	}
+	if rp.Token == "" {
+		c.add(ProblemMissingToken, id, rpPath, c.repair, "report has no token")
+		rp.Token = generateRandomString(16)
+		changed = true
+	}
+
+	// Revisions
+	revs := c.numbered(id, db.revisionPath(id), ".json")
+	valid := map[int]bool{}
+	for ver, p := range revs {
+		revBytes, _ := ioutil.ReadFile(p)
+		rev := Revision{}
+		if err := unmarshalDocument(SchemaRevision, revBytes, &rev); err != nil {
+			c.add(ProblemInvalidJSON, id, p, false, "revision does not parse: %v", err)
+			continue
+		}
+		valid[ver] = true
+
+		if rev.ReportID != id || rev.Version != ver {
+			c.add(ProblemMismatchedID, id, p, c.repair, "revision is %s/%d", rev.ReportID, rev.Version)
+			if c.repair {
+				rev.ReportID = id
+				rev.Version = ver
+				revBytes = marshalDocument(SchemaRevision, rev)
+				ioutil.WriteFile(p, revBytes, os.ModePerm)
+			}
+		}
+
+		var ans interface{}
+		json.Unmarshal(rev.Answers, &ans)
+		for _, doc := range documentRefs(db.questions, ans, nil) {
+			c.refs[doc] = append(c.refs[doc], p)
+		}
+	}
+	if n := c.counter(id, rpPath, "revision", rp.Revisions, revs, valid); n != rp.Revisions {
+		rp.Revisions = n
+		changed = true
+	}
+
+	// Issues
+	comments := c.numbered(id, db.commentPath(id), ".json")
+	valid = map[int]bool{}
+	for cid, p := range comments {
+		comBytes, _ := ioutil.ReadFile(p)
+		iss := UnsafeIssue{}
+		if err := unmarshalDocument(SchemaIssue, comBytes, &iss); err != nil {
+			c.add(ProblemInvalidJSON, id, p, false, "issue does not parse: %v", err)
+			continue
+		}
+		valid[cid] = true
+
+		issChanged := false
+		if iss.ReportID != id || iss.ID != cid {
+			c.add(ProblemMismatchedID, id, p, c.repair, "issue is %s/%d", iss.ReportID, iss.ID)
+			iss.ReportID = id
+			iss.ID = cid
+			issChanged = true
+		}
+		if iss.Token == "" {
+			c.add(ProblemMissingToken, id, p, c.repair, "issue has no token")
+			iss.Token = generateRandomString(16)
+			issChanged = true
+		}
+		if issChanged && c.repair {
+			comBytes = marshalDocument(SchemaIssue, iss)
+			ioutil.WriteFile(p, comBytes, os.ModePerm)
+		}
+	}
+	if n := c.counter(id, rpPath, "issue", rp.Comments, comments, valid); n != rp.Comments {
+		rp.Comments = n
+		changed = true
+	}
+
+	// Cached PDFs of revisions that do not exist are stale
+	pdfs := c.numbered(id, filepath.Join(db.reportPath(id), "pdf"), ".pdf")
+	for ver, p := range pdfs {
+		if ver > rp.Revisions || revs[ver] == "" {
+			c.add(ProblemOrphan, id, p, c.repair, "cached PDF of missing revision %d", ver)
+			if c.repair {
+				os.Remove(p)
+			}
+		}
+	}
+
+	fis, _ := ioutil.ReadDir(db.reportPath(id))
+	for _, fi := range fis {
+		switch fi.Name() {
+		case "report.json", "revisions", "comments", "pdf":
+		default:
+			c.add(ProblemOrphan, id, filepath.Join(db.reportPath(id), fi.Name()), false, "unexpected file")
+		}
+	}
+
+	if changed && c.repair {
+		db.SetReport(rp)
+	}
+}
+
+// Check scans the reports and documents of the DB and optionally repairs the
+// problems it finds.
+func (db *DB) Check(repair bool) []Problem {
+	if repair {
+		db.writeMutex.RLock()
+		defer db.writeMutex.RUnlock()
+	}
+
+	c := &checker{db: db, repair: repair, refs: map[string][]string{}}
+
+	fis, _ := ioutil.ReadDir(filepath.Join(db.Dir, "reports"))
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			c.add(ProblemOrphan, "", filepath.Join(db.Dir, "reports", fi.Name()), false, "unexpected file")
+			continue
+		}
+		c.checkReport(fi.Name())
+	}
+
+	var docs []string
+	for doc := range c.refs {
+		docs = append(docs, doc)
+	}
+	sort.Strings(docs)
+	for _, doc := range docs {
+		if _, err := os.Stat(db.documentPath(doc)); err != nil {
+			for _, p := range c.refs[doc] {
+				id := filepath.Base(filepath.Dir(filepath.Dir(p)))
+				c.add(ProblemDanglingDocument, id, p, false, "document %s does not exist", doc)
+			}
+		}
+	}
+
+	fis, _ = ioutil.ReadDir(filepath.Join(db.Dir, "documents"))
+	for _, fi := range fis {
+		if c.refs[fi.Name()] == nil {
+			c.add(ProblemOrphan, "", db.documentPath(fi.Name()), false, "document is not referenced by any revision")
+		}
+	}
+
+	sort.SliceStable(c.problems, func(i, j int) bool {
+		return c.problems[i].Path < c.problems[j].Path
+	})
+
+	return c.problems
+}
@@ -0,0 +1,79 @@
+package aime
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_Check(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	doc := db.UploadDocument([]byte("%PDF-1.4"))
+
+	ok := db.CreateReport("", true)
+	db.CreateRevision(ok.ID, "", json.RawMessage(`{"MD":{"9":[{"2":"`+doc+`"}]}}`), ok.Token, true)
+	if ps := db.Check(false); len(ps) != 0 {
+		t.Fatal(ps)
+	}
+
+	// A revision written without updating the counter, a stale PDF cache and
+	// an issue without token
+	rp := db.CreateReport("", true)
+	db.CreateRevision(rp.ID, "", json.RawMessage(`{"MD":{"9":[{"2":"missing.pdf"}]}}`), rp.Token, true)
+	ioutil.WriteFile(db.revisionFilePath(rp.ID, 2), []byte(`{"reportId":"other","version":2,"answers":{}}`), os.ModePerm)
+	os.MkdirAll(filepath.Dir(db.pdfFilePath(rp.ID, 3)), os.ModePerm)
+	ioutil.WriteFile(db.pdfFilePath(rp.ID, 3), []byte("%PDF"), os.ModePerm)
+	iss := db.CreateIssue(rp.ID, "Jane", "jane@test.de", []string{"MD"}, "Question", 0)
+	iss.Token = ""
+	db.SetIssue(*iss)
+
+	// Unparseable JSON, a gap and an orphan
+	broken := db.CreateReport("", true)
+	db.CreateRevision(broken.ID, "", json.RawMessage("{}"), broken.Token, true)
+	ioutil.WriteFile(db.revisionFilePath(broken.ID, 1), []byte(`{"reportId":`), os.ModePerm)
+	ioutil.WriteFile(db.revisionFilePath(broken.ID, 3), []byte(`{"reportId":"`+broken.ID+`","version":3}`), os.ModePerm)
+	ioutil.WriteFile(filepath.Join(db.revisionPath(broken.ID), "0002.json.tmp"), []byte("{}"), os.ModePerm)
+
+	kinds := func(ps []Problem) map[string]int {
+		ks := map[string]int{}
+		for _, p := range ps {
+			if !p.Repaired {
+				ks[p.Kind]++
+			}
+		}
+		return ks
+	}
+
+	ks := kinds(db.Check(false))
+	if ks[ProblemCounter] != 2 || ks[ProblemMismatchedID] != 1 || ks[ProblemMissingToken] != 1 ||
+		ks[ProblemInvalidJSON] != 1 || ks[ProblemGap] != 1 || ks[ProblemOrphan] != 2 || ks[ProblemDanglingDocument] != 1 {
+		t.Fatal(db.Check(false))
+	}
+
+	db.Check(true)
+
+	// What cannot be repaired safely is left
+	ks = kinds(db.Check(false))
+	if len(ks) != 5 || ks[ProblemCounter] != 1 || ks[ProblemInvalidJSON] != 1 || ks[ProblemGap] != 1 ||
+		ks[ProblemOrphan] != 1 || ks[ProblemDanglingDocument] != 1 {
+		t.Fatal(db.Check(false))
+	}
+
+	if rrp := db.GetReport(rp.ID); rrp.Revisions != 2 || rrp.Token != rp.Token {
+		t.Fatal(rrp)
+	}
+	if rev := db.GetRevision(rp.ID, 2); rev.ReportID != rp.ID {
+		t.Fatal(rev)
+	}
+	if riss := db.GetIssue(rp.ID, iss.ID); riss.Token == "" {
+		t.Fatal(riss)
+	}
+	if _, err := os.Stat(db.pdfFilePath(rp.ID, 3)); err == nil {
+		t.Fatal("stale PDF kept")
+	}
+}
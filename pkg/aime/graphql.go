@@ -0,0 +1,1013 @@
+package aime
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A small GraphQL implementation for the read-only API. It supports a subset
+// of the spec:
+//
+//   - queries with variables and their defaults, aliases, named and inline
+//     fragments and the @include and @skip directives
+//   - object types, lists and the scalars Int, Float, String, ID and Boolean,
+//     written as schema in Go
+//   - __typename as the only introspection
+//
+// There are no mutations, subscriptions, interfaces, unions or input object
+// types. Besides syntax errors, only operations, variables and fragments are
+// checked before execution, other errors like unknown fields or arguments are
+// reported per field with a null value. The tests of graphql_test.go cover
+// this subset.
+
+var ErrInvalidGraphQL = errors.New("invalid graphql")
+
+// gqlMaxDepth limits the nesting of selections, as types refer to each other
+// (Report.latest.report.latest...).
+const gqlMaxDepth = 12
+
+// Lexer
+
+const (
+	gqlEOF    = 0
+	gqlPunct  = 'p'
+	gqlName   = 'n'
+	gqlInt    = 'i'
+	gqlFloat  = 'f'
+	gqlString = 's'
+)
+
+type gqlToken struct {
+	kind  byte
+	value string
+	pos   int
+}
+
+func gqlSyntaxError(pos int, msg string) error {
+	return fmt.Errorf("%w: syntax error at %d: %s", ErrInvalidGraphQL, pos, msg)
+}
+
+func isGqlNameStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isGqlDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func tokenizeGraphQL(src string) ([]gqlToken, error) {
+	var ts []gqlToken
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case strings.HasPrefix(src[i:], "\uFEFF"):
+			i += 3
+		case c == '#':
+			for i < len(src) && src[i] != '\n' && src[i] != '\r' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "..."):
+			ts = append(ts, gqlToken{gqlPunct, "...", i})
+			i += 3
+		case strings.IndexByte("!$():=@[]{}|&", c) >= 0:
+			ts = append(ts, gqlToken{gqlPunct, string(c), i})
+			i++
+		case isGqlNameStart(c):
+			j := i
+			for j < len(src) && (isGqlNameStart(src[j]) || isGqlDigit(src[j])) {
+				j++
+			}
+			ts = append(ts, gqlToken{gqlName, src[i:j], i})
+			i = j
+		case isGqlDigit(c) || c == '-':
+			j := i + 1
+			kind := byte(gqlInt)
+			for j < len(src) && isGqlDigit(src[j]) {
+				j++
+			}
+			if j < len(src) && src[j] == '.' {
+				kind = gqlFloat
+				j++
+				for j < len(src) && isGqlDigit(src[j]) {
+					j++
+				}
+			}
+			if j < len(src) && (src[j] == 'e' || src[j] == 'E') {
+				kind = gqlFloat
+				j++
+				if j < len(src) && (src[j] == '+' || src[j] == '-') {
+					j++
+				}
+				for j < len(src) && isGqlDigit(src[j]) {
+					j++
+				}
+			}
+			if src[i:j] == "-" {
+				return nil, gqlSyntaxError(i, "invalid number")
+			}
+			ts = append(ts, gqlToken{kind, src[i:j], i})
+			i = j
+		case strings.HasPrefix(src[i:], `"""`):
+			end := strings.Index(src[i+3:], `"""`)
+			if end < 0 {
+				return nil, gqlSyntaxError(i, "unterminated string")
+			}
+			ts = append(ts, gqlToken{gqlString, strings.TrimSpace(src[i+3 : i+3+end]), i})
+			i += end + 6
+		case c == '"':
+			s, n, err := unquoteGraphQL(src[i:])
+			if err != nil {
+				return nil, gqlSyntaxError(i, err.Error())
+			}
+			ts = append(ts, gqlToken{gqlString, s, i})
+			i += n
+		default:
+			return nil, gqlSyntaxError(i, "unexpected character")
+		}
+	}
+	return append(ts, gqlToken{gqlEOF, "", len(src)}), nil
+}
+
+// unquoteGraphQL reads the string at the start of src and returns it with the
+// number of bytes read.
+func unquoteGraphQL(src string) (string, int, error) {
+	b := &strings.Builder{}
+	for i := 1; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '"':
+			return b.String(), i + 1, nil
+		case c == '\n' || c == '\r':
+			return "", 0, errors.New("unterminated string")
+		case c == '\\' && i+1 < len(src):
+			esc := src[i+1]
+			i += 2
+			switch esc {
+			case '"', '\\', '/':
+				b.WriteByte(esc)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if i+4 > len(src) {
+					return "", 0, errors.New("invalid escape")
+				}
+				r, err := strconv.ParseUint(src[i:i+4], 16, 32)
+				if err != nil {
+					return "", 0, errors.New("invalid escape")
+				}
+				b.WriteRune(rune(r))
+				i += 4
+			default:
+				return "", 0, errors.New("invalid escape")
+			}
+		default:
+			r, n := utf8.DecodeRuneInString(src[i:])
+			b.WriteRune(r)
+			i += n
+		}
+	}
+	return "", 0, errors.New("unterminated string")
+}
+
+// Parser
+
+type gqlDocument struct {
+	operations []*gqlOperation
+	fragments  map[string]*gqlFragment
+}
+
+type gqlOperation struct {
+	kind       string
+	name       string
+	variables  []gqlVariableDef
+	selections []gqlSelection
+}
+
+type gqlVariableDef struct {
+	name string
+	typ  string
+	def  *gqlValue
+}
+
+type gqlFragment struct {
+	typeCond   string
+	selections []gqlSelection
+}
+
+type gqlDirective struct {
+	name string
+	args map[string]gqlValue
+}
+
+// gqlSelection is a field, a fragment spread (spread is set) or an inline
+// fragment (inline is set).
+type gqlSelection struct {
+	alias      string
+	name       string
+	args       map[string]gqlValue
+	directives []gqlDirective
+	selections []gqlSelection
+
+	spread   string
+	inline   bool
+	typeCond string
+}
+
+func (s gqlSelection) key() string {
+	if s.alias != "" {
+		return s.alias
+	}
+	return s.name
+}
+
+// gqlValue is a literal of the query. Kinds are those of the tokens and '$'
+// for variables, 'b' for booleans, '0' for null, 'e' for enums, 'l' for lists
+// and 'o' for objects.
+type gqlValue struct {
+	kind   byte
+	raw    string
+	list   []gqlValue
+	fields map[string]gqlValue
+}
+
+type gqlParser struct {
+	tokens []gqlToken
+	pos    int
+}
+
+func (p *gqlParser) peek() gqlToken {
+	return p.tokens[p.pos]
+}
+
+func (p *gqlParser) next() gqlToken {
+	t := p.tokens[p.pos]
+	if t.kind != gqlEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *gqlParser) accept(value string) bool {
+	t := p.peek()
+	if t.kind == gqlPunct && t.value == value {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *gqlParser) expect(value string) error {
+	if !p.accept(value) {
+		return gqlSyntaxError(p.peek().pos, "expected "+value)
+	}
+	return nil
+}
+
+func (p *gqlParser) name() (string, error) {
+	t := p.next()
+	if t.kind != gqlName {
+		return "", gqlSyntaxError(t.pos, "expected name")
+	}
+	return t.value, nil
+}
+
+func parseGraphQL(src string) (*gqlDocument, error) {
+	ts, err := tokenizeGraphQL(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &gqlParser{tokens: ts}
+	doc := &gqlDocument{fragments: map[string]*gqlFragment{}}
+
+	for p.peek().kind != gqlEOF {
+		t := p.peek()
+		switch {
+		case t.kind == gqlPunct && t.value == "{":
+			sels, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &gqlOperation{kind: "query", selections: sels})
+		case t.kind == gqlName && (t.value == "query" || t.value == "mutation" || t.value == "subscription"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case t.kind == gqlName && t.value == "fragment":
+			p.next()
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if on, err := p.name(); err != nil || on != "on" {
+				return nil, gqlSyntaxError(t.pos, "expected on")
+			}
+			typeCond, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.directives(); err != nil {
+				return nil, err
+			}
+			sels, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			if doc.fragments[name] != nil {
+				return nil, fmt.Errorf("%w: duplicate fragment %s", ErrInvalidGraphQL, name)
+			}
+			doc.fragments[name] = &gqlFragment{typeCond, sels}
+		default:
+			return nil, gqlSyntaxError(t.pos, "expected definition")
+		}
+	}
+
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("%w: no operation", ErrInvalidGraphQL)
+	}
+	return doc, doc.checkFragments()
+}
+
+// checkFragments reports spreads of unknown fragments and fragments that
+// spread themselves.
+func (doc *gqlDocument) checkFragments() error {
+	// Fragments are visiting while their spreads are followed, then checked
+	state := map[string]string{}
+
+	var check func(sels []gqlSelection) error
+	check = func(sels []gqlSelection) error {
+		for _, sel := range sels {
+			if sel.spread != "" {
+				f := doc.fragments[sel.spread]
+				if f == nil {
+					return fmt.Errorf("%w: unknown fragment %s", ErrInvalidGraphQL, sel.spread)
+				}
+				switch state[sel.spread] {
+				case "visiting":
+					return fmt.Errorf("%w: fragment %s spreads itself", ErrInvalidGraphQL, sel.spread)
+				case "":
+					state[sel.spread] = "visiting"
+					if err := check(f.selections); err != nil {
+						return err
+					}
+					state[sel.spread] = "checked"
+				}
+			}
+			if err := check(sel.selections); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, op := range doc.operations {
+		if err := check(op.selections); err != nil {
+			return err
+		}
+	}
+	for name := range doc.fragments {
+		if err := check([]gqlSelection{{spread: name}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *gqlParser) operation() (*gqlOperation, error) {
+	op := &gqlOperation{kind: p.next().value}
+	if p.peek().kind == gqlName {
+		op.name = p.next().value
+	}
+
+	if p.accept("(") {
+		for !p.accept(")") {
+			if err := p.expect("$"); err != nil {
+				return nil, err
+			}
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			typ, err := p.typeRef()
+			if err != nil {
+				return nil, err
+			}
+			vd := gqlVariableDef{name: name, typ: typ}
+			if p.accept("=") {
+				v, err := p.value(true)
+				if err != nil {
+					return nil, err
+				}
+				vd.def = &v
+			}
+			op.variables = append(op.variables, vd)
+		}
+	}
+
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	var err error
+	op.selections, err = p.selectionSet()
+	return op, err
+}
+
+func (p *gqlParser) typeRef() (string, error) {
+	var typ string
+	if p.accept("[") {
+		inner, err := p.typeRef()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expect("]"); err != nil {
+			return "", err
+		}
+		typ = "[" + inner + "]"
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return "", err
+		}
+		typ = name
+	}
+	if p.accept("!") {
+		typ += "!"
+	}
+	return typ, nil
+}
+
+func (p *gqlParser) selectionSet() ([]gqlSelection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var sels []gqlSelection
+	for !p.accept("}") {
+		if p.peek().kind == gqlEOF {
+			return nil, gqlSyntaxError(p.peek().pos, "expected }")
+		}
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, sel)
+	}
+	if len(sels) == 0 {
+		return nil, gqlSyntaxError(p.peek().pos, "empty selection")
+	}
+	return sels, nil
+}
+
+func (p *gqlParser) selection() (gqlSelection, error) {
+	var sel gqlSelection
+	var err error
+
+	if p.accept("...") {
+		if t := p.peek(); t.kind == gqlName && t.value != "on" {
+			sel.spread = p.next().value
+			sel.directives, err = p.directives()
+			return sel, err
+		}
+		sel.inline = true
+		if p.peek().kind == gqlName {
+			p.next()
+			if sel.typeCond, err = p.name(); err != nil {
+				return sel, err
+			}
+		}
+		if sel.directives, err = p.directives(); err != nil {
+			return sel, err
+		}
+		sel.selections, err = p.selectionSet()
+		return sel, err
+	}
+
+	if sel.name, err = p.name(); err != nil {
+		return sel, err
+	}
+	if p.accept(":") {
+		sel.alias = sel.name
+		if sel.name, err = p.name(); err != nil {
+			return sel, err
+		}
+	}
+	if sel.args, err = p.arguments(); err != nil {
+		return sel, err
+	}
+	if sel.directives, err = p.directives(); err != nil {
+		return sel, err
+	}
+	if t := p.peek(); t.kind == gqlPunct && t.value == "{" {
+		sel.selections, err = p.selectionSet()
+	}
+	return sel, err
+}
+
+func (p *gqlParser) arguments() (map[string]gqlValue, error) {
+	args := map[string]gqlValue{}
+	if !p.accept("(") {
+		return args, nil
+	}
+	for !p.accept(")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		v, err := p.value(false)
+		if err != nil {
+			return nil, err
+		}
+		args[name] = v
+	}
+	return args, nil
+}
+
+func (p *gqlParser) directives() ([]gqlDirective, error) {
+	var ds []gqlDirective
+	for p.accept("@") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		args, err := p.arguments()
+		if err != nil {
+			return nil, err
+		}
+		ds = append(ds, gqlDirective{name, args})
+	}
+	return ds, nil
+}
+
+// value parses a literal, constant values must not contain variables.
+func (p *gqlParser) value(constant bool) (gqlValue, error) {
+	t := p.next()
+	switch t.kind {
+	case gqlInt, gqlFloat, gqlString:
+		return gqlValue{kind: t.kind, raw: t.value}, nil
+	case gqlName:
+		switch t.value {
+		case "true", "false":
+			return gqlValue{kind: 'b', raw: t.value}, nil
+		case "null":
+			return gqlValue{kind: '0'}, nil
+		}
+		return gqlValue{kind: 'e', raw: t.value}, nil
+	case gqlPunct:
+		switch t.value {
+		case "$":
+			if constant {
+				return gqlValue{}, gqlSyntaxError(t.pos, "unexpected variable")
+			}
+			name, err := p.name()
+			return gqlValue{kind: '$', raw: name}, err
+		case "[":
+			v := gqlValue{kind: 'l', list: []gqlValue{}}
+			for !p.accept("]") {
+				e, err := p.value(constant)
+				if err != nil {
+					return v, err
+				}
+				v.list = append(v.list, e)
+			}
+			return v, nil
+		case "{":
+			v := gqlValue{kind: 'o', fields: map[string]gqlValue{}}
+			for !p.accept("}") {
+				name, err := p.name()
+				if err != nil {
+					return v, err
+				}
+				if err := p.expect(":"); err != nil {
+					return v, err
+				}
+				if v.fields[name], err = p.value(constant); err != nil {
+					return v, err
+				}
+			}
+			return v, nil
+		}
+	}
+	return gqlValue{}, gqlSyntaxError(t.pos, "expected value")
+}
+
+// resolve returns the value with variables substituted, numbers are int or
+// float64.
+func (v gqlValue) resolve(vars map[string]interface{}) interface{} {
+	switch v.kind {
+	case '$':
+		return vars[v.raw]
+	case gqlInt:
+		i, err := strconv.Atoi(v.raw)
+		if err != nil {
+			f, _ := strconv.ParseFloat(v.raw, 64)
+			return f
+		}
+		return i
+	case gqlFloat:
+		f, _ := strconv.ParseFloat(v.raw, 64)
+		return f
+	case gqlString, 'e':
+		return v.raw
+	case 'b':
+		return v.raw == "true"
+	case 'l':
+		l := []interface{}{}
+		for _, e := range v.list {
+			l = append(l, e.resolve(vars))
+		}
+		return l
+	case 'o':
+		o := map[string]interface{}{}
+		for k, e := range v.fields {
+			o[k] = e.resolve(vars)
+		}
+		return o
+	}
+	return nil
+}
+
+// Schema
+
+type gqlResolver func(parent interface{}, args map[string]interface{}) (interface{}, error)
+
+// gqlFieldDef is a field of an object type. Types are written as in GraphQL,
+// e.g. "[Revision]" or "Int!". List resolvers return []interface{}.
+type gqlFieldDef struct {
+	typ     string
+	args    map[string]string
+	resolve gqlResolver
+}
+
+type gqlObject struct {
+	name   string
+	fields map[string]gqlFieldDef
+}
+
+type gqlSchema map[string]*gqlObject
+
+// coerceGraphQL checks a value against an input type. JSON numbers of
+// variables are turned into ints for Int.
+func coerceGraphQL(typ string, v interface{}) (interface{}, error) {
+	if strings.HasSuffix(typ, "!") {
+		if v == nil {
+			return nil, errors.New("must not be null")
+		}
+		typ = strings.TrimSuffix(typ, "!")
+	}
+	if v == nil {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(typ, "[") {
+		inner := typ[1 : len(typ)-1]
+		list, ok := v.([]interface{})
+		if !ok {
+			list = []interface{}{v}
+		}
+		res := []interface{}{}
+		for _, e := range list {
+			ce, err := coerceGraphQL(inner, e)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, ce)
+		}
+		return res, nil
+	}
+
+	switch typ {
+	case "Int":
+		switch n := v.(type) {
+		case int:
+			return n, nil
+		case float64:
+			if n == float64(int(n)) {
+				return int(n), nil
+			}
+		}
+		return nil, errors.New("expected Int")
+	case "Float":
+		switch n := v.(type) {
+		case int:
+			return float64(n), nil
+		case float64:
+			return n, nil
+		}
+		return nil, errors.New("expected Float")
+	case "String", "ID":
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		if typ == "ID" {
+			// Numbers of variables are decoded from JSON as float64
+			switch n := v.(type) {
+			case int:
+				return strconv.Itoa(n), nil
+			case float64:
+				if n == float64(int(n)) {
+					return strconv.Itoa(int(n)), nil
+				}
+			}
+		}
+		return nil, errors.New("expected " + typ)
+	case "Boolean":
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		return nil, errors.New("expected Boolean")
+	}
+	return v, nil
+}
+
+// Execution
+
+type gqlError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// gqlResult is an object of the response, whose keys keep the order of the
+// query.
+type gqlResult struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (r *gqlResult) set(key string, v interface{}) {
+	if _, ok := r.values[key]; !ok {
+		r.keys = append(r.keys, key)
+	}
+	r.values[key] = v
+}
+
+func (r *gqlResult) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, k := range r.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		kb, _ := json.Marshal(k)
+		buf.Write(kb)
+		buf.WriteByte(':')
+		vb, err := json.Marshal(r.values[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(vb)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+type gqlExecutor struct {
+	schema    gqlSchema
+	fragments map[string]*gqlFragment
+	vars      map[string]interface{}
+	errors    []gqlError
+}
+
+// gqlPath appends to a copy of the path, as sibling fields share it.
+func gqlPath(path []interface{}, elem interface{}) []interface{} {
+	return append(append([]interface{}{}, path...), elem)
+}
+
+func (e *gqlExecutor) fail(path []interface{}, msg string) {
+	e.errors = append(e.errors, gqlError{msg, path})
+}
+
+func (e *gqlExecutor) included(ds []gqlDirective) bool {
+	for _, d := range ds {
+		cond, _ := d.args["if"].resolve(e.vars).(bool)
+		if d.name == "include" && !cond || d.name == "skip" && cond {
+			return false
+		}
+	}
+	return true
+}
+
+// collect flattens the fragments of a selection set into fields, fields with
+// the same key are merged.
+func (e *gqlExecutor) collect(typ *gqlObject, sels []gqlSelection, fields *[]gqlSelection, visited map[string]bool) {
+	for _, sel := range sels {
+		if !e.included(sel.directives) {
+			continue
+		}
+		switch {
+		case sel.spread != "":
+			f := e.fragments[sel.spread]
+			if f == nil || visited[sel.spread] || f.typeCond != typ.name {
+				continue
+			}
+			visited[sel.spread] = true
+			e.collect(typ, f.selections, fields, visited)
+		case sel.inline:
+			if sel.typeCond == "" || sel.typeCond == typ.name {
+				e.collect(typ, sel.selections, fields, visited)
+			}
+		default:
+			merged := false
+			for i, f := range *fields {
+				if f.key() == sel.key() {
+					(*fields)[i].selections = append(append([]gqlSelection{}, f.selections...), sel.selections...)
+					merged = true
+					break
+				}
+			}
+			if !merged {
+				*fields = append(*fields, sel)
+			}
+		}
+	}
+}
+
+func (e *gqlExecutor) object(typ *gqlObject, parent interface{}, sels []gqlSelection, path []interface{}) *gqlResult {
+	res := &gqlResult{values: map[string]interface{}{}}
+
+	var fields []gqlSelection
+	e.collect(typ, sels, &fields, map[string]bool{})
+
+	for _, sel := range fields {
+		fpath := gqlPath(path, sel.key())
+
+		if sel.name == "__typename" {
+			res.set(sel.key(), typ.name)
+			continue
+		}
+
+		def, ok := typ.fields[sel.name]
+		if !ok {
+			e.fail(fpath, "Cannot query field \""+sel.name+"\" on type \""+typ.name+"\"")
+			res.set(sel.key(), nil)
+			continue
+		}
+
+		args := map[string]interface{}{}
+		var argErr error
+		for name := range sel.args {
+			if _, ok := def.args[name]; !ok {
+				argErr = errors.New("Unknown argument \"" + name + "\"")
+			}
+		}
+		for name, at := range def.args {
+			var v interface{}
+			if av, ok := sel.args[name]; ok {
+				v = av.resolve(e.vars)
+			}
+			cv, err := coerceGraphQL(at, v)
+			if err != nil && argErr == nil {
+				argErr = errors.New("Argument \"" + name + "\": " + err.Error())
+			}
+			if cv != nil {
+				args[name] = cv
+			}
+		}
+		if argErr != nil {
+			e.fail(fpath, argErr.Error())
+			res.set(sel.key(), nil)
+			continue
+		}
+
+		v, err := def.resolve(parent, args)
+		if err != nil {
+			e.fail(fpath, err.Error())
+			res.set(sel.key(), nil)
+			continue
+		}
+		res.set(sel.key(), e.complete(def.typ, v, sel, fpath))
+	}
+
+	return res
+}
+
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		return rv.IsNil()
+	}
+	return false
+}
+
+func (e *gqlExecutor) complete(typ string, v interface{}, sel gqlSelection, path []interface{}) interface{} {
+	typ = strings.TrimSuffix(typ, "!")
+
+	// Empty lists are not null
+	if list, ok := v.([]interface{}); ok && strings.HasPrefix(typ, "[") {
+		inner := typ[1 : len(typ)-1]
+		res := []interface{}{}
+		for i, ev := range list {
+			res = append(res, e.complete(inner, ev, sel, gqlPath(path, i)))
+		}
+		return res
+	}
+	if isNil(v) {
+		return nil
+	}
+
+	obj := e.schema[typ]
+	if obj == nil {
+		if sel.selections != nil {
+			e.fail(path, "Field \""+sel.name+"\" of type \""+typ+"\" must not have a selection")
+			return nil
+		}
+		return v
+	}
+	if sel.selections == nil {
+		e.fail(path, "Field \""+sel.name+"\" of type \""+typ+"\" must have a selection")
+		return nil
+	}
+	depth := 0
+	for _, p := range path {
+		if _, ok := p.(string); ok {
+			depth++
+		}
+	}
+	if depth > gqlMaxDepth {
+		e.fail(path, "Query is nested too deeply")
+		return nil
+	}
+	return e.object(obj, v, sel.selections, path)
+}
+
+// GraphQLRequest is the body of POST /graphql.
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+type GraphQLResponse struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []gqlError  `json:"errors,omitempty"`
+}
+
+// executeGraphQL runs a query. Errors of the request itself, like syntax
+// errors, return a response without data and ErrInvalidGraphQL.
+func executeGraphQL(schema gqlSchema, req GraphQLRequest) (GraphQLResponse, error) {
+	invalid := func(msg string) (GraphQLResponse, error) {
+		return GraphQLResponse{Errors: []gqlError{{Message: msg}}}, ErrInvalidGraphQL
+	}
+
+	doc, err := parseGraphQL(req.Query)
+	if err != nil {
+		return invalid(err.Error())
+	}
+
+	var op *gqlOperation
+	for _, o := range doc.operations {
+		if req.OperationName == "" && len(doc.operations) == 1 || o.name == req.OperationName {
+			op = o
+		}
+	}
+	if op == nil {
+		return invalid("Unknown operation")
+	}
+	if op.kind != "query" {
+		return invalid("Only queries are supported")
+	}
+
+	vars := map[string]interface{}{}
+	for _, vd := range op.variables {
+		v, ok := req.Variables[vd.name]
+		if !ok && vd.def != nil {
+			v = vd.def.resolve(nil)
+		}
+		cv, err := coerceGraphQL(vd.typ, v)
+		if err != nil {
+			return invalid("Variable \"$" + vd.name + "\": " + err.Error())
+		}
+		vars[vd.name] = cv
+	}
+
+	e := &gqlExecutor{schema: schema, fragments: doc.fragments, vars: vars}
+	data := e.object(schema["Query"], nil, op.selections, nil)
+
+	return GraphQLResponse{Data: data, Errors: e.errors}, nil
+}
@@ -0,0 +1,478 @@
+package aime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The GraphQL schema of the registry. Visibility follows the REST handlers:
+// reports and revisions are accessible by ID, listings only contain reports
+// whose latest revision is public and issues need to be verified and no longer
+// pending unless the password of the issue or report is given.
+
+var ErrForbidden = errors.New("forbidden")
+
+// gqlConnection is a page of reports.
+type gqlConnection struct {
+	count int
+	next  string
+	items []listItem
+}
+
+// gqlList is a page of another list.
+type gqlList struct {
+	count int
+	next  string
+	nodes []interface{}
+}
+
+// gqlListItem is an element of a list with the key it is sorted by and the
+// time it was created, if it has one.
+type gqlListItem struct {
+	key     string
+	created time.Time
+	node    interface{}
+}
+
+// gqlField is an answered question of a revision.
+type gqlField struct {
+	path     string
+	question *Question
+	values   []string
+}
+
+var gqlListingArgs = map[string]string{
+	"first":         "Int",
+	"after":         "String",
+	"sort":          "String",
+	"createdAfter":  "String",
+	"createdBefore": "String",
+	"updatedAfter":  "String",
+	"updatedBefore": "String",
+}
+
+var gqlPageArgs = map[string]string{
+	"first": "Int",
+	"after": "String",
+}
+
+func gqlStringArg(args map[string]interface{}, name string) string {
+	s, _ := args[name].(string)
+	return s
+}
+
+func gqlIntArg(args map[string]interface{}, name string, def int) int {
+	if i, ok := args[name].(int); ok {
+		return i
+	}
+	return def
+}
+
+func gqlStrings(ss []string) []interface{} {
+	l := []interface{}{}
+	for _, s := range ss {
+		l = append(l, s)
+	}
+	return l
+}
+
+// gqlPage pages a list sorted by key like the report listings: after is the
+// cursor of the previous page, and elements created after the first page are
+// left out of the following ones.
+func gqlPage(items []gqlListItem, args map[string]interface{}) (interface{}, error) {
+	q := url.Values{}
+	if after := gqlStringArg(args, "after"); after != "" {
+		q.Set("cursor", after)
+	}
+	if first, ok := args["first"].(int); ok {
+		q.Set("l", strconv.Itoa(first))
+	}
+
+	l, err := parseListing(q, unlimited, 100)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []gqlListItem
+	for _, it := range items {
+		if !it.created.After(l.at) {
+			matched = append(matched, it)
+		}
+	}
+
+	start := 0
+	if l.cursor != nil {
+		start = sort.Search(len(matched), func(i int) bool {
+			return matched[i].key > l.cursor.id
+		})
+	}
+	end := start + l.limit
+	if l.limit < 0 || end > len(matched) {
+		end = len(matched)
+	}
+
+	page := &gqlList{count: len(matched), nodes: []interface{}{}}
+	for _, it := range matched[start:end] {
+		page.nodes = append(page.nodes, it.node)
+	}
+	if end < len(matched) && end > start {
+		page.next = encodeCursor(l.at, listingCursor{id: matched[end-1].key})
+	}
+	return page, nil
+}
+
+// gqlNumberKey is the key of numbered elements, which sorts like the number.
+func gqlNumberKey(n int) string {
+	return fmt.Sprintf("%010d", n)
+}
+
+// entryItems lists index entries by value.
+func entryItems(es []*indexEntry) []gqlListItem {
+	var items []gqlListItem
+	for _, e := range es {
+		items = append(items, gqlListItem{key: e.value, node: e})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].key < items[j].key
+	})
+	return items
+}
+
+// connection lists the reports with listing arguments named like the query
+// parameters of the REST listings.
+func (s *Server) connection(reports []string, args map[string]interface{}) (interface{}, error) {
+	q := url.Values{}
+	for arg, param := range map[string]string{
+		"after":         "cursor",
+		"sort":          "sort",
+		"createdAfter":  "created_after",
+		"createdBefore": "created_before",
+		"updatedAfter":  "updated_after",
+		"updatedBefore": "updated_before",
+	} {
+		if v := gqlStringArg(args, arg); v != "" {
+			q.Set(param, v)
+		}
+	}
+	if first, ok := args["first"].(int); ok {
+		q.Set("l", strconv.Itoa(first))
+	}
+
+	l, err := parseListing(q, 100, 100)
+	if err != nil {
+		return nil, err
+	}
+	count, page, next := l.apply(s.listItems(reports), s.DB.GetRevision)
+	return &gqlConnection{count, next, page}, nil
+}
+
+func (s *Server) publicReports() []string {
+	var ids []string
+	for rev := range s.DB.GetLatestRevisions(false) {
+		ids = append(ids, rev.ReportID)
+	}
+	return ids
+}
+
+func (s *Server) fields(rev *Revision, path string) []interface{} {
+	var ans interface{}
+	json.Unmarshal(rev.Answers, &ans)
+
+	var fs []interface{}
+	var last *gqlField
+	for _, f := range flattenAnswers(s.DB.questions, ans, "") {
+		if path != "" && f.path != path && !strings.HasPrefix(f.path, path+".") {
+			continue
+		}
+		if last != nil && last.path == f.path {
+			last.values = append(last.values, f.value)
+			continue
+		}
+		last = &gqlField{f.path, questionAt(s.DB.questions, splitField(f.path)), []string{f.value}}
+		fs = append(fs, last)
+	}
+	return fs
+}
+
+func (s *Server) graphQLSchema() gqlSchema {
+	st := s.DB.Settings.withDefaults()
+	kwg := s.DB.GetKeywordGroups()
+
+	indexValues := func(name string, rev *Revision) []interface{} {
+		ix := s.DB.getIndex(name)
+		if ix == nil {
+			ix = &index{definition: st.index(name), entries: map[string]*indexEntry{}}
+		}
+		return gqlStrings(ix.values(s.DB.questions, kwg, rev.Answers))
+	}
+
+	schema := gqlSchema{}
+
+	schema["Query"] = &gqlObject{"Query", map[string]gqlFieldDef{
+		"report": {"Report", map[string]string{"id": "ID!"}, func(_ interface{}, args map[string]interface{}) (interface{}, error) {
+			return s.DB.GetReport(gqlStringArg(args, "id")), nil
+		}},
+		"reports": {"ReportConnection", gqlListingArgs, func(_ interface{}, args map[string]interface{}) (interface{}, error) {
+			return s.connection(s.publicReports(), args)
+		}},
+		"keyword": {"Keyword", map[string]string{"value": "String!"}, func(_ interface{}, args map[string]interface{}) (interface{}, error) {
+			return s.DB.GetKeyword(gqlStringArg(args, "value")), nil
+		}},
+		"keywords": {"KeywordList", gqlPageArgs, func(_ interface{}, args map[string]interface{}) (interface{}, error) {
+			return gqlPage(entryItems(s.DB.GetKeywords()), args)
+		}},
+		"category": {"Category", map[string]string{"value": "String!"}, func(_ interface{}, args map[string]interface{}) (interface{}, error) {
+			return s.DB.GetCategory(gqlStringArg(args, "value")), nil
+		}},
+		"categories": {"CategoryList", gqlPageArgs, func(_ interface{}, args map[string]interface{}) (interface{}, error) {
+			return gqlPage(entryItems(s.DB.GetCategories()), args)
+		}},
+	}}
+
+	schema["ReportConnection"] = &gqlObject{"ReportConnection", map[string]gqlFieldDef{
+		"count": {"Int", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p.(*gqlConnection).count, nil
+		}},
+		"next": {"String", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			if next := p.(*gqlConnection).next; next != "" {
+				return next, nil
+			}
+			return nil, nil
+		}},
+		"nodes": {"[Report]", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			l := []interface{}{}
+			for _, it := range p.(*gqlConnection).items {
+				l = append(l, it.report)
+			}
+			return l, nil
+		}},
+	}}
+
+	for list, node := range map[string]string{
+		"KeywordList":  "Keyword",
+		"CategoryList": "Category",
+		"RevisionList": "Revision",
+		"IssueList":    "Issue",
+	} {
+		schema[list] = &gqlObject{list, map[string]gqlFieldDef{
+			"count": {"Int", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+				return p.(*gqlList).count, nil
+			}},
+			"next": {"String", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+				if next := p.(*gqlList).next; next != "" {
+					return next, nil
+				}
+				return nil, nil
+			}},
+			"nodes": {"[" + node + "]", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+				return p.(*gqlList).nodes, nil
+			}},
+		}}
+	}
+
+	schema["Report"] = &gqlObject{"Report", map[string]gqlFieldDef{
+		"id": {"ID", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p.(*Report).ID, nil
+		}},
+		"createdAt": {"String", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p.(*Report).CreatedAt, nil
+		}},
+		"updatedAt": {"String", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p.(*Report).UpdatedAt, nil
+		}},
+		"public": {"Boolean", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			rev := s.DB.LatestRevision(p.(*Report).ID)
+			return rev != nil && rev.Public, nil
+		}},
+		"revisionCount": {"Int", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p.(*Report).Revisions, nil
+		}},
+		"latest": {"Revision", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			rp := p.(*Report)
+			return s.DB.GetRevision(rp.ID, rp.Revisions), nil
+		}},
+		"revision": {"Revision", map[string]string{"version": "Int!"}, func(p interface{}, args map[string]interface{}) (interface{}, error) {
+			return s.DB.GetRevision(p.(*Report).ID, gqlIntArg(args, "version", 0)), nil
+		}},
+		"revisions": {"RevisionList", gqlPageArgs, func(p interface{}, args map[string]interface{}) (interface{}, error) {
+			rp := p.(*Report)
+			var items []gqlListItem
+			for ver := 1; ver <= rp.Revisions; ver++ {
+				if rev := s.DB.GetRevision(rp.ID, ver); rev != nil {
+					items = append(items, gqlListItem{gqlNumberKey(ver), rev.CreatedAt, rev})
+				}
+			}
+			return gqlPage(items, args)
+		}},
+		"issues": {"IssueList", gqlPageArgs, func(p interface{}, args map[string]interface{}) (interface{}, error) {
+			var items []gqlListItem
+			for iss := range s.DB.GetReportIssues(p.(*Report).ID, false) {
+				items = append(items, gqlListItem{gqlNumberKey(iss.ID), iss.CreatedAt, iss})
+			}
+			sort.Slice(items, func(i, j int) bool {
+				return items[i].key < items[j].key
+			})
+			return gqlPage(items, args)
+		}},
+		"issue": {"Issue", map[string]string{"id": "Int!", "password": "String"}, func(p interface{}, args map[string]interface{}) (interface{}, error) {
+			rp := p.(*Report)
+			iss := s.DB.GetIssue(rp.ID, gqlIntArg(args, "id", 0))
+			if iss == nil || iss.Deleted {
+				return nil, nil
+			}
+			if !visibleIssue(rp, iss, gqlStringArg(args, "password")) {
+				return nil, ErrForbidden
+			}
+			return iss, nil
+		}},
+	}}
+
+	schema["Revision"] = &gqlObject{"Revision", map[string]gqlFieldDef{
+		"report": {"Report", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return s.DB.GetReport(p.(*Revision).ReportID), nil
+		}},
+		"version": {"Int", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p.(*Revision).Version, nil
+		}},
+		"createdAt": {"String", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p.(*Revision).CreatedAt, nil
+		}},
+		"public": {"Boolean", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p.(*Revision).Public, nil
+		}},
+		"answers": {"String", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return string(p.(*Revision).Answers), nil
+		}},
+		"title": {"String", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return ExtractField(s.DB.questions, p.(*Revision).Answers, splitField(st.TitleField)), nil
+		}},
+		"authors": {"[String]", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return gqlStrings(ExtractFields(s.DB.questions, p.(*Revision).Answers, splitField(st.AuthorsField))), nil
+		}},
+		"keywords": {"[String]", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return indexValues("keywords", p.(*Revision)), nil
+		}},
+		"categories": {"[String]", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return indexValues("categories", p.(*Revision)), nil
+		}},
+		"field": {"Field", map[string]string{"path": "String!"}, func(p interface{}, args map[string]interface{}) (interface{}, error) {
+			path := gqlStringArg(args, "path")
+			if q := questionAt(s.DB.questions, splitField(path)); q == nil || q.Type == "complex" || q.Type == "list" {
+				return nil, errors.New("no question " + path)
+			}
+			for _, f := range s.fields(p.(*Revision), path) {
+				if f.(*gqlField).path == path {
+					return f, nil
+				}
+			}
+			return nil, nil
+		}},
+		"fields": {"[Field]", map[string]string{"path": "String"}, func(p interface{}, args map[string]interface{}) (interface{}, error) {
+			return s.fields(p.(*Revision), gqlStringArg(args, "path")), nil
+		}},
+	}}
+
+	schema["Field"] = &gqlObject{"Field", map[string]gqlFieldDef{
+		"path": {"String", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p.(*gqlField).path, nil
+		}},
+		"type": {"String", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p.(*gqlField).question.Type, nil
+		}},
+		"title": {"String", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p.(*gqlField).question.Title, nil
+		}},
+		"question": {"String", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p.(*gqlField).question.Question, nil
+		}},
+		"value": {"String", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return strings.Join(p.(*gqlField).values, "|"), nil
+		}},
+		"values": {"[String]", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return gqlStrings(p.(*gqlField).values), nil
+		}},
+		"boolean": {"Boolean", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			f := p.(*gqlField)
+			if f.question.Type != "boolean" {
+				return nil, nil
+			}
+			return f.values[0] == "true", nil
+		}},
+	}}
+
+	schema["Issue"] = &gqlObject{"Issue", map[string]gqlFieldDef{
+		"id": {"Int", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p.(*Issue).ID, nil
+		}},
+		"report": {"Report", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return s.DB.GetReport(p.(*Issue).ReportID), nil
+		}},
+		"revision": {"Revision", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			iss := p.(*Issue)
+			return s.DB.GetRevision(iss.ReportID, iss.RevisionID), nil
+		}},
+		"name": {"String", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p.(*Issue).Name, nil
+		}},
+		"createdAt": {"String", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p.(*Issue).CreatedAt, nil
+		}},
+		"type": {"Int", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p.(*Issue).Type, nil
+		}},
+		"field": {"[String]", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return gqlStrings(p.(*Issue).Field), nil
+		}},
+		"content": {"String", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p.(*Issue).Content, nil
+		}},
+		"pending": {"Boolean", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p.(*Issue).Pending(), nil
+		}},
+		"answers": {"[Answer]", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			l := []interface{}{}
+			for _, a := range p.(*Issue).Answers {
+				l = append(l, a)
+			}
+			return l, nil
+		}},
+	}}
+
+	schema["Answer"] = &gqlObject{"Answer", map[string]gqlFieldDef{
+		"id": {"Int", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p.(Answer).ID, nil
+		}},
+		"createdAt": {"String", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p.(Answer).CreatedAt, nil
+		}},
+		"content": {"String", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p.(Answer).Content, nil
+		}},
+		"owner": {"Boolean", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p.(Answer).Owner, nil
+		}},
+	}}
+
+	// Keywords and categories are both index entries
+	entryFields := map[string]gqlFieldDef{
+		"value": {"String", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p.(*indexEntry).value, nil
+		}},
+		"count": {"Int", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return len(p.(*indexEntry).reports), nil
+		}},
+		"reports": {"ReportConnection", gqlListingArgs, func(p interface{}, args map[string]interface{}) (interface{}, error) {
+			return s.connection(p.(*indexEntry).reports, args)
+		}},
+	}
+	schema["Keyword"] = &gqlObject{"Keyword", entryFields}
+	schema["Category"] = &gqlObject{"Category", entryFields}
+
+	return schema
+}
@@ -0,0 +1,306 @@
+package aime
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseGraphQL(t *testing.T) {
+	doc, err := parseGraphQL(`
+		# Comment
+		query Reports($n: Int = 10, $id: ID!) {
+			a: report(id: $id) { ...R @include(if: true) }
+			reports(first: $n, sort: "created") { nodes { id } }
+		}
+		fragment R on Report { id, latest { version } }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := doc.operations[0]
+	if op.name != "Reports" || len(op.variables) != 2 || op.variables[1].typ != "ID!" || op.variables[0].def.raw != "10" {
+		t.Fatal(op)
+	}
+	if op.selections[0].alias != "a" || op.selections[0].selections[0].spread != "R" || doc.fragments["R"].typeCond != "Report" {
+		t.Fatal(op.selections)
+	}
+
+	for _, q := range []string{`{ report(id: "a) { id } }`, `{ report { }`, `query { a } }`, `fragment F on Report { id }`} {
+		if _, err := parseGraphQL(q); err == nil {
+			t.Fatal(q)
+		}
+	}
+}
+
+func TestExecuteGraphQL(t *testing.T) {
+	schema := gqlSchema{
+		"Query": {"Query", map[string]gqlFieldDef{
+			"hello": {"String", map[string]string{"name": "String!", "times": "Int"}, func(_ interface{}, args map[string]interface{}) (interface{}, error) {
+				return strings.Repeat("hello "+args["name"].(string), gqlIntArg(args, "times", 1)), nil
+			}},
+		}},
+	}
+
+	resp, err := executeGraphQL(schema, GraphQLRequest{
+		Query:     `query($n: Int) { b: hello(name: "b", times: $n) a: hello(name: "a") @skip(if: false) __typename }`,
+		Variables: map[string]interface{}{"n": 2.0},
+	})
+	respBytes, _ := json.Marshal(resp)
+	if err != nil || string(respBytes) != `{"data":{"b":"hello bhello b","a":"hello a","__typename":"Query"}}` {
+		t.Fatal(string(respBytes))
+	}
+
+	resp, err = executeGraphQL(schema, GraphQLRequest{Query: `{ hello(name: 1) bye }`})
+	if err != nil || len(resp.Errors) != 2 {
+		t.Fatal(resp.Errors)
+	}
+
+	if _, err := executeGraphQL(schema, GraphQLRequest{Query: `mutation { hello }`}); err == nil {
+		t.Fatal()
+	}
+}
+
+func TestServer_GraphQL(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	r1 := db.CreateReport("", true)
+	db.CreateRevision(r1.ID, "", json.RawMessage("{\"MD\":{\"1\":\"First\"}}"), r1.Token, true)
+	db.CreateRevision(r1.ID, "", json.RawMessage("{\"MD\":{\"1\":\"First, again\",\"5\":[{\"custom\":false,\"value\":\"omics\"},"+
+		"{\"custom\":true,\"value\":\"ecg\"}]},\"P\":{\"2\":{\"1\":true}}}"), r1.Token, true)
+	r2 := db.CreateReport("", false)
+	db.CreateRevision(r2.ID, "", json.RawMessage("{\"MD\":{\"1\":\"Hidden\"}}"), r2.Token, false)
+
+	i1 := db.CreateIssue(r1.ID, "Jane", "jane@test.de", []string{"MD", "1"}, "Typo", 0)
+	db.ValidateIssue(r1.ID, i1.ID, i1.Token)
+
+	srv := Server{DB: db}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	post := func(query string, vars map[string]interface{}) (int, map[string]interface{}) {
+		reqBytes, _ := json.Marshal(GraphQLRequest{Query: query, Variables: vars})
+		resp, _ := http.Post(ts.URL+"/graphql", "application/json", bytes.NewReader(reqBytes))
+		respBytes, _ := ioutil.ReadAll(resp.Body)
+		res := map[string]interface{}{}
+		json.Unmarshal(respBytes, &res)
+		return resp.StatusCode, res
+	}
+
+	code, res := post(`{ reports { count nodes { id latest { version title keywords
+		field(path: "MD.1") { value } marker: field(path: "P.2.1") { boolean } } } } }`, nil)
+	resBytes, _ := json.Marshal(res)
+	exp := `{"data":{"reports":{"count":1,"nodes":[{"id":"` + r1.ID + `","latest":{"field":{"value":"First, again"},` +
+		`"keywords":["omics","ecg"],"marker":{"boolean":true},"title":"First, again","version":2}}]}}}`
+	if code != 200 || string(resBytes) != exp {
+		t.Fatal(string(resBytes))
+	}
+
+	// Hidden reports are accessible by ID, like on /report/{id}
+	_, res = post(`query($id: ID!) { report(id: $id) { public revisions { nodes { version report { id } } } } }`, map[string]interface{}{"id": r2.ID})
+	resBytes, _ = json.Marshal(res)
+	if string(resBytes) != `{"data":{"report":{"public":false,"revisions":{"nodes":[{"report":{"id":"`+r2.ID+`"},"version":1}]}}}}` {
+		t.Fatal(string(resBytes))
+	}
+
+	// Lists are paged by cursor as of the first page
+	page := func(query string, vars map[string]interface{}) (string, []interface{}) {
+		_, res := post(query, vars)
+		data, _ := res["data"].(map[string]interface{})
+		for _, k := range []string{"report", "revisions"} {
+			if m, ok := data[k].(map[string]interface{}); ok {
+				data = m
+			}
+		}
+		next, _ := data["next"].(string)
+		nodes, _ := data["nodes"].([]interface{})
+		return next, nodes
+	}
+	db.BuildIndexes()
+	_, res = post(`{ keywords(first: 1) { count next nodes { value } } }`, nil)
+	resBytes, _ = json.Marshal(res)
+	if !strings.Contains(string(resBytes), `"count":2`) || !strings.Contains(string(resBytes), `"nodes":[{"value":"ecg"}]`) {
+		t.Fatal(string(resBytes))
+	}
+	if _, res = post(`{ keywords(after: "nope") { count } }`, nil); res["errors"] == nil {
+		t.Fatal(res)
+	}
+	revisions := `query($id: ID!, $n: Int, $after: String) { report(id: $id) { revisions(first: $n, after: $after) { next nodes { version } } } }`
+	next, nodes := page(revisions, map[string]interface{}{"id": r1.ID, "n": 1})
+	if next == "" || len(nodes) != 1 {
+		t.Fatal(nodes)
+	}
+	db.CreateRevision(r1.ID, "", json.RawMessage("{\"MD\":{\"1\":\"Third\"}}"), r1.Token, true)
+	next, nodes = page(revisions, map[string]interface{}{"id": r1.ID, "after": next})
+	if next != "" || len(nodes) != 1 || nodes[0].(map[string]interface{})["version"] != 2.0 {
+		t.Fatal(nodes)
+	}
+
+	// Pending issues need a password
+	query := `query($id: ID!, $p: String) { report(id: $id) { issues { nodes { id } } issue(id: 1, password: $p) { content } } }`
+	_, res = post(query, map[string]interface{}{"id": r1.ID})
+	resBytes, _ = json.Marshal(res)
+	if !strings.Contains(string(resBytes), `"issues":{"nodes":[]}`) || !strings.Contains(string(resBytes), `"message":"forbidden"`) {
+		t.Fatal(string(resBytes))
+	}
+	_, res = post(query, map[string]interface{}{"id": r1.ID, "p": r1.Token})
+	resBytes, _ = json.Marshal(res)
+	if !strings.Contains(string(resBytes), `"issue":{"content":"Typo"}`) || res["errors"] != nil {
+		t.Fatal(string(resBytes))
+	}
+
+	resp, _ := http.Get(ts.URL + "/graphql?query=" + url.QueryEscape("{ report(id: \"nope\") { id } }"))
+	respBytes, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || string(respBytes) != `{"data":{"report":null}}` {
+		t.Fatal(string(respBytes))
+	}
+
+	if code, _ := post(`{ report(id: "x") { id }`, nil); code != 400 {
+		t.Fatal(code)
+	}
+}
+
+// gqlTestSchema has a recursive type to test the executor with. Nodes are
+// their names, children are named after their parent.
+func gqlTestSchema() gqlSchema {
+	node := map[string]gqlFieldDef{
+		"name": {"String", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return p, nil
+		}},
+		"child": {"Node", map[string]string{"name": "String!"}, func(p interface{}, args map[string]interface{}) (interface{}, error) {
+			return p.(string) + "." + gqlStringArg(args, "name"), nil
+		}},
+		"children": {"[Node]", map[string]string{"n": "Int"}, func(p interface{}, args map[string]interface{}) (interface{}, error) {
+			l := []interface{}{}
+			for i := 0; i < gqlIntArg(args, "n", 1); i++ {
+				l = append(l, p.(string)+"."+strconv.Itoa(i))
+			}
+			return l, nil
+		}},
+		"nothing": {"Node", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return nil, nil
+		}},
+		"fail": {"String", nil, func(p interface{}, _ map[string]interface{}) (interface{}, error) {
+			return nil, errors.New("failed")
+		}},
+	}
+	return gqlSchema{
+		"Query": {"Query", map[string]gqlFieldDef{
+			"node": {"Node", map[string]string{"name": "String!"}, func(_ interface{}, args map[string]interface{}) (interface{}, error) {
+				return gqlStringArg(args, "name"), nil
+			}},
+			"echo": {"String", map[string]string{"i": "Int", "f": "Float", "id": "ID", "b": "Boolean", "l": "[Int]"}, func(_ interface{}, args map[string]interface{}) (interface{}, error) {
+				return fmt.Sprint(args["i"], " ", args["f"], " ", args["id"], " ", args["b"], " ", args["l"]), nil
+			}},
+		}},
+		"Node": {"Node", node},
+	}
+}
+
+func TestExecuteGraphQL_Conformance(t *testing.T) {
+	nested := func(n int) string {
+		return `{ node(name: "x") { ` + strings.Repeat(`child(name: "c") { `, n) + "name" + strings.Repeat(" }", n) + " } }"
+	}
+
+	cases := []struct {
+		name      string
+		query     string
+		vars      map[string]interface{}
+		operation string
+		// want is the response, or the start of the error message of invalid
+		// requests
+		want    string
+		invalid bool
+	}{
+		// Aliases
+		{name: "aliases", query: `{ a: node(name: "x") { name } b: node(name: "y") { n: name } }`,
+			want: `{"data":{"a":{"name":"x"},"b":{"n":"y"}}}`},
+		{name: "merged fields", query: `{ node(name: "x") { name } node(name: "x") { child(name: "c") { name } } }`,
+			want: `{"data":{"node":{"name":"x","child":{"name":"x.c"}}}}`},
+
+		// Fragments
+		{name: "named fragments", query: `{ node(name: "x") { ...A } } fragment A on Node { name ...B } fragment B on Node { child(name: "c") { name } }`,
+			want: `{"data":{"node":{"name":"x","child":{"name":"x.c"}}}}`},
+		{name: "inline fragments", query: `{ node(name: "x") { ... on Node { name } ... on Query { fail } ... { t: __typename } } }`,
+			want: `{"data":{"node":{"name":"x","t":"Node"}}}`},
+		{name: "fragment of another type", query: `{ node(name: "x") { name ...Q } } fragment Q on Query { node(name: "y") { name } }`,
+			want: `{"data":{"node":{"name":"x"}}}`},
+		{name: "unknown fragment", query: `{ node(name: "x") { ...A } }`, want: "invalid graphql: unknown fragment A", invalid: true},
+		{name: "fragment cycle", query: `{ node(name: "x") { ...A } } fragment A on Node { ...B } fragment B on Node { name ...A }`,
+			want: "invalid graphql: fragment A spreads itself", invalid: true},
+
+		// Variables and directives
+		{name: "directives", query: `query($yes: Boolean!) { a: node(name: "a") @include(if: $yes) { name } b: node(name: "b") @skip(if: $yes) { name } }`,
+			vars: map[string]interface{}{"yes": true}, want: `{"data":{"a":{"name":"a"}}}`},
+		{name: "variable defaults", query: `query($n: Int = 2) { node(name: "x") { children(n: $n) { name } } }`,
+			want: `{"data":{"node":{"children":[{"name":"x.0"},{"name":"x.1"}]}}}`},
+		{name: "variable coercion", query: `query($i: Int, $f: Float, $id: ID, $b: Boolean = true, $l: [Int]) { echo(i: $i, f: $f, id: $id, b: $b, l: $l) }`,
+			vars: map[string]interface{}{"i": 2.0, "f": 1.0, "id": 7.0, "l": 3.0}, want: `{"data":{"echo":"2 1 7 true [3]"}}`},
+		{name: "literal coercion", query: `{ echo(i: 2, f: 1, id: 7, b: false, l: [1, 2]) }`,
+			want: `{"data":{"echo":"2 1 7 false [1 2]"}}`},
+		{name: "missing variable", query: `query($name: String!) { node(name: $name) { name } }`,
+			want: `Variable "$name": must not be null`, invalid: true},
+		{name: "variable of wrong type", query: `query($i: Int) { echo(i: $i) }`, vars: map[string]interface{}{"i": "x"},
+			want: `Variable "$i": expected Int`, invalid: true},
+		{name: "fraction for Int", query: `query($i: Int) { echo(i: $i) }`, vars: map[string]interface{}{"i": 2.5},
+			want: `Variable "$i": expected Int`, invalid: true},
+
+		// Operations
+		{name: "named operation", query: `query A { a: node(name: "a") { name } } query B { b: node(name: "b") { name } }`, operation: "B",
+			want: `{"data":{"b":{"name":"b"}}}`},
+		{name: "operation not named", query: `query A { a: echo } query B { b: echo }`, want: "Unknown operation", invalid: true},
+		{name: "unknown operation", query: `query A { a: echo }`, operation: "B", want: "Unknown operation", invalid: true},
+		{name: "mutation", query: `mutation { echo }`, want: "Only queries are supported", invalid: true},
+
+		// Errors of fields keep the rest of the data
+		{name: "resolver errors", query: `{ node(name: "x") { name children(n: 2) { fail } } }`,
+			want: `{"data":{"node":{"name":"x","children":[{"fail":null},{"fail":null}]}},"errors":[` +
+				`{"message":"failed","path":["node","children",0,"fail"]},{"message":"failed","path":["node","children",1,"fail"]}]}`},
+		{name: "field errors", query: `{ node(name: "x") { nope } b: node(name: 1) { name } c: node(name: "x", extra: 1) { name } }`,
+			want: `{"data":{"node":{"nope":null},"b":null,"c":null},"errors":[` +
+				`{"message":"Cannot query field \"nope\" on type \"Node\"","path":["node","nope"]},` +
+				`{"message":"Argument \"name\": expected String","path":["b"]},` +
+				`{"message":"Unknown argument \"extra\"","path":["c"]}]}`},
+		{name: "selections", query: `{ node(name: "x") { name { x } } n: node(name: "y") }`,
+			want: `{"data":{"node":{"name":null},"n":null},"errors":[` +
+				`{"message":"Field \"name\" of type \"String\" must not have a selection","path":["node","name"]},` +
+				`{"message":"Field \"node\" of type \"Node\" must have a selection","path":["n"]}]}`},
+		{name: "null objects", query: `{ node(name: "x") { nothing { name } } }`, want: `{"data":{"node":{"nothing":null}}}`},
+
+		// Depth limit
+		{name: "deepest query", query: nested(gqlMaxDepth - 1),
+			want: `{"data":{"node":{"child":` + strings.Repeat(`{"child":`, gqlMaxDepth-2) + `{"name":"x` + strings.Repeat(".c", gqlMaxDepth-1) + `"}` + strings.Repeat("}", gqlMaxDepth-1) + `}}`},
+		{name: "query too deep", query: nested(gqlMaxDepth), want: "Query is nested too deeply"},
+
+		// Syntax
+		{name: "strings", query: `{ a: node(name: "a\"bé\n") { name } b: node(name: """ block "quoted" """) { name } }`,
+			want: `{"data":{"a":{"name":"a\"bé\n"},"b":{"name":"block \"quoted\""}}}`},
+		{name: "invalid escape", query: `{ node(name: "\x") { name } }`, want: "invalid graphql: syntax error at 13: invalid escape", invalid: true},
+		{name: "unterminated string", query: `{ node(name: "x) { name } }`, want: "invalid graphql: syntax error at 13: unterminated string", invalid: true},
+		{name: "variable in default", query: `query($a: Int = $b) { echo }`, want: "invalid graphql: syntax error at 16: unexpected variable", invalid: true},
+	}
+
+	schema := gqlTestSchema()
+	for _, c := range cases {
+		resp, err := executeGraphQL(schema, GraphQLRequest{Query: c.query, Variables: c.vars, OperationName: c.operation})
+		if c.invalid {
+			if err == nil || len(resp.Errors) != 1 || !strings.HasPrefix(resp.Errors[0].Message, c.want) {
+				t.Fatal(c.name, err, resp.Errors)
+			}
+			continue
+		}
+		respBytes, _ := json.Marshal(resp)
+		if err != nil || !strings.Contains(string(respBytes), c.want) {
+			t.Fatal(c.name, err, string(respBytes))
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package aime
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+type indexEntry struct {
+	value   string
+	reports []string
+}
+
+type index struct {
+	definition IndexDefinition
+	entries    map[string]*indexEntry
+	suggest    *suggestIndex
+
+	taxonomy *Taxonomy
+	// concepts holds the reports of every concept including its narrower concepts
+	concepts map[string]*indexEntry
+}
+
+// conceptNode is a concept of the taxonomy with its rolled-up entry. Values
+// that are not part of the taxonomy are nodes without concept.
+type conceptNode struct {
+	concept  *Concept
+	entry    *indexEntry
+	children []*conceptNode
+}
+
+func (ix *index) add(value string, reportID string) {
+	if e, ok := ix.entries[value]; ok {
+		e.reports = append(e.reports, reportID)
+	} else {
+		ix.entries[value] = &indexEntry{
+			value:   value,
+			reports: []string{reportID},
+		}
+	}
+}
+
+func (ix *index) sorted() []*indexEntry {
+	var es []*indexEntry
+	for _, e := range ix.entries {
+		es = append(es, e)
+	}
+	sort.Slice(es, func(i, j int) bool {
+		if len(es[i].reports) != len(es[j].reports) {
+			return len(es[j].reports) < len(es[i].reports)
+		}
+		return es[i].value < es[j].value
+	})
+	return es
+}
+
+func (ix *index) buildSuggest(kwg KeywordGroups) {
+	ix.suggest = newSuggestIndex()
+	for _, e := range ix.entries {
+		ix.suggest.insert(e.value, e)
+	}
+	if !ix.definition.Groups {
+		return
+	}
+	// Synonyms lead to their canonical targets
+	for _, g := range kwg {
+		for _, s := range g.Sources {
+			for _, t := range g.Targets {
+				if e, ok := ix.entries[t]; ok {
+					ix.suggest.insert(s, e)
+				}
+			}
+		}
+	}
+}
+
+func (ix *index) buildConcepts(tx *Taxonomy) {
+	if tx == nil || !ix.definition.Taxonomy {
+		return
+	}
+
+	ix.taxonomy = tx
+	ix.concepts = map[string]*indexEntry{}
+
+	reports := map[string]map[string]bool{}
+	for _, e := range ix.entries {
+		c := tx.Find(e.value)
+		if c == nil {
+			continue
+		}
+		for _, id := range tx.ancestors(c) {
+			if reports[id] == nil {
+				reports[id] = map[string]bool{}
+			}
+			for _, r := range e.reports {
+				reports[id][r] = true
+			}
+		}
+	}
+
+	for id, rs := range reports {
+		ce := &indexEntry{value: tx.Get(id).Label}
+		for r := range rs {
+			ce.reports = append(ce.reports, r)
+		}
+		sort.Strings(ce.reports)
+		ix.concepts[id] = ce
+	}
+}
+
+// lookup returns the entry of a value. If the value is a concept of the
+// taxonomy, the reports of its narrower concepts are included.
+func (ix *index) lookup(value string) *indexEntry {
+	e := ix.entries[value]
+
+	c := ix.taxonomy.Find(value)
+	if c == nil {
+		return e
+	}
+	ce, ok := ix.concepts[c.ID]
+	if !ok {
+		return e
+	}
+	if e == nil {
+		return ce
+	}
+
+	merged := &indexEntry{value: e.value}
+	seen := map[string]bool{}
+	for _, r := range append(append([]string{}, e.reports...), ce.reports...) {
+		if !seen[r] {
+			seen[r] = true
+			merged.reports = append(merged.reports, r)
+		}
+	}
+	return merged
+}
+
+func (ix *index) tree() []*conceptNode {
+	var nodes []*conceptNode
+
+	var walk func(cs []*Concept, path map[string]bool) []*conceptNode
+	walk = func(cs []*Concept, path map[string]bool) []*conceptNode {
+		var ns []*conceptNode
+		for _, c := range cs {
+			ce, ok := ix.concepts[c.ID]
+			if !ok || path[c.ID] {
+				continue
+			}
+			path[c.ID] = true
+			ns = append(ns, &conceptNode{
+				concept:  c,
+				entry:    ce,
+				children: walk(ix.taxonomy.Children(c), path),
+			})
+			delete(path, c.ID)
+		}
+		sortConceptNodes(ns)
+		return ns
+	}
+
+	if ix.taxonomy != nil {
+		nodes = walk(ix.taxonomy.Roots(), map[string]bool{})
+	}
+
+	for _, e := range ix.entries {
+		if ix.taxonomy.Find(e.value) == nil {
+			nodes = append(nodes, &conceptNode{entry: e})
+		}
+	}
+	sortConceptNodes(nodes)
+
+	return nodes
+}
+
+func sortConceptNodes(ns []*conceptNode) {
+	sort.Slice(ns, func(i, j int) bool {
+		if len(ns[i].entry.reports) != len(ns[j].entry.reports) {
+			return len(ns[j].entry.reports) < len(ns[i].entry.reports)
+		}
+		return ns[i].entry.value < ns[j].entry.value
+	})
+}
+
+// values extracts the distinct, non-empty values of a revision for this index.
+func (ix *index) values(q Question, kwg KeywordGroups, answers json.RawMessage) []string {
+	vals := ExtractFields(q, answers, splitField(ix.definition.Field))
+
+	if ix.definition.Other != "" {
+		for i, v := range vals {
+			if !strings.EqualFold(v, "other") {
+				continue
+			}
+			custom := normalizeCategory(ExtractField(q, answers, splitField(ix.definition.Other)))
+			if custom == "" {
+				continue
+			}
+			vals[i] = custom
+			// Merge with values that only differ in casing or diacritics,
+			// the first of them by name if there are several
+			merged := ""
+			for name := range ix.entries {
+				if foldKeyword(name) == foldKeyword(custom) && (merged == "" || name < merged) {
+					merged = name
+				}
+			}
+			if merged != "" {
+				vals[i] = merged
+			}
+		}
+	}
+
+	if ix.definition.Groups {
+		vals = kwg.transform(vals)
+	}
+
+	seen := map[string]bool{}
+	var res []string
+	for _, v := range vals {
+		v = strings.TrimSpace(v)
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		res = append(res, v)
+	}
+	return res
+}
+
+func normalizeCategory(c string) string {
+	return clean(strings.Join(strings.Fields(c), " "))
+}
+
+// BuildIndexes rebuilds all indexes defined in the settings and returns the
+// number of distinct values per index.
+func (db *DB) BuildIndexes() map[string]int {
+	return db.buildIndexes(db.Settings.withDefaults().Indexes)
+}
+
+// buildIndexes builds the defined indexes, they replace every index.
+func (db *DB) buildIndexes(defs []IndexDefinition) map[string]int {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	built := map[string]*index{}
+	for _, d := range defs {
+		built[d.Name] = &index{
+			definition: d,
+			entries:    map[string]*indexEntry{},
+		}
+	}
+
+	for rev := range db.GetLatestRevisions(false) {
+		for _, ix := range built {
+			for _, v := range ix.values(db.questions, db.KeywordGroups, rev.Answers) {
+				ix.add(v, rev.ReportID)
+			}
+		}
+	}
+
+	indexes := map[string]*index{}
+	counts := map[string]int{}
+	for name, ix := range built {
+		ix.buildSuggest(db.KeywordGroups)
+		ix.buildConcepts(db.Taxonomy)
+		indexes[name] = ix
+		counts[name] = len(ix.entries)
+	}
+
+	db.indexes = indexes
+
+	return counts
+}
+
+func (db *DB) getIndex(name string) *index {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	return db.indexes[name]
+}
+
+func (db *DB) ExistsIndex(name string) bool {
+	return db.getIndex(name) != nil
+}
+
+func (db *DB) GetIndexEntry(name string, value string) *indexEntry {
+	ix := db.getIndex(name)
+	if ix == nil {
+		return nil
+	}
+	return ix.lookup(value)
+}
+
+// GetIndexTree returns the entries of an index arranged by the taxonomy with
+// rolled-up reports. Without taxonomy, all entries are roots.
+func (db *DB) GetIndexTree(name string) []*conceptNode {
+	ix := db.getIndex(name)
+	if ix == nil {
+		return nil
+	}
+	return ix.tree()
+}
+
+func (db *DB) GetIndexEntries(name string) []*indexEntry {
+	ix := db.getIndex(name)
+	if ix == nil {
+		return nil
+	}
+	return ix.sorted()
+}
+
+func (db *DB) SuggestIndexEntries(name string, prefix string, limit int) []*indexEntry {
+	ix := db.getIndex(name)
+	if ix == nil {
+		return nil
+	}
+	return ix.suggest.lookup(prefix, limit)
+}
+
+// Keywords and categories
+
+func (db *DB) GetKeyword(k string) *indexEntry {
+	return db.GetIndexEntry("keywords", k)
+}
+
+func (db *DB) GetKeywords() []*indexEntry {
+	return db.GetIndexEntries("keywords")
+}
+
+func (db *DB) SuggestKeywords(prefix string, limit int) []*indexEntry {
+	return db.SuggestIndexEntries("keywords", prefix, limit)
+}
+
+func (db *DB) GetCategory(c string) *indexEntry {
+	return db.GetIndexEntry("categories", c)
+}
+
+func (db *DB) GetCategories() []*indexEntry {
+	return db.GetIndexEntries("categories")
+}
@@ -0,0 +1,122 @@
+package aime
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDB_BuildIndexes(t *testing.T) {
+	db := DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	te := []string{
+		"{\"MD\":{\"6\":[{\"1\":\"A\",\"2\":\"Uni X\"},{\"1\":\"B\",\"2\":\"Uni X\"}]},\"R\":{\"2\":{\"1\":{\"4\":{\"custom\":false,\"value\":\"mit\"}}}}}",
+		"{\"MD\":{\"6\":[{\"1\":\"A\",\"2\":\"Uni Y\"}]},\"R\":{\"2\":{\"1\":{\"4\":{\"custom\":true,\"value\":\"WTFPL\"}}}}}",
+		"{\"MD\":{\"6\":[]}}",
+	}
+	for _, jn := range te {
+		r := db.CreateReport("", true)
+		db.CreateRevision(r.ID, "", json.RawMessage(jn), r.Token, true)
+	}
+
+	counts := db.BuildIndexes()
+	if counts["authors"] != 2 || counts["institutions"] != 2 || counts["licenses"] != 2 {
+		t.Fatal(counts)
+	}
+	if counts["keywords"] != 0 {
+		t.Fatal(counts)
+	}
+
+	if len(db.GetIndexEntry("authors", "A").reports) != 2 {
+		t.Fatal()
+	}
+	// Counted once per report
+	if len(db.GetIndexEntry("institutions", "Uni X").reports) != 1 {
+		t.Fatal()
+	}
+	if db.GetIndexEntry("licenses", "MIT License") == nil {
+		t.Fatal()
+	}
+
+	es := db.GetIndexEntries("authors")
+	if len(es) != 2 || es[0].value != "A" {
+		t.Fatal()
+	}
+
+	if db.ExistsIndex("unknown") || db.GetIndexEntries("unknown") != nil {
+		t.Fatal()
+	}
+
+	// A DB without background rebuilds, so the settings can be changed
+	sdb := DB{Dir: db.Dir, questions: db.questions}
+	sdb.BuildIndexes()
+	sdb.Settings = Settings{Indexes: []IndexDefinition{{Name: "titles", Field: "MD.1"}}}
+	counts = sdb.BuildIndexes()
+	if len(counts) != 1 {
+		t.Fatal(counts)
+	}
+	// Indexes that are no longer configured are dropped
+	if !sdb.ExistsIndex("titles") || sdb.ExistsIndex("authors") {
+		t.Fatal()
+	}
+}
+
+func TestServer_Index(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	for _, a := range []string{"Alice", "Bob", "Alice"} {
+		r := db.CreateReport("", true)
+		db.CreateRevision(r.ID, "", json.RawMessage("{\"MD\":{\"1\":\"Title\",\"6\":[{\"1\":\""+a+"\"}]}}"), r.Token, true)
+	}
+	db.BuildIndexes()
+
+	srv := Server{DB: db}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, _ := http.Get(ts.URL + "/index/authors")
+	if resp.StatusCode != 200 {
+		t.Fatal()
+	}
+	respBytes, _ := ioutil.ReadAll(resp.Body)
+	ixResp := IndexResponse{}
+	json.Unmarshal(respBytes, &ixResp)
+	if len(ixResp.Entries) != 2 || ixResp.Entries[0].Value != "Alice" || ixResp.Entries[0].Count != 2 {
+		t.Fatal(string(respBytes))
+	}
+
+	resp, _ = http.Get(ts.URL + "/index/authors/suggest?prefix=bo")
+	respBytes, _ = ioutil.ReadAll(resp.Body)
+	ixResp = IndexResponse{}
+	json.Unmarshal(respBytes, &ixResp)
+	if len(ixResp.Entries) != 1 || ixResp.Entries[0].Value != "Bob" {
+		t.Fatal(string(respBytes))
+	}
+
+	resp, _ = http.Get(ts.URL + "/index/authors/Alice?l=1")
+	if resp.StatusCode != 200 {
+		t.Fatal()
+	}
+	respBytes, _ = ioutil.ReadAll(resp.Body)
+	entryResp := IndexEntryResponse{}
+	json.Unmarshal(respBytes, &entryResp)
+	if entryResp.Count != 2 || len(entryResp.Results) != 1 || entryResp.Results[0].Authors[0] != "Alice" {
+		t.Fatal(string(respBytes))
+	}
+
+	resp, _ = http.Get(ts.URL + "/index/unknown")
+	if resp.StatusCode != 404 {
+		t.Fatal()
+	}
+
+	resp, _ = http.Get(ts.URL + "/index/authors/Carol")
+	if resp.StatusCode != 404 {
+		t.Fatal()
+	}
+}
@@ -0,0 +1,124 @@
+package aime
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+type ldOrganization struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+}
+
+type ldPerson struct {
+	Type        string          `json:"@type"`
+	ID          string          `json:"@id,omitempty"`
+	Name        string          `json:"name"`
+	GivenName   string          `json:"givenName,omitempty"`
+	FamilyName  string          `json:"familyName,omitempty"`
+	Affiliation *ldOrganization `json:"affiliation,omitempty"`
+}
+
+type ldSoftware struct {
+	Type                string   `json:"@type"`
+	Name                string   `json:"name"`
+	Description         string   `json:"description,omitempty"`
+	ApplicationCategory string   `json:"applicationCategory,omitempty"`
+	Keywords            []string `json:"keywords,omitempty"`
+	License             []string `json:"license,omitempty"`
+}
+
+// ldReport is the schema.org markup of a revision: the report is a
+// CreativeWork about the AI, which is a SoftwareApplication.
+type ldReport struct {
+	Context          string         `json:"@context"`
+	Type             string         `json:"@type"`
+	ID               string         `json:"@id"`
+	Identifier       string         `json:"identifier"`
+	Name             string         `json:"name"`
+	Description      string         `json:"description,omitempty"`
+	URL              string         `json:"url"`
+	Version          string         `json:"version"`
+	DateCreated      time.Time      `json:"dateCreated"`
+	DateModified     time.Time      `json:"dateModified"`
+	Author           []ldPerson     `json:"author,omitempty"`
+	Keywords         []string       `json:"keywords,omitempty"`
+	Genre            []string       `json:"genre,omitempty"`
+	Publisher        ldOrganization `json:"publisher"`
+	InLanguage       string         `json:"inLanguage"`
+	IsAccessible     bool           `json:"isAccessibleForFree"`
+	About            ldSoftware     `json:"about"`
+	IsBasedOn        string         `json:"isBasedOn,omitempty"`
+	MainEntityOfPage string         `json:"mainEntityOfPage"`
+}
+
+func (db *DB) jsonLD(rp *Report, rev *Revision) []byte {
+	st := db.Settings.withDefaults()
+	kwg := db.GetKeywordGroups()
+	c := db.citation(rev, true)
+
+	ctIndex := &index{definition: st.index("categories"), entries: map[string]*indexEntry{}}
+	if ix := db.getIndex("categories"); ix != nil {
+		ctIndex = ix
+	}
+	lcIndex := &index{definition: st.index("licenses"), entries: map[string]*indexEntry{}}
+	categories := ctIndex.values(db.questions, kwg, rev.Answers)
+
+	ld := ldReport{
+		Context:      "https://schema.org",
+		Type:         "CreativeWork",
+		ID:           reportURL + rp.ID,
+		Identifier:   rp.ID,
+		Name:         c.title,
+		Description:  c.description,
+		URL:          c.url,
+		Version:      strconv.Itoa(rev.Version),
+		DateCreated:  rp.CreatedAt,
+		DateModified: rev.CreatedAt,
+		Keywords:     c.keywords,
+		Genre:        categories,
+		Publisher: ldOrganization{
+			Type: "Organization",
+			Name: "AIMe Registry",
+			URL:  registryURL,
+		},
+		InLanguage:   "en",
+		IsAccessible: true,
+		About: ldSoftware{
+			Type:        "SoftwareApplication",
+			Name:        c.title,
+			Description: c.description,
+			Keywords:    c.keywords,
+			License:     lcIndex.values(db.questions, kwg, rev.Answers),
+		},
+		MainEntityOfPage: reportURL + rp.ID,
+	}
+	if len(categories) > 0 {
+		ld.About.ApplicationCategory = categories[0]
+	}
+	if rev.Version > 1 {
+		ld.IsBasedOn = reportURL + rp.ID + "/" + strconv.Itoa(rev.Version-1)
+	}
+
+	for _, a := range c.authors {
+		p := ldPerson{
+			Type:       "Person",
+			ID:         a.orcid,
+			Name:       a.given + " " + a.family,
+			GivenName:  a.given,
+			FamilyName: a.family,
+		}
+		if a.given == "" {
+			p.Name = a.family
+		}
+		if a.affiliation != "" {
+			p.Affiliation = &ldOrganization{Type: "Organization", Name: a.affiliation}
+		}
+		ld.Author = append(ld.Author, p)
+	}
+
+	ldBytes, _ := json.Marshal(ld)
+	return ldBytes
+}
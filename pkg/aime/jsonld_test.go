@@ -0,0 +1,64 @@
+package aime
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer_JSONLD(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	rp := db.CreateReport("", true)
+	jn := "{\"MD\":{\"1\":\"Heart failure\",\"5\":[{\"custom\":true,\"value\":\"ecg\"}],\"6\":[{\"1\":\"Jane Doe\",\"2\":\"University\",\"4\":\"0000-0002-1825-0097\"}]}," +
+		"\"P\":{\"3\":{\"1\":{\"custom\":false,\"value\":\"cf\"}}}}"
+	db.CreateRevision(rp.ID, "", json.RawMessage(jn), rp.Token, true)
+	db.CreateRevision(rp.ID, "", json.RawMessage(jn), rp.Token, true)
+
+	srv := Server{DB: db}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL+"/report/"+rp.ID, nil)
+	req.Header.Set("Accept", "application/ld+json")
+	resp, _ := http.DefaultClient.Do(req)
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "application/ld+json" {
+		t.Fatal(resp.StatusCode)
+	}
+	respBytes, _ := ioutil.ReadAll(resp.Body)
+	ld := ldReport{}
+	json.Unmarshal(respBytes, &ld)
+	if ld.Context != "https://schema.org" || ld.Name != "Heart failure" || ld.Version != "2" || ld.IsBasedOn != reportURL+rp.ID+"/1" {
+		t.Fatal(string(respBytes))
+	}
+	if len(ld.Author) != 1 || ld.Author[0].ID != "https://orcid.org/0000-0002-1825-0097" || ld.Author[0].Affiliation.Name != "University" {
+		t.Fatal(string(respBytes))
+	}
+	if ld.About.Type != "SoftwareApplication" || ld.About.ApplicationCategory != "Classification" || ld.Keywords[0] != "ecg" {
+		t.Fatal(string(respBytes))
+	}
+
+	req, _ = http.NewRequest("GET", ts.URL+"/report/"+rp.ID+"/1", nil)
+	req.Header.Set("Accept", "text/html,*/*;q=0.8")
+	resp, _ = http.DefaultClient.Do(req)
+	respBytes, _ = ioutil.ReadAll(resp.Body)
+	revResp := GetRevisionResponse{}
+	json.Unmarshal(respBytes, &revResp)
+	if resp.StatusCode != 200 || revResp.Revision != 1 {
+		t.Fatal(string(respBytes))
+	}
+
+	req, _ = http.NewRequest("GET", ts.URL+"/report/"+rp.ID, nil)
+	req.Header.Set("Accept", "image/png")
+	resp, _ = http.DefaultClient.Do(req)
+	respBytes, _ = ioutil.ReadAll(resp.Body)
+	revResp = GetRevisionResponse{}
+	json.Unmarshal(respBytes, &revResp)
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") == "application/ld+json" || revResp.Revision == 0 {
+		t.Fatal(resp.StatusCode)
+	}
+}
@@ -0,0 +1,252 @@
+package aime
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Minimum similarity (1 - edit distance / length) for two keywords to be
+// considered variants of each other
+const keywordSimilarity = 0.85
+
+// Minimum length of a word to be considered a truncated variant of a longer
+// one, e.g. "learn" of "learning"
+const keywordMinStem = 5
+
+type keywordStats struct {
+	keyword    string
+	normalized string
+	words      []string
+	reports    map[string]bool
+}
+
+// normalizeKeyword folds a keyword and drops everything but letters and
+// digits, so that "Deep-Learning" and "deep learning" are equal.
+func normalizeKeyword(k string) string {
+	var b strings.Builder
+	for _, r := range foldKeyword(k) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func keywordWords(k string) []string {
+	return strings.FieldsFunc(foldKeyword(k), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func acronym(k string) string {
+	var b strings.Builder
+	for _, w := range keywordWords(k) {
+		b.WriteRune([]rune(w)[0])
+	}
+	return b.String()
+}
+
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = cur[j-1] + 1
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if prev[j-1]+cost < cur[j] {
+				cur[j] = prev[j-1] + cost
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// sameKeywords reports whether two keywords are spellings of the same keyword:
+// same normalized form or small edit distance.
+func sameKeywords(a, b *keywordStats) bool {
+	if a.normalized == "" || b.normalized == "" {
+		return false
+	}
+	if a.normalized == b.normalized {
+		return true
+	}
+
+	l := len([]rune(a.normalized))
+	if bl := len([]rune(b.normalized)); bl > l {
+		l = bl
+	}
+	return 1-float64(editDistance(a.normalized, b.normalized))/float64(l) >= keywordSimilarity
+}
+
+// abbreviates reports whether short is an abbreviation of long: the same words
+// with the last one truncated, e.g. "deep learn" of "deep learning", or an
+// acronym used on the same report as its expansion.
+func abbreviates(short, long *keywordStats) bool {
+	if n := len(short.words); n > 0 && n == len(long.words) {
+		same := true
+		for i := 0; i < n-1; i++ {
+			if short.words[i] != long.words[i] {
+				same = false
+			}
+		}
+		last, longLast := short.words[n-1], long.words[n-1]
+		if same && last != longLast && len([]rune(last)) >= keywordMinStem && strings.HasPrefix(longLast, last) {
+			return true
+		}
+	}
+
+	if len(short.words) == 1 && len([]rune(short.normalized)) >= 2 && short.normalized == acronym(long.keyword) {
+		for r := range short.reports {
+			if long.reports[r] {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// SuggestKeywordGroups clusters the keywords of the latest public revisions
+// that are not covered by a keyword group yet and proposes a group for every
+// cluster with more than one variant. The most used variant becomes the
+// target, preferring longer variants over acronyms.
+func (db *DB) SuggestKeywordGroups() KeywordGroups {
+	kwg := db.GetKeywordGroups()
+	field := splitField(db.Settings.withDefaults().index("keywords").Field)
+
+	statsMap := map[string]*keywordStats{}
+	for rev := range db.GetLatestRevisions(false) {
+		for _, k := range ExtractFields(db.questions, rev.Answers, field) {
+			k = strings.TrimSpace(k)
+			if k == "" || kwg.covers(k) {
+				continue
+			}
+			ks, ok := statsMap[k]
+			if !ok {
+				ks = &keywordStats{
+					keyword:    k,
+					normalized: normalizeKeyword(k),
+					words:      keywordWords(k),
+					reports:    map[string]bool{},
+				}
+				statsMap[k] = ks
+			}
+			ks.reports[rev.ReportID] = true
+		}
+	}
+
+	var stats []*keywordStats
+	for _, ks := range statsMap {
+		stats = append(stats, ks)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].keyword < stats[j].keyword
+	})
+
+	// Union-find over the keywords
+	parent := make([]int, len(stats))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for i := range stats {
+		for j := i + 1; j < len(stats); j++ {
+			if sameKeywords(stats[i], stats[j]) {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	// Abbreviations join the cluster they abbreviate only if it is the only
+	// one and does not join another itself, so that short keywords do not
+	// chain unrelated clusters like "image segmentation" and "image
+	// classification"
+	abbreviated := map[int]map[int]bool{}
+	for i := range stats {
+		for j := range stats {
+			if find(i) != find(j) && abbreviates(stats[i], stats[j]) {
+				if abbreviated[find(i)] == nil {
+					abbreviated[find(i)] = map[int]bool{}
+				}
+				abbreviated[find(i)][find(j)] = true
+			}
+		}
+	}
+	for c, targets := range abbreviated {
+		if len(targets) != 1 {
+			continue
+		}
+		for t := range targets {
+			if abbreviated[t] == nil {
+				parent[c] = t
+			}
+		}
+	}
+
+	clusters := map[int][]*keywordStats{}
+	for i, ks := range stats {
+		clusters[find(i)] = append(clusters[find(i)], ks)
+	}
+
+	groups := KeywordGroups{}
+	for _, c := range clusters {
+		if len(c) < 2 {
+			continue
+		}
+		sort.Slice(c, func(i, j int) bool {
+			if len(c[i].reports) != len(c[j].reports) {
+				return len(c[j].reports) < len(c[i].reports)
+			}
+			if len(c[i].keyword) != len(c[j].keyword) {
+				return len(c[j].keyword) < len(c[i].keyword)
+			}
+			return c[i].keyword < c[j].keyword
+		})
+		g := KeywordGroup{
+			Targets:       []string{clean(c[0].keyword)},
+			CaseSensitive: false,
+		}
+		for _, ks := range c {
+			g.Sources = append(g.Sources, ks.keyword)
+		}
+		groups = append(groups, g)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Targets[0] < groups[j].Targets[0]
+	})
+
+	return groups
+}
+
+// covers reports whether a keyword is a source of any keyword group.
+func (kwg KeywordGroups) covers(k string) bool {
+	for _, g := range kwg {
+		for _, s := range g.Sources {
+			if s == k || (!g.CaseSensitive && strings.EqualFold(s, k)) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package aime
+
+import (
+	"encoding/json"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	if editDistance("kitten", "sitting") != 3 {
+		t.Fatal()
+	}
+	if editDistance("", "abc") != 3 {
+		t.Fatal()
+	}
+	if editDistance("äb", "ab") != 1 {
+		t.Fatal()
+	}
+}
+
+func TestNormalizeKeyword(t *testing.T) {
+	if normalizeKeyword("Deep-Learning") != "deeplearning" {
+		t.Fatal()
+	}
+	if acronym("deep learning") != "dl" || acronym("Natural-language processing") != "nlp" {
+		t.Fatal()
+	}
+}
+
+func TestAbbreviates(t *testing.T) {
+	ks := func(k string, reports ...string) *keywordStats {
+		ks := &keywordStats{keyword: k, normalized: normalizeKeyword(k), words: keywordWords(k), reports: map[string]bool{}}
+		for _, r := range reports {
+			ks.reports[r] = true
+		}
+		return ks
+	}
+
+	if !abbreviates(ks("deep learn"), ks("deep learning")) || !abbreviates(ks("network"), ks("networks")) {
+		t.Fatal()
+	}
+	if abbreviates(ks("deep learning"), ks("deep learn")) || abbreviates(ks("image"), ks("image segmentation")) {
+		t.Fatal()
+	}
+	if abbreviates(ks("net"), ks("network")) || abbreviates(ks("deep learn"), ks("shallow learning")) {
+		t.Fatal()
+	}
+	if !abbreviates(ks("DL", "1"), ks("deep learning", "1")) || abbreviates(ks("DL", "1"), ks("deep learning", "2")) {
+		t.Fatal()
+	}
+}
+
+func TestDB_SuggestKeywordGroups(t *testing.T) {
+	db := DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	db.KeywordGroups = KeywordGroups{
+		{Sources: []string{"gene expression"}, Targets: []string{"gene expression"}},
+	}
+
+	te := [][]string{
+		{"Deep learning", "DL"},
+		{"deep-learning"},
+		{"Deep learning", "cancer"},
+		{"deep learn"},
+		{"dl", "Gene Expression"},
+		{"GE", "gene expressions"},
+		{"dancer"},
+		{"image", "image segmentation"},
+		{"image classification", "segment"},
+		{"segmentation", "segmented"},
+	}
+	for _, kws := range te {
+		kwJn := ""
+		for i, k := range kws {
+			if i > 0 {
+				kwJn += ","
+			}
+			kwJn += "{\"custom\":true,\"value\":\"" + k + "\"}"
+		}
+		r := db.CreateReport("", true)
+		db.CreateRevision(r.ID, "", json.RawMessage("{\"MD\":{\"5\":["+kwJn+"]}}"), r.Token, true)
+	}
+
+	kwg := db.SuggestKeywordGroups()
+	if len(kwg) != 2 {
+		t.Fatal(kwg)
+	}
+	if kwg[0].Targets[0] != "deep learning" || kwg[0].CaseSensitive {
+		t.Fatal(kwg)
+	}
+	if strings.Join(kwg[0].Sources, ",") != "Deep learning,deep-learning,deep learn,DL,dl" {
+		t.Fatal(kwg[0].Sources)
+	}
+	// "Gene Expression" is already covered by a keyword group
+	if kwg[1].Targets[0] != "gene expressions" || strings.Join(kwg[1].Sources, ",") != "gene expressions,GE" {
+		t.Fatal(kwg[1])
+	}
+}
+
+func TestServer_SuggestKeywordGroups(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	for _, k := range []string{"Deep learning", "deep-learning"} {
+		r := db.CreateReport("", true)
+		db.CreateRevision(r.ID, "", json.RawMessage("{\"MD\":{\"5\":[{\"custom\":true,\"value\":\""+k+"\"}]}}"), r.Token, true)
+	}
+
+	srv := Server{DB: db, AdminToken: "admin"}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, _ := http.Get(ts.URL + "/admin/keyword-groups/suggestions")
+	if resp.StatusCode != 403 {
+		t.Fatal()
+	}
+
+	resp, _ = http.Get(ts.URL + "/admin/keyword-groups/suggestions?p=admin")
+	if resp.StatusCode != 200 {
+		t.Fatal()
+	}
+	respBytes, _ := ioutil.ReadAll(resp.Body)
+	kwg := KeywordGroups{}
+	yaml.Unmarshal(respBytes, &kwg)
+	if len(kwg) != 1 || len(kwg[0].Sources) != 2 {
+		t.Fatal(string(respBytes))
+	}
+}
@@ -0,0 +1,264 @@
+package aime
+
+import (
+	"bytes"
+	"errors"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+var ErrInvalidKeywordGroup = errors.New("keyword group needs at least one source and one target")
+
+// ReadKeywordGroups reads the keyword groups from a YAML file. A missing file
+// yields no keyword groups. Groups without ID get one.
+func ReadKeywordGroups(filename string) (KeywordGroups, error) {
+	var kwg KeywordGroups
+
+	kwgBytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	err = yaml.Unmarshal(kwgBytes, &kwg)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, g := range kwg {
+		if !g.valid() {
+			return nil, ErrInvalidKeywordGroup
+		}
+	}
+
+	return kwg.withIDs(), nil
+}
+
+func marshalKeywordGroups(kwg KeywordGroups) ([]byte, error) {
+	if kwg == nil {
+		kwg = KeywordGroups{}
+	}
+	return yaml.Marshal(kwg)
+}
+
+func WriteKeywordGroups(filename string, kwg KeywordGroups) error {
+	kwgBytes, err := marshalKeywordGroups(kwg)
+	if err != nil {
+		return err
+	}
+	return writeKeywordGroupsFile(filename, kwgBytes)
+}
+
+func writeKeywordGroupsFile(filename string, kwgBytes []byte) error {
+	// Write to a temporary file first so that the watcher never sees a partial file
+	tmp := filename + ".tmp"
+	err := ioutil.WriteFile(tmp, kwgBytes, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+func (g KeywordGroup) valid() bool {
+	return len(g.Sources) > 0 && len(g.Targets) > 0
+}
+
+// withIDs gives the groups without ID, or with the ID of an earlier group, the
+// next free IDs.
+func (kwg KeywordGroups) withIDs() KeywordGroups {
+	max := 0
+	for _, g := range kwg {
+		if g.ID > max {
+			max = g.ID
+		}
+	}
+
+	seen := map[int]bool{}
+	for i := range kwg {
+		if kwg[i].ID <= 0 || seen[kwg[i].ID] {
+			max++
+			kwg[i].ID = max
+		}
+		seen[kwg[i].ID] = true
+	}
+	return kwg
+}
+
+func (kwg KeywordGroups) find(id int) int {
+	for i, g := range kwg {
+		if g.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (db *DB) GetKeywordGroups() KeywordGroups {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	return append(KeywordGroups{}, db.KeywordGroups...)
+}
+
+// SetKeywordGroups replaces the keyword groups, persists them to
+// KeywordGroupsFile and rebuilds the indexes. The file is written anew from
+// the groups, comments and the layout of an edited file are lost.
+func (db *DB) SetKeywordGroups(kwg KeywordGroups) error {
+	db.keywordGroupsMutex.Lock()
+	defer db.keywordGroupsMutex.Unlock()
+
+	return db.setKeywordGroups(kwg)
+}
+
+func (db *DB) setKeywordGroups(kwg KeywordGroups) error {
+	for _, g := range kwg {
+		if !g.valid() {
+			return ErrInvalidKeywordGroup
+		}
+	}
+	kwg = kwg.withIDs()
+
+	if db.KeywordGroupsFile != "" {
+		kwgBytes, err := marshalKeywordGroups(kwg)
+		if err != nil {
+			return err
+		}
+		err = writeKeywordGroupsFile(db.KeywordGroupsFile, kwgBytes)
+		if err != nil {
+			return err
+		}
+		db.keywordGroupsWritten = kwgBytes
+	}
+
+	db.mutex.Lock()
+	db.KeywordGroups = kwg
+	db.mutex.Unlock()
+
+	db.BuildIndexes()
+
+	return nil
+}
+
+// CreateKeywordGroup adds a keyword group and returns its ID.
+func (db *DB) CreateKeywordGroup(g KeywordGroup) (int, error) {
+	db.keywordGroupsMutex.Lock()
+	defer db.keywordGroupsMutex.Unlock()
+
+	kwg := db.GetKeywordGroups()
+	g.ID = 0
+	kwg = append(kwg, g).withIDs()
+	err := db.setKeywordGroups(kwg)
+	if err != nil {
+		return 0, err
+	}
+	return kwg[len(kwg)-1].ID, nil
+}
+
+// UpdateKeywordGroup replaces the keyword group with the given ID. It returns
+// false if no such group exists.
+func (db *DB) UpdateKeywordGroup(id int, g KeywordGroup) (bool, error) {
+	db.keywordGroupsMutex.Lock()
+	defer db.keywordGroupsMutex.Unlock()
+
+	kwg := db.GetKeywordGroups()
+	i := kwg.find(id)
+	if i < 0 {
+		return false, nil
+	}
+	g.ID = id
+	kwg[i] = g
+	return true, db.setKeywordGroups(kwg)
+}
+
+// DeleteKeywordGroup removes the keyword group with the given ID. The IDs of
+// the other groups stay the same.
+func (db *DB) DeleteKeywordGroup(id int) (bool, error) {
+	db.keywordGroupsMutex.Lock()
+	defer db.keywordGroupsMutex.Unlock()
+
+	kwg := db.GetKeywordGroups()
+	i := kwg.find(id)
+	if i < 0 {
+		return false, nil
+	}
+	kwg = append(kwg[:i], kwg[i+1:]...)
+	return true, db.setKeywordGroups(kwg)
+}
+
+// ReloadKeywordGroups reads KeywordGroupsFile and rebuilds the indexes. On
+// error the current keyword groups are kept.
+func (db *DB) ReloadKeywordGroups() error {
+	db.keywordGroupsMutex.Lock()
+	defer db.keywordGroupsMutex.Unlock()
+
+	return db.reloadKeywordGroups()
+}
+
+func (db *DB) reloadKeywordGroups() error {
+	kwg, err := ReadKeywordGroups(db.KeywordGroupsFile)
+	if err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	db.KeywordGroups = kwg
+	db.mutex.Unlock()
+
+	db.BuildIndexes()
+
+	return nil
+}
+
+// reloadChangedKeywordGroups reloads KeywordGroupsFile unless it is what the
+// DB wrote itself, whose indexes are already built.
+func (db *DB) reloadChangedKeywordGroups() error {
+	db.keywordGroupsMutex.Lock()
+	defer db.keywordGroupsMutex.Unlock()
+
+	kwgBytes, err := ioutil.ReadFile(db.KeywordGroupsFile)
+	if err == nil && db.keywordGroupsWritten != nil && bytes.Equal(kwgBytes, db.keywordGroupsWritten) {
+		return nil
+	}
+	return db.reloadKeywordGroups()
+}
+
+// WatchKeywordGroups polls KeywordGroupsFile for changes by others and reloads
+// it. The returned function stops watching.
+func (db *DB) WatchKeywordGroups(interval time.Duration) func() {
+	stop := make(chan bool)
+
+	go func() {
+		var lastMod time.Time
+		if s, err := os.Stat(db.KeywordGroupsFile); err == nil {
+			lastMod = s.ModTime()
+		}
+
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				s, err := os.Stat(db.KeywordGroupsFile)
+				if err != nil || s.ModTime().Equal(lastMod) {
+					continue
+				}
+				lastMod = s.ModTime()
+				if err := db.reloadChangedKeywordGroups(); err != nil {
+					log.Printf("Could not reload keyword groups: %v\n", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+	}
+}
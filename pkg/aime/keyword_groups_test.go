@@ -0,0 +1,216 @@
+package aime
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReadKeywordGroups(t *testing.T) {
+	kwg, err := ReadKeywordGroups("../../keyword-groups.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kwg) != 3 || kwg[0].Targets[1] != "GE" {
+		t.Fatal()
+	}
+
+	kwg, err = ReadKeywordGroups("./does-not-exist.yaml")
+	if err != nil || kwg != nil {
+		t.Fatal()
+	}
+
+	ioutil.WriteFile("./test-groups.yaml", []byte("- sources: [a]\n"), os.ModePerm)
+	defer os.Remove("./test-groups.yaml")
+
+	_, err = ReadKeywordGroups("./test-groups.yaml")
+	if err != ErrInvalidKeywordGroup {
+		t.Fatal(err)
+	}
+
+	ioutil.WriteFile("./test-groups.yaml", []byte("sources: 1"), os.ModePerm)
+
+	_, err = ReadKeywordGroups("./test-groups.yaml")
+	if err == nil {
+		t.Fatal()
+	}
+}
+
+func TestDB_KeywordGroups(t *testing.T) {
+	db := DB{Dir: "./test", KeywordGroupsFile: "./test-groups.yaml"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+	defer os.Remove("./test-groups.yaml")
+
+	r := db.CreateReport("", true)
+	db.CreateRevision(r.ID, "", json.RawMessage("{\"MD\":{\"5\":[{\"custom\":true,\"value\":\"DL\"}]}}"), r.Token, true)
+	db.BuildIndexes()
+
+	if db.GetKeyword("DL") == nil {
+		t.Fatal()
+	}
+
+	id, err := db.CreateKeywordGroup(KeywordGroup{Sources: []string{"dl"}, Targets: []string{"deep learning"}})
+	if err != nil || id != 1 {
+		t.Fatal(err)
+	}
+	if db.GetKeyword("DL") != nil || db.GetKeyword("deep learning") == nil {
+		t.Fatal()
+	}
+
+	kwg, _ := ReadKeywordGroups("./test-groups.yaml")
+	if len(kwg) != 1 || kwg[0].Targets[0] != "deep learning" {
+		t.Fatal()
+	}
+
+	ok, err := db.UpdateKeywordGroup(1, KeywordGroup{Sources: []string{"dl"}, Targets: []string{"Deep Learning"}})
+	if !ok || err != nil {
+		t.Fatal(err)
+	}
+	if db.GetKeyword("Deep Learning") == nil {
+		t.Fatal()
+	}
+
+	if _, err := db.CreateKeywordGroup(KeywordGroup{Sources: []string{"x"}}); err != ErrInvalidKeywordGroup {
+		t.Fatal(err)
+	}
+
+	ok, _ = db.UpdateKeywordGroup(2, KeywordGroup{Sources: []string{"x"}, Targets: []string{"y"}})
+	if ok {
+		t.Fatal()
+	}
+
+	id, err = db.CreateKeywordGroup(KeywordGroup{Sources: []string{"ml"}, Targets: []string{"machine learning"}})
+	if err != nil || id != 2 {
+		t.Fatal(err)
+	}
+
+	ok, err = db.DeleteKeywordGroup(1)
+	if !ok || err != nil {
+		t.Fatal(err)
+	}
+	if db.GetKeyword("DL") == nil {
+		t.Fatal()
+	}
+	// The IDs of the other groups stay the same
+	kwg = db.GetKeywordGroups()
+	if len(kwg) != 1 || kwg[0].ID != 2 {
+		t.Fatal(kwg)
+	}
+	kwg, _ = ReadKeywordGroups("./test-groups.yaml")
+	if len(kwg) != 1 || kwg[0].ID != 2 {
+		t.Fatal(kwg)
+	}
+	ok, _ = db.UpdateKeywordGroup(2, KeywordGroup{Sources: []string{"ml"}, Targets: []string{"ML"}})
+	if !ok {
+		t.Fatal()
+	}
+}
+
+func TestDB_WatchKeywordGroups(t *testing.T) {
+	db := DB{Dir: "./test", KeywordGroupsFile: "./test-groups.yaml"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+	defer os.Remove("./test-groups.yaml")
+
+	WriteKeywordGroups("./test-groups.yaml", nil)
+
+	stop := db.WatchKeywordGroups(5 * time.Millisecond)
+	defer stop()
+
+	time.Sleep(20 * time.Millisecond)
+
+	// Ensure the modification time differs on coarse file systems
+	later := time.Now().Add(time.Second)
+	WriteKeywordGroups("./test-groups.yaml", KeywordGroups{{Sources: []string{"a"}, Targets: []string{"b"}}})
+	os.Chtimes("./test-groups.yaml", later, later)
+
+	time.Sleep(50 * time.Millisecond)
+
+	if len(db.GetKeywordGroups()) != 1 {
+		t.Fatal()
+	}
+
+	// Own writes are not reloaded
+	db.SetKeywordGroups(KeywordGroups{{Sources: []string{"c"}, Targets: []string{"d"}}})
+	db.KeywordGroups = nil
+	db.reloadChangedKeywordGroups()
+	if len(db.GetKeywordGroups()) != 0 {
+		t.Fatal()
+	}
+	WriteKeywordGroups("./test-groups.yaml", KeywordGroups{{Sources: []string{"e"}, Targets: []string{"f"}}})
+	db.reloadChangedKeywordGroups()
+	if kwg := db.GetKeywordGroups(); len(kwg) != 1 || kwg[0].Sources[0] != "e" {
+		t.Fatal(kwg)
+	}
+}
+
+func TestServer_KeywordGroups(t *testing.T) {
+	db := &DB{Dir: "./test", KeywordGroupsFile: "./test-groups.yaml"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+	defer os.Remove("./test-groups.yaml")
+
+	srv := Server{DB: db, AdminToken: "admin"}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, _ := http.Get(ts.URL + "/admin/keyword-groups")
+	if resp.StatusCode != 403 {
+		t.Fatal()
+	}
+
+	reqBytes, _ := json.Marshal(KeywordGroup{Sources: []string{"GE"}, Targets: []string{"gene expression"}})
+	resp, _ = http.Post(ts.URL+"/admin/keyword-groups?p=admin", "application/json", bytes.NewBuffer(reqBytes))
+	if resp.StatusCode != 200 {
+		t.Fatal()
+	}
+	respBytes, _ := ioutil.ReadAll(resp.Body)
+	group := KeywordGroupResponse{}
+	json.Unmarshal(respBytes, &group)
+	if group.ID != 1 || group.Targets[0] != "gene expression" {
+		t.Fatal(string(respBytes))
+	}
+
+	resp, _ = http.Post(ts.URL+"/admin/keyword-groups?p=admin", "application/json", bytes.NewBufferString("{}"))
+	if resp.StatusCode != 400 {
+		t.Fatal()
+	}
+
+	c := http.Client{}
+
+	reqBytes, _ = json.Marshal(KeywordGroup{Sources: []string{"GE"}, Targets: []string{"Gene expression"}, CaseSensitive: true})
+	r, _ := http.NewRequest("PUT", ts.URL+"/admin/keyword-groups/1?p=admin", bytes.NewBuffer(reqBytes))
+	resp, _ = c.Do(r)
+	if resp.StatusCode != 200 {
+		t.Fatal()
+	}
+
+	r, _ = http.NewRequest("PUT", ts.URL+"/admin/keyword-groups/7?p=admin", bytes.NewBuffer(reqBytes))
+	resp, _ = c.Do(r)
+	if resp.StatusCode != 404 {
+		t.Fatal()
+	}
+
+	resp, _ = http.Get(ts.URL + "/admin/keyword-groups?p=admin")
+	respBytes, _ = ioutil.ReadAll(resp.Body)
+	groups := KeywordGroupsResponse{}
+	json.Unmarshal(respBytes, &groups)
+	if len(groups.KeywordGroups) != 1 || !groups.KeywordGroups[0].CaseSensitive {
+		t.Fatal(string(respBytes))
+	}
+
+	r, _ = http.NewRequest("DELETE", ts.URL+"/admin/keyword-groups/1?p=admin", nil)
+	resp, _ = c.Do(r)
+	if resp.StatusCode != 200 {
+		t.Fatal()
+	}
+	if len(db.GetKeywordGroups()) != 0 {
+		t.Fatal()
+	}
+}
@@ -79,16 +79,19 @@ type UploadFileResponse struct {
 type Result struct {
 	ID        string    `json:"id"`
 	UpdatedAt time.Time `json:"date"`
+	CreatedAt time.Time `json:"createdAt"`
 	Title     string    `json:"title"`
 	Authors   []string  `json:"authors"`
 	Revisions int       `json:"revisions"`
 	Issues    int       `json:"issues"`
+	Score     float64   `json:"score,omitempty"`
 }
 
 type SearchResponse struct {
 	Count   int      `json:"count"`
 	Results []Result `json:"results"`
 	Query   string   `json:"query"`
+	Next    string   `json:"next,omitempty"`
 }
 
 type Keyword struct {
@@ -106,4 +109,166 @@ type KeywordResponse struct {
 	Count   int      `json:"count"`
 	Results []Result `json:"results"`
 	Keyword string   `json:"keyword"`
+	Next    string   `json:"next,omitempty"`
+}
+
+type Category struct {
+	Category string `json:"category"`
+	Count    int    `json:"count"`
+}
+
+type CategoriesResponse struct {
+	Categories []Category `json:"categories"`
+}
+
+type CategoryResponse struct {
+	Count    int      `json:"count"`
+	Results  []Result `json:"results"`
+	Category string   `json:"category"`
+	Next     string   `json:"next,omitempty"`
+}
+
+type IndexEntry struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type IndexResponse struct {
+	Name    string       `json:"name"`
+	Entries []IndexEntry `json:"entries"`
+}
+
+type IndexListResponse []string
+
+type IndexEntryResponse struct {
+	Count   int      `json:"count"`
+	Results []Result `json:"results"`
+	Name    string   `json:"name"`
+	Value   string   `json:"value"`
+	Next    string   `json:"next,omitempty"`
+}
+
+type KeywordGroupResponse struct {
+	ID            int      `json:"id"`
+	Sources       []string `json:"sources"`
+	Targets       []string `json:"targets"`
+	CaseSensitive bool     `json:"caseSensitive"`
+}
+
+type KeywordGroupsResponse struct {
+	KeywordGroups []KeywordGroupResponse `json:"keywordGroups"`
+}
+
+type TreeNode struct {
+	ID       string     `json:"id,omitempty"`
+	Label    string     `json:"label"`
+	Count    int        `json:"count"`
+	Children []TreeNode `json:"children,omitempty"`
+}
+
+type KeywordTreeResponse struct {
+	Keywords []TreeNode `json:"keywords"`
+}
+
+type IndexTreeResponse struct {
+	Name    string     `json:"name"`
+	Entries []TreeNode `json:"entries"`
+}
+
+type SimilarResponse struct {
+	Results []Result `json:"results"`
+}
+
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+	// Active defaults to true
+	Active *bool `json:"active"`
+}
+
+type ImportArchiveResponse struct {
+	ID        string `json:"id"`
+	Revisions int    `json:"revisions"`
+}
+
+type WebhooksResponse struct {
+	Webhooks []*Webhook `json:"webhooks"`
+}
+
+type DeliveriesResponse struct {
+	Count      int         `json:"count"`
+	Deliveries []*Delivery `json:"deliveries"`
+}
+
+type ReportEvent struct {
+	ReportID  string    `json:"reportId"`
+	Version   int       `json:"version"`
+	Public    bool      `json:"isPublic"`
+	CreatedAt time.Time `json:"createdAt"`
+	URL       string    `json:"url"`
+}
+
+type IssueEvent struct {
+	ReportID   string    `json:"reportId"`
+	IssueID    int       `json:"issueId"`
+	RevisionID int       `json:"revisionId"`
+	Type       int       `json:"type"`
+	Field      []string  `json:"field"`
+	CreatedAt  time.Time `json:"createdAt"`
+	URL        string    `json:"url"`
+}
+
+type AnswerEvent struct {
+	ReportID  string    `json:"reportId"`
+	IssueID   int       `json:"issueId"`
+	AnswerID  int       `json:"answerId"`
+	Owner     bool      `json:"owner"`
+	CreatedAt time.Time `json:"createdAt"`
+	URL       string    `json:"url"`
+}
+
+type MonthStats struct {
+	Month     string `json:"month"`
+	Reports   int    `json:"reports"`
+	Revisions int    `json:"revisions"`
+}
+
+type StatsResponse struct {
+	Reports        int          `json:"reports"`
+	Revisions      int          `json:"revisions"`
+	Public         int          `json:"public"`
+	Hidden         int          `json:"hidden"`
+	Months         []MonthStats `json:"months"`
+	Issues         int          `json:"issues"`
+	AnsweredIssues int          `json:"answeredIssues"`
+	ResponseRate   float64      `json:"responseRate"`
+}
+
+type ValueStats struct {
+	Key    string `json:"key,omitempty"`
+	Value  string `json:"value"`
+	Custom bool   `json:"custom"`
+	Count  int    `json:"count"`
+}
+
+type QuestionStatsResponse struct {
+	Path     string       `json:"path"`
+	Question string       `json:"question"`
+	Type     string       `json:"type"`
+	Reports  int          `json:"reports"`
+	Answered int          `json:"answered"`
+	Values   []ValueStats `json:"values"`
+}
+
+type IssueStatusEvent struct {
+	Verified     bool      `json:"confirmed"`
+	Pending      bool      `json:"pending"`
+	PendingUntil time.Time `json:"pendingUntil"`
+}
+
+type ChangesResponse struct {
+	Changes []Change `json:"changes"`
+	Last    int64    `json:"last"`
+	More    bool     `json:"more"`
 }
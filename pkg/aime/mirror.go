@@ -0,0 +1,371 @@
+package aime
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A mirror replicates the public reports of an upstream registry into the
+// local DB. The first sync copies every public report, later syncs follow the
+// change log of the upstream and only copy the reports that changed since.
+// Reports that are deleted or hidden upstream are removed from the mirror.
+
+var ErrUpstream = errors.New("unexpected response from upstream")
+
+var mirrorClient = &http.Client{Timeout: maxChangeWait + 30*time.Second}
+
+type Mirror struct {
+	// Upstream is the base URL of the upstream API, e.g. https://aime.example.org/api
+	Upstream string
+	DB       *DB
+	// Wait is how long a sync waits for upstream changes. The upstream caps it
+	// at maxChangeWait.
+	Wait time.Duration
+}
+
+// mirrorState is the position of the mirror in the upstream change log.
+type mirrorState struct {
+	Upstream string    `json:"upstream"`
+	Last     int64     `json:"last"`
+	SyncedAt time.Time `json:"syncedAt"`
+}
+
+// mirrorIssue is an issue as served by GET /report/{id}/issue/{issue}.
+type mirrorIssue struct {
+	Issue
+	Pending      bool      `json:"pending"`
+	PendingUntil time.Time `json:"pendingUntil"`
+}
+
+func (db *DB) mirrorFilePath() string {
+	return filepath.Join(db.Dir, "mirror.json")
+}
+
+func (m *Mirror) state() mirrorState {
+	st := mirrorState{}
+	stBytes, err := ioutil.ReadFile(m.DB.mirrorFilePath())
+	if err == nil {
+		json.Unmarshal(stBytes, &st)
+	}
+	// Positions in the change log of another upstream are meaningless
+	if st.Upstream != m.Upstream {
+		return mirrorState{Upstream: m.Upstream}
+	}
+	return st
+}
+
+func (m *Mirror) setState(st mirrorState) {
+	os.MkdirAll(m.DB.Dir, os.ModePerm)
+	stBytes, _ := json.Marshal(st)
+	ioutil.WriteFile(m.DB.mirrorFilePath(), stBytes, os.ModePerm)
+}
+
+// fetch requests a path of the upstream API. It returns nil if the upstream
+// does not have the resource.
+func (m *Mirror) fetch(path string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(m.Upstream, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := mirrorClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case 200:
+		return resp, nil
+	case 403, 404:
+		resp.Body.Close()
+		return nil, nil
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: GET %s returned %d", ErrUpstream, path, resp.StatusCode)
+	}
+}
+
+// get decodes the JSON response of the upstream into v. It reports false if
+// the upstream does not have the resource.
+func (m *Mirror) get(path string, v interface{}) (bool, error) {
+	resp, err := m.fetch(path)
+	if resp == nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	return true, json.NewDecoder(resp.Body).Decode(v)
+}
+
+// exportedIDs returns the IDs of all public reports of the upstream.
+func (m *Mirror) exportedIDs() ([]string, error) {
+	resp, err := m.fetch("/export/reports.jsonl")
+	if resp == nil {
+		if err == nil {
+			err = fmt.Errorf("%w: no export", ErrUpstream)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var ids []string
+	s := bufio.NewScanner(resp.Body)
+	s.Buffer(nil, 64*1024*1024)
+	for s.Scan() {
+		rec := ExportRecord{}
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			return nil, err
+		}
+		ids = append(ids, rec.ID)
+	}
+	return ids, s.Err()
+}
+
+// Sync copies the reports that changed upstream since the last sync, or all
+// public reports on the first sync. It returns the number of copied reports.
+func (m *Mirror) Sync() (int, error) {
+	return m.sync(0)
+}
+
+func (m *Mirror) sync(wait time.Duration) (int, error) {
+	st := m.state()
+	initial := st.SyncedAt.IsZero()
+
+	ids := map[string]bool{}
+	var order []string
+	add := func(id string) {
+		if !ids[id] {
+			ids[id] = true
+			order = append(order, id)
+		}
+	}
+
+	// Read the change log before the reports, so that changes made meanwhile
+	// are copied by the next sync at the latest
+	last := st.Last
+	for {
+		q := url.Values{}
+		q.Set("since", strconv.FormatInt(last, 10))
+		q.Set("limit", "1000")
+		if wait > 0 && !initial && last == st.Last {
+			q.Set("wait", strconv.Itoa(int(wait/time.Second)))
+		}
+
+		cr := ChangesResponse{}
+		ok, err := m.get("/changes?"+q.Encode(), &cr)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, fmt.Errorf("%w: no change log", ErrUpstream)
+		}
+		for _, c := range cr.Changes {
+			add(c.ReportID)
+		}
+		last = cr.Last
+		if !cr.More {
+			break
+		}
+	}
+
+	if initial {
+		exported, err := m.exportedIDs()
+		if err != nil {
+			return 0, err
+		}
+		for _, id := range exported {
+			add(id)
+		}
+	}
+
+	n := 0
+	for _, id := range order {
+		if err := m.syncReport(id); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	m.setState(mirrorState{Upstream: m.Upstream, Last: last, SyncedAt: time.Now()})
+
+	return n, nil
+}
+
+// syncReport copies a report with all its revisions and published issues.
+// Revisions are never changed, so only new ones are fetched.
+func (m *Mirror) syncReport(id string) error {
+	db := m.DB
+
+	db.writeMutex.RLock()
+	defer db.writeMutex.RUnlock()
+
+	if id == "" || strings.ContainsAny(id, "/\\.") {
+		return nil
+	}
+
+	rr := GetRevisionResponse{}
+	ok, err := m.get("/report/"+url.PathEscape(id), &rr)
+	if err != nil {
+		return err
+	}
+	if !ok || !rr.Public || len(rr.Revisions) != rr.Revision {
+		if db.ExistsReport(id) {
+			db.deleteReport(id)
+		}
+		return nil
+	}
+
+	rp := db.GetReport(id)
+	if rp == nil {
+		rp = &Report{ID: id, Token: generateRandomString(16)}
+	}
+
+	os.MkdirAll(db.revisionPath(id), os.ModePerm)
+	os.MkdirAll(db.commentPath(id), os.ModePerm)
+
+	var revs []*Revision
+	for _, ri := range rr.Revisions {
+		if rev := db.GetRevision(id, ri.Revision); rev != nil {
+			continue
+		}
+
+		rev := &Revision{
+			ReportID:  id,
+			Version:   ri.Revision,
+			Answers:   rr.Answers,
+			CreatedAt: ri.CreatedAt,
+			Public:    rr.Public,
+		}
+		if ri.Revision != rr.Revision {
+			vr := GetRevisionResponse{}
+			ok, err := m.get("/report/"+url.PathEscape(id)+"/"+strconv.Itoa(ri.Revision), &vr)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("%w: revision %d of %s is missing", ErrUpstream, ri.Revision, id)
+			}
+			rev.Answers = vr.Answers
+			rev.Public = vr.Public
+		}
+
+		revBytes := marshalDocument(SchemaRevision, rev)
+		ioutil.WriteFile(db.revisionFilePath(id, rev.Version), revBytes, os.ModePerm)
+		revs = append(revs, rev)
+	}
+
+	// Issues that are no longer listed were deleted upstream
+	listed := map[int]bool{}
+	for _, ii := range rr.Issues {
+		listed[ii.ID] = true
+
+		mi := mirrorIssue{}
+		ok, err := m.get("/report/"+url.PathEscape(id)+"/issue/"+strconv.Itoa(ii.ID), &mi)
+		if err != nil {
+			return err
+		}
+		if !ok || mi.Pending {
+			listed[ii.ID] = false
+			continue
+		}
+
+		iss := mi.Issue
+		iss.ReportID = id
+		iss.VerifiedAt = mi.PendingUntil.Add(-pendingTime)
+		iss.PublishedAt = mi.PendingUntil
+		if iss.PublishedAt.After(time.Now()) {
+			iss.PublishedAt = time.Now()
+		}
+		iss.Token = generateRandomString(16)
+
+		if iss.ID > rp.Comments {
+			rp.Comments = iss.ID
+		}
+
+		known := 0
+		if old := db.GetIssue(id, iss.ID); old != nil {
+			iss.Token = old.Token
+			known = len(old.Answers)
+			if known == len(iss.Answers) && !old.Deleted {
+				continue
+			}
+		} else {
+			db.recordChange(Change{Type: EventIssuePublished, ReportID: id, IssueID: iss.ID})
+		}
+		for _, a := range iss.Answers {
+			if a.ID > known {
+				db.recordChange(Change{Type: EventAnswerCreated, ReportID: id, IssueID: iss.ID, AnswerID: a.ID})
+			}
+		}
+		db.SetIssue(iss)
+	}
+	for iss := range db.GetReportIssues(id, true) {
+		if !listed[iss.ID] {
+			iss.Deleted = true
+			db.SetIssue(*iss)
+		}
+	}
+
+	created := rp.Revisions == 0
+	rp.Revisions = rr.Revision
+	rp.Public = rr.Public
+	rp.CreatedAt = rr.Revisions[0].CreatedAt
+	rp.UpdatedAt = rr.Revisions[rr.Revision-1].CreatedAt
+	db.SetReport(*rp)
+
+	if created {
+		db.recordChange(Change{Type: EventReportCreated, ReportID: id})
+	}
+	for _, rev := range revs {
+		db.updateSimilarity(rev)
+		db.recordChange(Change{Type: EventRevisionCreated, ReportID: id, Version: rev.Version})
+	}
+	if len(revs) > 0 {
+		go db.BuildIndexes()
+	}
+
+	return nil
+}
+
+// Watch keeps the mirror in sync until the returned function is called. After
+// failed syncs it waits for retry.
+func (m *Mirror) Watch(retry time.Duration) func() {
+	stop := make(chan bool)
+
+	go func() {
+		for {
+			n, err := m.sync(m.Wait)
+			if err != nil {
+				log.Printf("Could not sync mirror of %s: %v\n", m.Upstream, err)
+			} else if n > 0 {
+				log.Printf("Synced %d reports from %s\n", n, m.Upstream)
+			}
+
+			delay := time.Duration(0)
+			if err != nil || m.Wait <= 0 {
+				delay = retry
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(delay):
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+	}
+}
@@ -0,0 +1,124 @@
+package aime
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMirror_Sync(t *testing.T) {
+	up := &DB{Dir: "./test-upstream"}
+	up.Create("../../questionnaire.yaml")
+	defer up.Delete()
+
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	rp1 := up.CreateReport("", true)
+	up.CreateRevision(rp1.ID, "", json.RawMessage(`{"MD":{"1":"First"}}`), rp1.Token, true)
+	up.CreateRevision(rp1.ID, "", json.RawMessage(`{"MD":{"1":"Second"}}`), rp1.Token, true)
+	rp2 := up.CreateReport("", false)
+	up.CreateRevision(rp2.ID, "", json.RawMessage("{}"), rp2.Token, false)
+
+	// A published issue and a pending one
+	iss := up.CreateIssue(rp1.ID, "Jane", "jane@test.de", []string{"MD"}, "Question", 0)
+	up.ValidateIssue(rp1.ID, iss.ID, iss.Token)
+	up.CreateAnswer(rp1.ID, iss.ID, "Answer", rp1.Token)
+	pending := up.CreateIssue(rp1.ID, "John", "john@test.de", []string{"MD"}, "Question", 0)
+	up.ValidateIssue(rp1.ID, pending.ID, pending.Token)
+
+	upSrv := Server{DB: up}
+	ts := httptest.NewServer(upSrv.Handler())
+	defer ts.Close()
+
+	// The change log of the upstream leaves out the hidden report
+	m := &Mirror{Upstream: ts.URL, DB: db}
+	if n, err := m.Sync(); err != nil || n != 1 {
+		t.Fatal(n, err)
+	}
+
+	rp := db.GetReport(rp1.ID)
+	if rp == nil || rp.Revisions != 2 || !rp.Public || rp.Token == rp1.Token || rp.Email != "" {
+		t.Fatal(rp)
+	}
+	if rev := db.GetRevision(rp1.ID, 1); rev == nil || string(rev.Answers) != `{"MD":{"1":"First"}}` {
+		t.Fatal(rev)
+	}
+	if db.ExistsReport(rp2.ID) {
+		t.Fatal("hidden report mirrored")
+	}
+	if mi := db.GetIssue(rp1.ID, iss.ID); mi == nil || mi.Pending() || len(mi.Answers) != 1 || mi.Email != "" {
+		t.Fatal(mi)
+	}
+	if db.GetIssue(rp1.ID, pending.ID) != nil {
+		t.Fatal("pending issue mirrored")
+	}
+
+	// Without upstream changes nothing is copied
+	if n, err := m.Sync(); err != nil || n != 0 {
+		t.Fatal(n, err)
+	}
+
+	// Hiding a report removes it from the mirror
+	up.CreateRevision(rp1.ID, "", json.RawMessage("{}"), rp1.Token, false)
+	up.CreateRevision(rp2.ID, "", json.RawMessage("{}"), rp2.Token, true)
+	if n, err := m.Sync(); err != nil || n != 2 {
+		t.Fatal(n, err)
+	}
+	if db.ExistsReport(rp1.ID) {
+		t.Fatal("hidden report still mirrored")
+	}
+	if rp := db.GetReport(rp2.ID); rp == nil || rp.Revisions != 2 {
+		t.Fatal(rp)
+	}
+
+	// The mirror is read-only
+	srv := Server{DB: db, ReadOnly: true}
+	ms := httptest.NewServer(srv.Handler())
+	defer ms.Close()
+
+	resp, _ := http.Post(ms.URL+"/report", "application/json", strings.NewReader(`{"answers":{}}`))
+	if resp.StatusCode != 403 {
+		t.Fatal(resp.StatusCode)
+	}
+	resp, _ = http.Post(ms.URL+"/graphql", "application/json", strings.NewReader(`{"query":"{ reports { count } }"}`))
+	if resp.StatusCode != 200 {
+		t.Fatal(resp.StatusCode)
+	}
+	resp, _ = http.Get(ms.URL + "/report/" + rp2.ID)
+	if resp.StatusCode != 200 {
+		t.Fatal(resp.StatusCode)
+	}
+}
+
+func TestMirror_Watch(t *testing.T) {
+	up := &DB{Dir: "./test-upstream"}
+	up.Create("../../questionnaire.yaml")
+	defer up.Delete()
+
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	upSrv := Server{DB: up}
+	ts := httptest.NewServer(upSrv.Handler())
+	defer ts.Close()
+
+	m := &Mirror{Upstream: ts.URL, DB: db, Wait: time.Second}
+	stop := m.Watch(time.Second)
+	defer stop()
+
+	rp := up.CreateReport("", true)
+	up.CreateRevision(rp.ID, "", json.RawMessage("{}"), rp.Token, true)
+
+	for i := 0; i < 50 && !db.ExistsReport(rp.ID); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if mrp := db.GetReport(rp.ID); mrp == nil || mrp.Revisions != 1 {
+		t.Fatal(mrp)
+	}
+}
@@ -0,0 +1,663 @@
+package aime
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	oaiPageSize    = 100
+	oaiIDPrefix    = "oai:aime-registry.org:"
+	oaiGranularity = "YYYY-MM-DDThh:mm:ssZ"
+	oaiDateFormat  = "2006-01-02T15:04:05Z"
+	oaiDayFormat   = "2006-01-02"
+
+	oaiNamespace   = "http://www.openarchives.org/OAI/2.0/"
+	oaiDCNamespace = "http://www.openarchives.org/OAI/2.0/oai_dc/"
+	dcNamespace    = "http://purl.org/dc/elements/1.1/"
+	aimeNamespace  = "https://aime-registry.org/oai/aime/"
+)
+
+type oaiMetadataFormat struct {
+	Prefix    string `xml:"metadataPrefix"`
+	Schema    string `xml:"schema"`
+	Namespace string `xml:"metadataNamespace"`
+}
+
+var oaiMetadataFormats = []oaiMetadataFormat{
+	{"oai_dc", "http://www.openarchives.org/OAI/2.0/oai_dc.xsd", oaiDCNamespace},
+	{"aime", apiURL + "oai/aime.xsd", aimeNamespace},
+}
+
+// aimeSchema describes the native format: every answered leaf field of the
+// questionnaire with its path, see flattenAnswers.
+const aimeSchema = `<?xml version="1.0" encoding="UTF-8"?>
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema"
+           targetNamespace="https://aime-registry.org/oai/aime/"
+           xmlns:aime="https://aime-registry.org/oai/aime/"
+           elementFormDefault="qualified">
+  <xs:element name="report">
+    <xs:complexType>
+      <xs:sequence>
+        <xs:element name="answer" minOccurs="0" maxOccurs="unbounded">
+          <xs:complexType>
+            <xs:simpleContent>
+              <xs:extension base="xs:string">
+                <xs:attribute name="path" type="xs:string" use="required"/>
+              </xs:extension>
+            </xs:simpleContent>
+          </xs:complexType>
+        </xs:element>
+      </xs:sequence>
+      <xs:attribute name="id" type="xs:string" use="required"/>
+      <xs:attribute name="version" type="xs:integer" use="required"/>
+      <xs:attribute name="createdAt" type="xs:dateTime" use="required"/>
+    </xs:complexType>
+  </xs:element>
+</xs:schema>
+`
+
+type oaiError struct {
+	Code    string `xml:"code,attr"`
+	Message string `xml:",chardata"`
+}
+
+type oaiRequest struct {
+	Attrs []xml.Attr `xml:",any,attr"`
+	URL   string     `xml:",chardata"`
+}
+
+type oaiIdentify struct {
+	RepositoryName    string `xml:"repositoryName"`
+	BaseURL           string `xml:"baseURL"`
+	ProtocolVersion   string `xml:"protocolVersion"`
+	AdminEmail        string `xml:"adminEmail"`
+	EarliestDatestamp string `xml:"earliestDatestamp"`
+	DeletedRecord     string `xml:"deletedRecord"`
+	Granularity       string `xml:"granularity"`
+}
+
+type oaiSet struct {
+	Spec string `xml:"setSpec"`
+	Name string `xml:"setName"`
+}
+
+type oaiHeader struct {
+	Identifier string   `xml:"identifier"`
+	Datestamp  string   `xml:"datestamp"`
+	SetSpecs   []string `xml:"setSpec"`
+}
+
+type oaiDC struct {
+	XMLName        xml.Name `xml:"oai_dc:dc"`
+	XmlnsOaiDC     string   `xml:"xmlns:oai_dc,attr"`
+	XmlnsDC        string   `xml:"xmlns:dc,attr"`
+	SchemaLocation string   `xml:"xsi:schemaLocation,attr"`
+	Title          []string `xml:"dc:title"`
+	Creator        []string `xml:"dc:creator"`
+	Subject        []string `xml:"dc:subject"`
+	Description    []string `xml:"dc:description"`
+	Publisher      string   `xml:"dc:publisher"`
+	Date           string   `xml:"dc:date"`
+	Type           string   `xml:"dc:type"`
+	Identifier     string   `xml:"dc:identifier"`
+	Rights         []string `xml:"dc:rights"`
+}
+
+type oaiAnswer struct {
+	Path  string `xml:"path,attr"`
+	Value string `xml:",chardata"`
+}
+
+type oaiAIMe struct {
+	XMLName        xml.Name    `xml:"aime:report"`
+	XmlnsAIMe      string      `xml:"xmlns:aime,attr"`
+	SchemaLocation string      `xml:"xsi:schemaLocation,attr"`
+	ID             string      `xml:"id,attr"`
+	Version        int         `xml:"version,attr"`
+	CreatedAt      string      `xml:"createdAt,attr"`
+	Answers        []oaiAnswer `xml:"aime:answer"`
+}
+
+type oaiMetadata struct {
+	DC   *oaiDC
+	AIMe *oaiAIMe
+}
+
+type oaiRecord struct {
+	Header   oaiHeader    `xml:"header"`
+	Metadata *oaiMetadata `xml:"metadata"`
+}
+
+type oaiResumptionToken struct {
+	CompleteListSize int    `xml:"completeListSize,attr"`
+	Cursor           int    `xml:"cursor,attr"`
+	Token            string `xml:",chardata"`
+}
+
+type oaiList struct {
+	Headers         []oaiHeader         `xml:"header"`
+	Records         []oaiRecord         `xml:"record"`
+	Sets            []oaiSet            `xml:"set"`
+	Formats         []oaiMetadataFormat `xml:"metadataFormat"`
+	ResumptionToken *oaiResumptionToken `xml:"resumptionToken"`
+}
+
+type oaiResponse struct {
+	XMLName        xml.Name   `xml:"OAI-PMH"`
+	Xmlns          string     `xml:"xmlns,attr"`
+	XmlnsXSI       string     `xml:"xmlns:xsi,attr"`
+	SchemaLocation string     `xml:"xsi:schemaLocation,attr"`
+	ResponseDate   string     `xml:"responseDate"`
+	Request        oaiRequest `xml:"request"`
+	Errors         []oaiError `xml:"error"`
+
+	Identify            *oaiIdentify `xml:"Identify"`
+	ListMetadataFormats *oaiList     `xml:"ListMetadataFormats"`
+	ListSets            *oaiList     `xml:"ListSets"`
+	ListIdentifiers     *oaiList     `xml:"ListIdentifiers"`
+	ListRecords         *oaiList     `xml:"ListRecords"`
+	GetRecord           *oaiList     `xml:"GetRecord"`
+}
+
+// oaiItem is a harvestable report: its latest revision if it is public.
+type oaiItem struct {
+	report    *Report
+	revision  *Revision
+	datestamp time.Time
+	sets      []string
+}
+
+// oaiQuery holds the selective harvesting arguments and the position of a
+// resumption token.
+type oaiQuery struct {
+	prefix string
+	set    string
+	from   time.Time
+	until  time.Time
+
+	// Position behind the last item of the previous page
+	after  *listingCursor
+	cursor int
+}
+
+var oaiArguments = map[string][]string{
+	"Identify":            {},
+	"ListMetadataFormats": {"identifier"},
+	"ListSets":            {"resumptionToken"},
+	"ListIdentifiers":     {"metadataPrefix", "set", "from", "until", "resumptionToken"},
+	"ListRecords":         {"metadataPrefix", "set", "from", "until", "resumptionToken"},
+	"GetRecord":           {"identifier", "metadataPrefix"},
+}
+
+// setSpec turns a category into a set specification, which may only contain
+// unreserved URI characters.
+func setSpec(c string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range foldKeyword(c) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteRune('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+func oaiDate(t time.Time) string {
+	return t.UTC().Format(oaiDateFormat)
+}
+
+// parseOAIDate parses a from or until argument. The returned bool is true if
+// only the day was given.
+func parseOAIDate(v string) (time.Time, bool, error) {
+	if t, err := time.Parse(oaiDateFormat, v); err == nil {
+		return t, false, nil
+	}
+	t, err := time.Parse(oaiDayFormat, v)
+	return t, true, err
+}
+
+func encodeResumptionToken(q oaiQuery, after listingCursor, cursor int) string {
+	from, until := "", ""
+	if !q.from.IsZero() {
+		from = q.from.Format(time.RFC3339)
+	}
+	if !q.until.IsZero() {
+		until = q.until.Format(time.RFC3339)
+	}
+	parts := []string{q.prefix, q.set, from, until, after.date.Format(time.RFC3339Nano), after.id, strconv.Itoa(cursor)}
+	return base64.RawURLEncoding.EncodeToString([]byte(strings.Join(parts, "|")))
+}
+
+func decodeResumptionToken(v string) (*oaiQuery, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return nil, false
+	}
+	parts := strings.Split(string(b), "|")
+	if len(parts) != 7 {
+		return nil, false
+	}
+
+	q := &oaiQuery{prefix: parts[0], set: parts[1]}
+	if parts[2] != "" {
+		if q.from, err = time.Parse(time.RFC3339, parts[2]); err != nil {
+			return nil, false
+		}
+	}
+	if parts[3] != "" {
+		if q.until, err = time.Parse(time.RFC3339, parts[3]); err != nil {
+			return nil, false
+		}
+	}
+	after, err := time.Parse(time.RFC3339Nano, parts[4])
+	if err != nil {
+		return nil, false
+	}
+	q.after = &listingCursor{date: after, id: parts[5]}
+	if q.cursor, err = strconv.Atoi(parts[6]); err != nil {
+		return nil, false
+	}
+	return q, true
+}
+
+func oaiFormat(prefix string) *oaiMetadataFormat {
+	for _, f := range oaiMetadataFormats {
+		if f.Prefix == prefix {
+			return &f
+		}
+	}
+	return nil
+}
+
+func (s *Server) oaiCategories(rev *Revision) []string {
+	ix := s.DB.getIndex("categories")
+	if ix == nil {
+		ix = &index{definition: s.DB.Settings.withDefaults().index("categories"), entries: map[string]*indexEntry{}}
+	}
+	return ix.values(s.DB.questions, s.DB.GetKeywordGroups(), rev.Answers)
+}
+
+func (s *Server) oaiItem(rev *Revision) *oaiItem {
+	rp := s.DB.GetReport(rev.ReportID)
+	if rp == nil {
+		return nil
+	}
+	it := &oaiItem{
+		report:    rp,
+		revision:  rev,
+		datestamp: rev.CreatedAt.UTC().Truncate(time.Second),
+	}
+	for _, c := range s.oaiCategories(rev) {
+		it.sets = append(it.sets, setSpec(c))
+	}
+	return it
+}
+
+// oaiItems returns all harvestable reports, oldest first.
+func (s *Server) oaiItems() []*oaiItem {
+	var its []*oaiItem
+	for rev := range s.DB.GetLatestRevisions(false) {
+		if it := s.oaiItem(rev); it != nil {
+			its = append(its, it)
+		}
+	}
+	sort.Slice(its, func(i, j int) bool {
+		a, b := its[i], its[j]
+		if !a.datestamp.Equal(b.datestamp) {
+			return a.datestamp.Before(b.datestamp)
+		}
+		return a.report.ID < b.report.ID
+	})
+	return its
+}
+
+func (s *Server) oaiGetItem(identifier string) *oaiItem {
+	if !strings.HasPrefix(identifier, oaiIDPrefix) {
+		return nil
+	}
+	rev := s.DB.LatestRevision(strings.TrimPrefix(identifier, oaiIDPrefix))
+	if rev == nil || !rev.Public {
+		return nil
+	}
+	return s.oaiItem(rev)
+}
+
+func (it *oaiItem) header() oaiHeader {
+	return oaiHeader{
+		Identifier: oaiIDPrefix + it.report.ID,
+		Datestamp:  oaiDate(it.datestamp),
+		SetSpecs:   it.sets,
+	}
+}
+
+func nonEmpty(vs []string) []string {
+	var res []string
+	for _, v := range vs {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func (s *Server) oaiRecord(it *oaiItem, prefix string) oaiRecord {
+	rec := oaiRecord{Header: it.header(), Metadata: &oaiMetadata{}}
+	rev := it.revision
+
+	if prefix == "aime" {
+		a := &oaiAIMe{
+			XmlnsAIMe:      aimeNamespace,
+			SchemaLocation: aimeNamespace + " " + apiURL + "oai/aime.xsd",
+			ID:             it.report.ID,
+			Version:        rev.Version,
+			CreatedAt:      oaiDate(rev.CreatedAt),
+		}
+		for _, f := range revisionFields(s.DB.questions, rev) {
+			a.Answers = append(a.Answers, oaiAnswer{f.path, f.value})
+		}
+		rec.Metadata.AIMe = a
+		return rec
+	}
+
+	st := s.DB.Settings.withDefaults()
+	kwg := s.DB.GetKeywordGroups()
+	kwIndex := &index{definition: st.index("keywords"), entries: map[string]*indexEntry{}}
+	lcIndex := &index{definition: st.index("licenses"), entries: map[string]*indexEntry{}}
+
+	rec.Metadata.DC = &oaiDC{
+		XmlnsOaiDC:     oaiDCNamespace,
+		XmlnsDC:        dcNamespace,
+		SchemaLocation: oaiDCNamespace + " http://www.openarchives.org/OAI/2.0/oai_dc.xsd",
+		Title:          nonEmpty([]string{ExtractField(s.DB.questions, rev.Answers, splitField(st.TitleField))}),
+		Creator:        nonEmpty(ExtractFields(s.DB.questions, rev.Answers, splitField(st.AuthorsField))),
+		Subject:        append(kwIndex.values(s.DB.questions, kwg, rev.Answers), s.oaiCategories(rev)...),
+		Description:    nonEmpty([]string{ExtractField(s.DB.questions, rev.Answers, splitField(st.DescriptionField))}),
+		Publisher:      "AIMe Registry",
+		Date:           rev.CreatedAt.UTC().Format(oaiDayFormat),
+		Type:           "Text",
+		Identifier:     reportURL + it.report.ID,
+		Rights:         lcIndex.values(s.DB.questions, kwg, rev.Answers),
+	}
+
+	return rec
+}
+
+// revisionFields flattens the answers of a revision.
+func revisionFields(q Question, rev *Revision) []answerField {
+	var ans interface{}
+	if err := json.Unmarshal(rev.Answers, &ans); err != nil {
+		return nil
+	}
+	return flattenAnswers(q, ans, "")
+}
+
+// parseOAIQuery reads the selective harvesting arguments of ListIdentifiers
+// and ListRecords.
+func (s *Server) parseOAIQuery(args url.Values) (*oaiQuery, *oaiError) {
+	if t := args.Get("resumptionToken"); t != "" {
+		q, ok := decodeResumptionToken(t)
+		if !ok {
+			return nil, &oaiError{"badResumptionToken", "The resumption token is invalid."}
+		}
+		return q, nil
+	}
+
+	q := &oaiQuery{prefix: args.Get("metadataPrefix"), set: args.Get("set")}
+	if q.prefix == "" {
+		return nil, &oaiError{"badArgument", "The argument metadataPrefix is required."}
+	}
+	if oaiFormat(q.prefix) == nil {
+		return nil, &oaiError{"cannotDisseminateFormat", "The metadata format " + q.prefix + " is not supported."}
+	}
+
+	var fromDay, untilDay bool
+	var err error
+	if v := args.Get("from"); v != "" {
+		if q.from, fromDay, err = parseOAIDate(v); err != nil {
+			return nil, &oaiError{"badArgument", "The argument from is not a valid date."}
+		}
+	}
+	if v := args.Get("until"); v != "" {
+		if q.until, untilDay, err = parseOAIDate(v); err != nil {
+			return nil, &oaiError{"badArgument", "The argument until is not a valid date."}
+		}
+		// A day includes all of its seconds
+		if untilDay {
+			q.until = q.until.Add(24*time.Hour - time.Second)
+		}
+	}
+	if args.Get("from") != "" && args.Get("until") != "" {
+		if fromDay != untilDay {
+			return nil, &oaiError{"badArgument", "The arguments from and until have different granularities."}
+		}
+		if q.until.Before(q.from) {
+			return nil, &oaiError{"badArgument", "The argument from is after until."}
+		}
+	}
+
+	return q, nil
+}
+
+func (q *oaiQuery) match(it *oaiItem) bool {
+	if !q.from.IsZero() && it.datestamp.Before(q.from) {
+		return false
+	}
+	if !q.until.IsZero() && it.datestamp.After(q.until) {
+		return false
+	}
+	if q.set != "" {
+		found := false
+		for _, sp := range it.sets {
+			if sp == q.set {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// oaiPage returns the page of items selected by the query and the resumption
+// token, which is nil if the list is complete without paging.
+func (s *Server) oaiPage(q *oaiQuery) ([]*oaiItem, *oaiResumptionToken) {
+	var matched []*oaiItem
+	for _, it := range s.oaiItems() {
+		if q.match(it) {
+			matched = append(matched, it)
+		}
+	}
+
+	start := 0
+	if q.after != nil {
+		start = sort.Search(len(matched), func(i int) bool {
+			it := matched[i]
+			if !it.datestamp.Equal(q.after.date) {
+				return it.datestamp.After(q.after.date)
+			}
+			return it.report.ID > q.after.id
+		})
+	}
+	end := start + oaiPageSize
+	if end > len(matched) {
+		end = len(matched)
+	}
+	page := matched[start:end]
+
+	var rt *oaiResumptionToken
+	if end < len(matched) {
+		last := matched[end-1]
+		rt = &oaiResumptionToken{
+			CompleteListSize: len(matched),
+			Cursor:           q.cursor,
+			Token:            encodeResumptionToken(*q, listingCursor{last.datestamp, last.report.ID}, q.cursor+len(page)),
+		}
+	} else if q.after != nil {
+		// The last page of a paged list ends with an empty token
+		rt = &oaiResumptionToken{CompleteListSize: len(matched), Cursor: q.cursor}
+	}
+
+	return page, rt
+}
+
+func (s *Server) oaiRespond(args url.Values) oaiResponse {
+	resp := oaiResponse{
+		Xmlns:          oaiNamespace,
+		XmlnsXSI:       "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: oaiNamespace + " http://www.openarchives.org/OAI/2.0/OAI-PMH.xsd",
+		ResponseDate:   oaiDate(time.Now()),
+		Request:        oaiRequest{URL: apiURL + "oai"},
+	}
+
+	fail := func(code string, msg string) oaiResponse {
+		resp.Errors = append(resp.Errors, oaiError{code, msg})
+		return resp
+	}
+
+	verb := args.Get("verb")
+	allowed, ok := oaiArguments[verb]
+	if !ok || len(args["verb"]) != 1 {
+		return fail("badVerb", "The verb is missing, repeated or illegal.")
+	}
+
+	for name, vs := range args {
+		if name == "verb" {
+			continue
+		}
+		known := false
+		for _, a := range allowed {
+			known = known || a == name
+		}
+		if !known {
+			return fail("badArgument", "The argument "+name+" is illegal for "+verb+".")
+		}
+		if len(vs) != 1 {
+			return fail("badArgument", "The argument "+name+" is repeated.")
+		}
+	}
+	if args.Get("resumptionToken") != "" && len(args) > 2 {
+		return fail("badArgument", "The argument resumptionToken is exclusive.")
+	}
+
+	// Arguments are only echoed for requests without argument errors
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		resp.Request.Attrs = append(resp.Request.Attrs, xml.Attr{Name: xml.Name{Local: name}, Value: args.Get(name)})
+	}
+
+	switch verb {
+	case "Identify":
+		earliest := time.Now()
+		if its := s.oaiItems(); len(its) > 0 {
+			earliest = its[0].datestamp
+		}
+		resp.Identify = &oaiIdentify{
+			RepositoryName:    "AIMe Registry",
+			BaseURL:           apiURL + "oai",
+			ProtocolVersion:   "2.0",
+			AdminEmail:        "info@aime-registry.org",
+			EarliestDatestamp: oaiDate(earliest),
+			DeletedRecord:     "no",
+			Granularity:       oaiGranularity,
+		}
+
+	case "ListMetadataFormats":
+		if id := args.Get("identifier"); id != "" && s.oaiGetItem(id) == nil {
+			return fail("idDoesNotExist", "The identifier "+id+" is unknown.")
+		}
+		resp.ListMetadataFormats = &oaiList{Formats: oaiMetadataFormats}
+
+	case "ListSets":
+		// All sets fit on one page
+		if args.Get("resumptionToken") != "" {
+			return fail("badResumptionToken", "The resumption token is invalid.")
+		}
+		l := &oaiList{}
+		for _, e := range s.DB.GetCategories() {
+			l.Sets = append(l.Sets, oaiSet{setSpec(e.value), e.value})
+		}
+		if len(l.Sets) == 0 {
+			return fail("noSetHierarchy", "There are no sets yet.")
+		}
+		sort.Slice(l.Sets, func(i, j int) bool {
+			return l.Sets[i].Spec < l.Sets[j].Spec
+		})
+		resp.ListSets = l
+
+	case "ListIdentifiers", "ListRecords":
+		q, oe := s.parseOAIQuery(args)
+		if oe != nil {
+			return fail(oe.Code, oe.Message)
+		}
+		page, rt := s.oaiPage(q)
+		if len(page) == 0 && q.after == nil {
+			return fail("noRecordsMatch", "No records match the arguments.")
+		}
+		l := &oaiList{ResumptionToken: rt}
+		for _, it := range page {
+			if verb == "ListIdentifiers" {
+				l.Headers = append(l.Headers, it.header())
+			} else {
+				l.Records = append(l.Records, s.oaiRecord(it, q.prefix))
+			}
+		}
+		if verb == "ListIdentifiers" {
+			resp.ListIdentifiers = l
+		} else {
+			resp.ListRecords = l
+		}
+
+	case "GetRecord":
+		id, prefix := args.Get("identifier"), args.Get("metadataPrefix")
+		if id == "" || prefix == "" {
+			return fail("badArgument", "The arguments identifier and metadataPrefix are required.")
+		}
+		if oaiFormat(prefix) == nil {
+			return fail("cannotDisseminateFormat", "The metadata format "+prefix+" is not supported.")
+		}
+		it := s.oaiGetItem(id)
+		if it == nil {
+			return fail("idDoesNotExist", "The identifier "+id+" is unknown.")
+		}
+		resp.GetRecord = &oaiList{Records: []oaiRecord{s.oaiRecord(it, prefix)}}
+	}
+
+	return resp
+}
+
+func (s *Server) serveOAI(w http.ResponseWriter, r *http.Request) {
+	var args url.Values
+	if r.Method == "POST" {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		args = r.PostForm
+	} else {
+		args = r.URL.Query()
+	}
+
+	respBytes, _ := xml.MarshalIndent(s.oaiRespond(args), "", "  ")
+
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(respBytes)
+}
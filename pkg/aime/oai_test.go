@@ -0,0 +1,179 @@
+package aime
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type oaiTestResponse struct {
+	Error struct {
+		Code string `xml:"code,attr"`
+	} `xml:"error"`
+	Identify struct {
+		BaseURL string `xml:"baseURL"`
+	} `xml:"Identify"`
+	Sets    []oaiSet    `xml:"ListSets>set"`
+	Headers []oaiHeader `xml:"ListIdentifiers>header"`
+	Token   struct {
+		CompleteListSize int    `xml:"completeListSize,attr"`
+		Cursor           int    `xml:"cursor,attr"`
+		Token            string `xml:",chardata"`
+	} `xml:"ListIdentifiers>resumptionToken"`
+	Records []struct {
+		Identifier string `xml:"header>identifier"`
+		DC         struct {
+			Title   []string `xml:"http://purl.org/dc/elements/1.1/ title"`
+			Creator []string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+			Subject []string `xml:"http://purl.org/dc/elements/1.1/ subject"`
+		} `xml:"metadata>dc"`
+		AIMe struct {
+			ID      string      `xml:"id,attr"`
+			Answers []oaiAnswer `xml:"answer"`
+		} `xml:"metadata>report"`
+	} `xml:"GetRecord>record"`
+}
+
+func oaiGet(t *testing.T, ts *httptest.Server, args url.Values) oaiTestResponse {
+	resp, err := http.Get(ts.URL + "/oai?" + args.Encode())
+	if err != nil || resp.StatusCode != 200 {
+		t.Fatal(err)
+	}
+	respBytes, _ := ioutil.ReadAll(resp.Body)
+	or := oaiTestResponse{}
+	if err := xml.Unmarshal(respBytes, &or); err != nil {
+		t.Fatal(err, string(respBytes))
+	}
+	return or
+}
+
+func TestSetSpec(t *testing.T) {
+	if setSpec("Continuous estimation / Regression") != "continuous-estimation-regression" {
+		t.Fatal(setSpec("Continuous estimation / Regression"))
+	}
+}
+
+func TestServer_OAI(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	var first *Report
+	for i, c := range []string{"cf", "cf", "cl"} {
+		rp := db.CreateReport("", true)
+		db.CreateRevision(rp.ID, "", json.RawMessage("{\"MD\":{\"1\":\"Title\",\"5\":[{\"custom\":true,\"value\":\"ecg\"}],\"6\":[{\"1\":\"Jane Doe\"}]},\"P\":{\"3\":{\"1\":{\"custom\":false,\"value\":\""+c+"\"}}}}"), rp.Token, true)
+		if i == 0 {
+			first = rp
+		}
+	}
+	hidden := db.CreateReport("", false)
+	db.CreateRevision(hidden.ID, "", json.RawMessage("{\"MD\":{\"1\":\"Hidden\"}}"), hidden.Token, false)
+	db.BuildIndexes()
+
+	srv := Server{DB: db}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	or := oaiGet(t, ts, url.Values{"verb": {"Identify"}})
+	if or.Identify.BaseURL != apiURL+"oai" {
+		t.Fatal(or)
+	}
+
+	or = oaiGet(t, ts, url.Values{"verb": {"Unknown"}})
+	if or.Error.Code != "badVerb" {
+		t.Fatal(or)
+	}
+
+	or = oaiGet(t, ts, url.Values{"verb": {"ListSets"}})
+	if len(or.Sets) != 2 || or.Sets[0].Spec != "classification" {
+		t.Fatal(or)
+	}
+
+	or = oaiGet(t, ts, url.Values{"verb": {"ListIdentifiers"}})
+	if or.Error.Code != "badArgument" {
+		t.Fatal(or)
+	}
+
+	or = oaiGet(t, ts, url.Values{"verb": {"ListIdentifiers"}, "metadataPrefix": {"marc"}})
+	if or.Error.Code != "cannotDisseminateFormat" {
+		t.Fatal(or)
+	}
+
+	or = oaiGet(t, ts, url.Values{"verb": {"ListIdentifiers"}, "metadataPrefix": {"oai_dc"}, "set": {"classification"}})
+	if len(or.Headers) != 2 || or.Token.Token != "" {
+		t.Fatal(or)
+	}
+
+	or = oaiGet(t, ts, url.Values{"verb": {"ListIdentifiers"}, "metadataPrefix": {"oai_dc"}, "from": {"2000-01-01"}, "until": {"2000-12-31"}})
+	if or.Error.Code != "noRecordsMatch" {
+		t.Fatal(or)
+	}
+
+	or = oaiGet(t, ts, url.Values{"verb": {"GetRecord"}, "metadataPrefix": {"oai_dc"}, "identifier": {oaiIDPrefix + first.ID}})
+	if len(or.Records) != 1 || or.Records[0].DC.Title[0] != "Title" || or.Records[0].DC.Creator[0] != "Jane Doe" {
+		t.Fatal(or)
+	}
+	if strings.Join(or.Records[0].DC.Subject, ",") != "ecg,Classification" {
+		t.Fatal(or)
+	}
+
+	or = oaiGet(t, ts, url.Values{"verb": {"GetRecord"}, "metadataPrefix": {"aime"}, "identifier": {oaiIDPrefix + first.ID}})
+	if len(or.Records) != 1 || or.Records[0].AIMe.ID != first.ID || or.Records[0].AIMe.Answers[0].Path != "MD.1" {
+		t.Fatal(or)
+	}
+
+	or = oaiGet(t, ts, url.Values{"verb": {"GetRecord"}, "metadataPrefix": {"oai_dc"}, "identifier": {oaiIDPrefix + hidden.ID}})
+	if or.Error.Code != "idDoesNotExist" {
+		t.Fatal(or)
+	}
+}
+
+func TestServer_OAI_ResumptionToken(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	for i := 0; i < oaiPageSize+5; i++ {
+		rp := db.CreateReport("", true)
+		db.CreateRevision(rp.ID, "", json.RawMessage("{\"MD\":{\"1\":\"Title\"}}"), rp.Token, true)
+	}
+
+	srv := Server{DB: db}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	or := oaiGet(t, ts, url.Values{"verb": {"ListIdentifiers"}, "metadataPrefix": {"oai_dc"}})
+	if len(or.Headers) != oaiPageSize || or.Token.Token == "" || or.Token.CompleteListSize != oaiPageSize+5 {
+		t.Fatal(len(or.Headers), or.Token)
+	}
+	seen := map[string]bool{}
+	for _, h := range or.Headers {
+		seen[h.Identifier] = true
+	}
+
+	or = oaiGet(t, ts, url.Values{"verb": {"ListIdentifiers"}, "resumptionToken": {or.Token.Token}, "set": {"x"}})
+	if or.Error.Code != "badArgument" {
+		t.Fatal(or)
+	}
+
+	first := oaiGet(t, ts, url.Values{"verb": {"ListIdentifiers"}, "metadataPrefix": {"oai_dc"}})
+	or = oaiGet(t, ts, url.Values{"verb": {"ListIdentifiers"}, "resumptionToken": {first.Token.Token}})
+	if len(or.Headers) != 5 || or.Token.Token != "" || or.Token.Cursor != oaiPageSize {
+		t.Fatal(len(or.Headers), or.Token)
+	}
+	for _, h := range or.Headers {
+		if seen[h.Identifier] {
+			t.Fatal(h.Identifier)
+		}
+	}
+
+	or = oaiGet(t, ts, url.Values{"verb": {"ListIdentifiers"}, "resumptionToken": {"invalid"}})
+	if or.Error.Code != "badResumptionToken" {
+		t.Fatal(or)
+	}
+}
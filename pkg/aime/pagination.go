@@ -0,0 +1,221 @@
+package aime
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidListing = errors.New("invalid listing parameters")
+
+// listing holds the date filters, sort order and page of a result listing.
+//
+// Pages are selected by cursor. A cursor points behind the last result of the
+// previous page and carries the time of the first page. The following pages
+// list the reports as of that time, so reports created or updated in the
+// meantime neither shift nor skip results, whether sorted by creation date of
+// the report (sort=created) or by date of the latest revision (sort=updated,
+// default).
+type listing struct {
+	createdAfter  time.Time
+	createdBefore time.Time
+	updatedAfter  time.Time
+	updatedBefore time.Time
+
+	sortBy string
+
+	// at is the time of the first page
+	at     time.Time
+	cursor *listingCursor
+	// limit is negative for unlimited listings
+	limit int
+}
+
+type listingCursor struct {
+	date time.Time
+	id   string
+}
+
+type listItem struct {
+	report   *Report
+	revision *Revision
+	// updated is the date of the latest revision at the time of the listing
+	updated time.Time
+}
+
+// unlimited is the default limit of listings that return all results unless
+// a limit or cursor is given.
+const unlimited = -1
+
+func parseListingDate(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return time.Time{}, ErrInvalidListing
+	}
+	return t, nil
+}
+
+func encodeCursor(at time.Time, c listingCursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(at.Format(time.RFC3339Nano) + "|" + c.date.Format(time.RFC3339Nano) + "|" + c.id))
+}
+
+func decodeCursor(v string) (time.Time, *listingCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return time.Time{}, nil, ErrInvalidListing
+	}
+	parts := strings.SplitN(string(b), "|", 3)
+	if len(parts) != 3 {
+		return time.Time{}, nil, ErrInvalidListing
+	}
+	at, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, nil, ErrInvalidListing
+	}
+	t, err := time.Parse(time.RFC3339Nano, parts[1])
+	if err != nil {
+		return time.Time{}, nil, ErrInvalidListing
+	}
+	return at, &listingCursor{date: t, id: parts[2]}, nil
+}
+
+// parseListing reads the listing parameters of a request. The limit l
+// defaults to defaultLimit and is capped at maxLimit. Unlimited listings are
+// capped as well once a cursor is given.
+func parseListing(q url.Values, defaultLimit int, maxLimit int) (*listing, error) {
+	l := &listing{at: time.Now()}
+
+	var err error
+	for _, p := range []struct {
+		name string
+		date *time.Time
+	}{
+		{"created_after", &l.createdAfter},
+		{"created_before", &l.createdBefore},
+		{"updated_after", &l.updatedAfter},
+		{"updated_before", &l.updatedBefore},
+	} {
+		*p.date, err = parseListingDate(q.Get(p.name))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	l.sortBy = q.Get("sort")
+	if l.sortBy == "" {
+		l.sortBy = "updated"
+	}
+	if l.sortBy != "updated" && l.sortBy != "created" {
+		return nil, ErrInvalidListing
+	}
+
+	if c := q.Get("cursor"); c != "" {
+		l.at, l.cursor, err = decodeCursor(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	l.limit = defaultLimit
+	if lv := q.Get("l"); lv != "" {
+		l.limit, _ = strconv.Atoi(lv)
+		if l.limit < 0 {
+			l.limit = 0
+		}
+	} else if l.limit < 0 && l.cursor != nil {
+		l.limit = maxLimit
+	}
+	if l.limit > maxLimit {
+		l.limit = maxLimit
+	}
+
+	return l, nil
+}
+
+func (l *listing) match(it listItem) bool {
+	created := it.report.CreatedAt
+	updated := it.updated
+	if !l.createdAfter.IsZero() && !created.After(l.createdAfter) {
+		return false
+	}
+	if !l.createdBefore.IsZero() && !created.Before(l.createdBefore) {
+		return false
+	}
+	if !l.updatedAfter.IsZero() && !updated.After(l.updatedAfter) {
+		return false
+	}
+	if !l.updatedBefore.IsZero() && !updated.Before(l.updatedBefore) {
+		return false
+	}
+	return true
+}
+
+func (l *listing) key(it listItem) listingCursor {
+	if l.sortBy == "created" {
+		return listingCursor{it.report.CreatedAt, it.report.ID}
+	}
+	return listingCursor{it.updated, it.report.ID}
+}
+
+// before reports whether a comes before b, i.e. newer first and by ID for equal dates.
+func (a listingCursor) before(b listingCursor) bool {
+	if !a.date.Equal(b.date) {
+		return a.date.After(b.date)
+	}
+	return a.id < b.id
+}
+
+// apply filters and sorts the items as of the time of the listing and returns
+// the total count, the requested page and the cursor of the next page if there
+// is one. Reports created later are left out, reports updated later are
+// sorted by the revision that was the latest then, which revision returns.
+func (l *listing) apply(items []listItem, revision func(id string, ver int) *Revision) (int, []listItem, string) {
+	var matched []listItem
+	for _, it := range items {
+		if it.report.CreatedAt.After(l.at) {
+			continue
+		}
+		it.updated = it.revision.CreatedAt
+		for ver := it.revision.Version - 1; it.updated.After(l.at) && ver > 0; ver-- {
+			if rev := revision(it.report.ID, ver); rev != nil {
+				it.updated = rev.CreatedAt
+			}
+		}
+		if l.match(it) {
+			matched = append(matched, it)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return l.key(matched[i]).before(l.key(matched[j]))
+	})
+
+	start := 0
+	if l.cursor != nil {
+		start = sort.Search(len(matched), func(i int) bool {
+			return l.cursor.before(l.key(matched[i]))
+		})
+	}
+
+	end := start + l.limit
+	if l.limit < 0 || end > len(matched) {
+		end = len(matched)
+	}
+
+	next := ""
+	if end < len(matched) && end > start {
+		next = encodeCursor(l.at, l.key(matched[end-1]))
+	}
+
+	return len(matched), matched[start:end], next
+}
@@ -0,0 +1,169 @@
+package aime
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func testListItems() []listItem {
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	var items []listItem
+	for i, id := range []string{"a", "b", "c", "d"} {
+		items = append(items, listItem{
+			report:   &Report{ID: id, CreatedAt: base.Add(time.Duration(i) * 24 * time.Hour)},
+			revision: &Revision{ReportID: id, Version: 1, CreatedAt: base.Add(time.Duration(10-i) * 24 * time.Hour)},
+		})
+	}
+	return items
+}
+
+// testRevisions returns the revisions of the items, as they are before any
+// update.
+func testRevisions(items []listItem) func(id string, ver int) *Revision {
+	revs := map[string]*Revision{}
+	for _, it := range items {
+		revs[it.report.ID] = it.revision
+	}
+	return func(id string, ver int) *Revision {
+		if ver != 1 {
+			return nil
+		}
+		return revs[id]
+	}
+}
+
+func TestParseListing(t *testing.T) {
+	l, err := parseListing(url.Values{"created_after": {"2021-01-02"}, "l": {"500"}}, 10, 100)
+	if err != nil || l.limit != 100 || l.createdAfter.Day() != 2 || l.sortBy != "updated" {
+		t.Fatal(err)
+	}
+
+	l, err = parseListing(url.Values{}, 10, 100)
+	if err != nil || l.limit != 10 {
+		t.Fatal(err)
+	}
+
+	l, _ = parseListing(url.Values{}, unlimited, 100)
+	if l.limit >= 0 {
+		t.Fatal(l.limit)
+	}
+	l, _ = parseListing(url.Values{"cursor": {encodeCursor(time.Now(), listingCursor{time.Now(), "a"})}}, unlimited, 100)
+	if l.limit != 100 || l.cursor.id != "a" {
+		t.Fatal(l.limit)
+	}
+
+	if _, err := parseListing(url.Values{"updated_before": {"yesterday"}}, 10, 100); err != ErrInvalidListing {
+		t.Fatal(err)
+	}
+	if _, err := parseListing(url.Values{"cursor": {"!!"}}, 10, 100); err != ErrInvalidListing {
+		t.Fatal(err)
+	}
+	if _, err := parseListing(url.Values{"sort": {"title"}}, 10, 100); err != ErrInvalidListing {
+		t.Fatal(err)
+	}
+}
+
+func TestListing_apply(t *testing.T) {
+	items := testListItems()
+	revision := testRevisions(items)
+
+	l, _ := parseListing(url.Values{"l": {"2"}}, 0, 100)
+	count, page, next := l.apply(items, revision)
+	if count != 4 || len(page) != 2 || page[0].report.ID != "a" || next == "" {
+		t.Fatal(count, page, next)
+	}
+
+	// Updates and new reports after the first page do not change the next
+	// page, neither of listed reports nor of those still to come
+	items[1].revision = &Revision{ReportID: "b", Version: 2, CreatedAt: time.Now()}
+	items[2].revision = &Revision{ReportID: "c", Version: 2, CreatedAt: time.Now()}
+	items = append(items, listItem{
+		report:   &Report{ID: "e", CreatedAt: time.Now()},
+		revision: &Revision{ReportID: "e", Version: 1, CreatedAt: time.Now()},
+	})
+
+	l, _ = parseListing(url.Values{"l": {"2"}, "cursor": {next}}, 0, 100)
+	count, page, next = l.apply(items, revision)
+	if count != 4 || len(page) != 2 || page[0].report.ID != "c" || page[1].report.ID != "d" || next != "" {
+		t.Fatal(count, page, next)
+	}
+	// The listed revision is the latest one
+	if page[0].revision.Version != 2 {
+		t.Fatal(page[0].revision)
+	}
+
+	// A new listing sees the updates
+	l, _ = parseListing(url.Values{"l": {"3"}}, 0, 100)
+	count, page, _ = l.apply(items, revision)
+	if count != 5 || page[0].report.ID != "e" || page[1].report.ID != "c" || page[2].report.ID != "b" {
+		t.Fatal(page)
+	}
+
+	l, _ = parseListing(url.Values{"sort": {"created"}, "created_after": {"2021-01-02T00:00:00Z"}, "created_before": {"2021-01-04"}}, 10, 100)
+	count, page, _ = l.apply(items, revision)
+	if count != 1 || page[0].report.ID != "c" {
+		t.Fatal(count, page)
+	}
+
+	l, _ = parseListing(url.Values{"updated_before": {"2021-01-10"}}, unlimited, 100)
+	count, page, next = l.apply(testListItems(), revision)
+	if count != 2 || len(page) != 2 || page[0].report.ID != "c" || next != "" {
+		t.Fatal(count, page)
+	}
+}
+
+func TestServer_SearchListing(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	for i := 0; i < 3; i++ {
+		rp := db.CreateReport("", true)
+		db.CreateRevision(rp.ID, "", json.RawMessage("{\"MD\":{\"1\":\"Title\"}}"), rp.Token, true)
+	}
+
+	srv := Server{DB: db}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	seen := map[string]bool{}
+	next := ""
+	for i := 0; i < 3; i++ {
+		resp, _ := http.Get(ts.URL + "/search?l=1&sort=created&cursor=" + next)
+		respBytes, _ := ioutil.ReadAll(resp.Body)
+		searchResp := SearchResponse{}
+		json.Unmarshal(respBytes, &searchResp)
+		if searchResp.Count < 3 || len(searchResp.Results) != 1 || searchResp.Results[0].CreatedAt.IsZero() {
+			t.Fatal(string(respBytes))
+		}
+		seen[searchResp.Results[0].ID] = true
+		next = searchResp.Next
+
+		// New reports appear before the cursor
+		if i == 0 {
+			rp := db.CreateReport("", true)
+			db.CreateRevision(rp.ID, "", json.RawMessage("{}"), rp.Token, true)
+		}
+	}
+	if len(seen) != 3 || next != "" {
+		t.Fatal(seen, next)
+	}
+
+	resp, _ := http.Get(ts.URL + "/search?l=10&updated_after=" + url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)))
+	respBytes, _ := ioutil.ReadAll(resp.Body)
+	searchResp := SearchResponse{}
+	json.Unmarshal(respBytes, &searchResp)
+	if searchResp.Count != 0 {
+		t.Fatal(string(respBytes))
+	}
+
+	resp, _ = http.Get(ts.URL + "/search?created_before=tomorrow")
+	if resp.StatusCode != 400 {
+		t.Fatal()
+	}
+}
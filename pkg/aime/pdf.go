@@ -0,0 +1,355 @@
+package aime
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The PDF is written by hand with the standard Helvetica fonts, which every
+// PDF reader provides, so there is nothing to embed. Text is encoded in
+// WinAnsiEncoding, characters outside of it are replaced by "?".
+
+const (
+	pdfRegular = iota
+	pdfBold
+	pdfOblique
+)
+
+var pdfFontNames = []string{"Helvetica", "Helvetica-Bold", "Helvetica-Oblique"}
+
+// Glyph widths of the characters 32 to 126 in 1/1000 of the font size
+var helveticaWidths = []int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helveticaBoldWidths = []int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
+
+var pdfWinAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '‘': 0x91, '’': 0x92,
+	'“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '™': 0x99,
+}
+
+func pdfEncode(s string) []byte {
+	var b []byte
+	for _, r := range s {
+		switch {
+		case r == '\t':
+			b = append(b, ' ')
+		case r >= 32 && r <= 126, r >= 0xA0 && r <= 0xFF:
+			b = append(b, byte(r))
+		case pdfWinAnsi[r] != 0:
+			b = append(b, pdfWinAnsi[r])
+		default:
+			b = append(b, '?')
+		}
+	}
+	return b
+}
+
+func pdfTextWidth(b []byte, font int, size float64) float64 {
+	widths := helveticaWidths
+	if font == pdfBold {
+		widths = helveticaBoldWidths
+	}
+	w := 0
+	for _, c := range b {
+		if c >= 32 && c <= 126 {
+			w += widths[c-32]
+		} else {
+			w += 556
+		}
+	}
+	return float64(w) * size / 1000
+}
+
+// pdfString writes b as PDF literal string.
+func pdfString(b []byte) string {
+	sb := &strings.Builder{}
+	sb.WriteByte('(')
+	for _, c := range b {
+		switch {
+		case c == '(' || c == ')' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c < 32 || c > 126:
+			fmt.Fprintf(sb, "\\%03o", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte(')')
+	return sb.String()
+}
+
+// Layout
+
+const (
+	pdfPageWidth  = 595.28
+	pdfPageHeight = 841.89
+	pdfMargin     = 56.0
+	pdfFooter     = 24.0
+)
+
+type pdfText struct {
+	x, y float64
+	font int
+	size float64
+	gray float64
+	blue bool
+	text []byte
+}
+
+type pdfLink struct {
+	x1, y1, x2, y2 float64
+	uri            string
+}
+
+type pdfPage struct {
+	texts []pdfText
+	links []pdfLink
+}
+
+type pdfLayout struct {
+	pages []*pdfPage
+	y     float64
+}
+
+func (l *pdfLayout) newPage() {
+	l.pages = append(l.pages, &pdfPage{})
+	l.y = pdfPageHeight - pdfMargin
+}
+
+func (l *pdfLayout) page() *pdfPage {
+	return l.pages[len(l.pages)-1]
+}
+
+// space moves down by h, starting a new page if there is not enough room.
+func (l *pdfLayout) space(h float64) {
+	if l.pages == nil || l.y-h < pdfMargin+pdfFooter {
+		l.newPage()
+	}
+	l.y -= h
+}
+
+// wrap breaks text into lines fitting into width, long words are broken.
+func pdfWrap(text string, font int, size float64, width float64) [][]byte {
+	var lines [][]byte
+	for _, para := range strings.Split(strings.TrimSpace(text), "\n") {
+		var line []byte
+		for _, word := range strings.Fields(para) {
+			wb := pdfEncode(word)
+			candidate := append(append([]byte{}, line...), wb...)
+			if line != nil {
+				candidate = append(append(append([]byte{}, line...), ' '), wb...)
+			}
+			if pdfTextWidth(candidate, font, size) <= width {
+				line = candidate
+				continue
+			}
+			if line != nil {
+				lines = append(lines, line)
+				line = nil
+			}
+			for pdfTextWidth(wb, font, size) > width {
+				i := 1
+				for i < len(wb) && pdfTextWidth(wb[:i+1], font, size) <= width {
+					i++
+				}
+				lines = append(lines, wb[:i])
+				wb = wb[i:]
+			}
+			line = wb
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func (l *pdfLayout) paragraph(text string, font int, size float64, indent float64, gray float64) {
+	width := pdfPageWidth - 2*pdfMargin - indent
+	for _, line := range pdfWrap(text, font, size, width) {
+		l.space(size * 1.35)
+		if len(line) > 0 {
+			l.page().texts = append(l.page().texts, pdfText{pdfMargin + indent, l.y, font, size, gray, false, line})
+		}
+	}
+}
+
+func (l *pdfLayout) link(text string, uri string, size float64, indent float64) {
+	l.space(size * 1.35)
+	tb := pdfEncode(text)
+	x := pdfMargin + indent
+	p := l.page()
+	p.texts = append(p.texts, pdfText{x, l.y, pdfRegular, size, 0, true, tb})
+	p.links = append(p.links, pdfLink{x, l.y - size*0.25, x + pdfTextWidth(tb, pdfRegular, size), l.y + size, uri})
+}
+
+func (n *renderNode) pdf(l *pdfLayout, level int, indent float64) {
+	if n.children != nil {
+		size := 16.0 - 2*float64(level)
+		if size < 10 {
+			size = 10
+		}
+		l.space(size * 0.6)
+		l.paragraph(n.title, pdfBold, size, indent, 0)
+		if n.question != "" {
+			l.paragraph(n.question, pdfOblique, 9, indent, 0.4)
+		}
+		l.space(4)
+		for _, ch := range n.children {
+			ch.pdf(l, level+1, indent+12)
+		}
+		return
+	}
+
+	l.paragraph(n.title, pdfBold, 10, indent, 0)
+	if n.question != "" {
+		l.paragraph(n.question, pdfOblique, 9, indent, 0.4)
+	}
+	for _, v := range n.values {
+		switch {
+		case n.documents:
+			l.link(v, apiURL+"documents/"+v, 10, indent)
+		case len(n.values) > 1:
+			l.paragraph("• "+v, pdfRegular, 10, indent, 0)
+		default:
+			l.paragraph(v, pdfRegular, 10, indent, 0)
+		}
+	}
+	l.space(6)
+}
+
+// Writer
+
+func (rr renderedReport) pdf() []byte {
+	l := &pdfLayout{}
+	l.paragraph(rr.title, pdfBold, 20, 0, 0)
+	l.space(4)
+	l.paragraph("AIMe report "+rr.id+", version "+strconv.Itoa(rr.version), pdfRegular, 10, 0, 0.3)
+	l.paragraph("Created "+rr.createdAt.Format("2006-01-02 15:04 UTC"), pdfRegular, 10, 0, 0.3)
+	l.link(rr.url, rr.url, 10, 0)
+	l.space(10)
+	for _, n := range rr.sections {
+		n.pdf(l, 1, 0)
+	}
+
+	// Footer with page numbers
+	for i, p := range l.pages {
+		left := pdfEncode("AIMe report " + rr.id + ", version " + strconv.Itoa(rr.version))
+		right := pdfEncode("Page " + strconv.Itoa(i+1) + " of " + strconv.Itoa(len(l.pages)))
+		p.texts = append(p.texts,
+			pdfText{pdfMargin, pdfMargin - 12, pdfRegular, 8, 0.5, false, left},
+			pdfText{pdfPageWidth - pdfMargin - pdfTextWidth(right, pdfRegular, 8), pdfMargin - 12, pdfRegular, 8, 0.5, false, right})
+	}
+
+	buf := &bytes.Buffer{}
+	var offsets []int
+	obj := func(content string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(buf, "%d 0 obj\n%s\nendobj\n", len(offsets), content)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1 to 5: catalog, page tree, fonts. Every page takes two objects
+	// (page and content) and one per link, pages start at 7.
+	pageIDs := []string{}
+	next := 7
+	for _, p := range l.pages {
+		pageIDs = append(pageIDs, strconv.Itoa(next)+" 0 R")
+		next += 2 + len(p.links)
+	}
+
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(pageIDs, " "), len(l.pages)))
+	for _, name := range pdfFontNames {
+		obj("<< /Type /Font /Subtype /Type1 /BaseFont /" + name + " /Encoding /WinAnsiEncoding >>")
+	}
+	obj(fmt.Sprintf("<< /Title %s /Producer (AIMe Registry) /CreationDate (D:%s) >>",
+		pdfString(pdfEncode(rr.title)), rr.createdAt.Format("20060102150405Z")))
+
+	for _, p := range l.pages {
+		id := len(offsets) + 1
+
+		var annots []string
+		for i := range p.links {
+			annots = append(annots, strconv.Itoa(id+2+i)+" 0 R")
+		}
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R /F3 5 0 R >> >> /Contents %d 0 R /Annots [%s] >>",
+			pdfPageWidth, pdfPageHeight, id+1, strings.Join(annots, " ")))
+
+		cs := &bytes.Buffer{}
+		for _, t := range p.texts {
+			if t.blue {
+				cs.WriteString("0 0 0.8 rg ")
+			} else {
+				fmt.Fprintf(cs, "%.2f g ", t.gray)
+			}
+			fmt.Fprintf(cs, "BT /F%d %.1f Tf %.2f %.2f Td %s Tj ET\n", t.font+1, t.size, t.x, t.y, pdfString(t.text))
+		}
+		zb := &bytes.Buffer{}
+		zw := zlib.NewWriter(zb)
+		zw.Write(cs.Bytes())
+		zw.Close()
+		obj(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", zb.Len(), zb.String()))
+
+		for _, lk := range p.links {
+			obj(fmt.Sprintf("<< /Type /Annot /Subtype /Link /Rect [%.2f %.2f %.2f %.2f] /Border [0 0 0] /A << /S /URI /URI %s >> >>",
+				lk.x1, lk.y1, lk.x2, lk.y2, pdfString([]byte(lk.uri))))
+		}
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, o := range offsets {
+		fmt.Fprintf(buf, "%010d 00000 n \n", o)
+	}
+	fmt.Fprintf(buf, "trailer\n<< /Size %d /Root 1 0 R /Info 6 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return buf.Bytes()
+}
+
+// RenderPDF renders the answered questions of a revision as PDF. Revisions do
+// not change, so the PDF is rendered once and cached next to the revision.
+func (db *DB) RenderPDF(rp *Report, rev *Revision) []byte {
+	pdfPath := db.pdfFilePath(rp.ID, rev.Version)
+	if pdfBytes, err := ioutil.ReadFile(pdfPath); err == nil {
+		return pdfBytes
+	}
+
+	pdfBytes := db.renderReport(rp, rev).pdf()
+
+	// Write to a temporary file first, so concurrent requests never read a
+	// partial PDF
+	os.MkdirAll(filepath.Dir(pdfPath), os.ModePerm)
+	tmpFile, err := ioutil.TempFile(filepath.Dir(pdfPath), "pdf")
+	if err != nil {
+		return pdfBytes
+	}
+	tmpFile.Write(pdfBytes)
+	tmpFile.Close()
+	if os.Rename(tmpFile.Name(), pdfPath) != nil {
+		os.Remove(tmpFile.Name())
+	}
+
+	return pdfBytes
+}
@@ -0,0 +1,71 @@
+package aime
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPdfWrap(t *testing.T) {
+	lines := pdfWrap("aaa bbb ccc\n\nddddddddddddd", pdfRegular, 10, 40)
+	if len(lines) != 5 || string(lines[0]) != "aaa bbb" || string(lines[1]) != "ccc" || len(lines[2]) != 0 {
+		t.Fatal(len(lines))
+	}
+	if pdfString(pdfEncode("a(b)\\ ü€ 漢")) != "(a\\(b\\)\\\\ \\374\\200 ?)" {
+		t.Fatal(pdfString(pdfEncode("a(b)\\ ü€ 漢")))
+	}
+}
+
+func TestDB_RenderPDF(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	rp := db.CreateReport("", true)
+	jn := "{\"MD\":{\"1\":\"Heart failure (ECG)\",\"3\":\"" + strings.Repeat("Long description. ", 800) + "\"}}"
+	rev := db.CreateRevision(rp.ID, "", json.RawMessage(jn), rp.Token, true)
+
+	pdf := db.RenderPDF(rp, rev)
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatal()
+	}
+	if !bytes.Contains(pdf, []byte("/URI ("+reportURL+rp.ID+"/1)")) {
+		t.Fatal()
+	}
+	if c := regexp.MustCompile(`/Count (\d+)`).FindSubmatch(pdf); c == nil || string(c[1]) == "1" {
+		t.Fatal("expected several pages")
+	}
+
+	// Every cross-reference entry points to its object
+	xref := regexp.MustCompile(`startxref\n(\d+)`).FindSubmatch(pdf)
+	start, _ := strconv.Atoi(string(xref[1]))
+	entries := regexp.MustCompile(`(\d{10}) 00000 n`).FindAllSubmatch(pdf[start:], -1)
+	for i, e := range entries {
+		off, _ := strconv.Atoi(string(e[1]))
+		if !bytes.HasPrefix(pdf[off:], []byte(strconv.Itoa(i+1)+" 0 obj")) {
+			t.Fatal(i + 1)
+		}
+	}
+
+	// Cached on disk
+	cached, err := ioutil.ReadFile(db.pdfFilePath(rp.ID, 1))
+	if err != nil || !bytes.Equal(cached, pdf) {
+		t.Fatal(err)
+	}
+
+	srv := Server{DB: db}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, _ := http.Get(ts.URL + "/report/" + rp.ID + "/1.pdf")
+	respBytes, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "application/pdf" || !bytes.Equal(respBytes, pdf) {
+		t.Fatal(resp.StatusCode)
+	}
+}
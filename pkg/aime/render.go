@@ -0,0 +1,308 @@
+package aime
+
+import (
+	"encoding/json"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// renderNode is a question of a revision prepared for rendering: either a
+// group of questions with children or an answered leaf with values.
+type renderNode struct {
+	title    string
+	question string
+	values   []string
+	// documents is set if the values are names of uploaded files
+	documents bool
+	children  []*renderNode
+}
+
+// renderedReport holds what is shown of a revision.
+type renderedReport struct {
+	id        string
+	version   int
+	title     string
+	date      string
+	createdAt time.Time
+	url       string
+	sections  []*renderNode
+}
+
+func questionLabel(q Question) (string, string) {
+	if q.Title == "" {
+		return q.Question, ""
+	}
+	if q.Question == q.Title {
+		return q.Title, ""
+	}
+	return q.Title, q.Question
+}
+
+// buildRenderNode walks the question tree along the answers. Unanswered
+// questions and questions whose condition is not met are left out, it returns
+// nil if nothing is left.
+func buildRenderNode(q Question, a interface{}) *renderNode {
+	if a == nil {
+		return nil
+	}
+
+	n := &renderNode{}
+	n.title, n.question = questionLabel(q)
+
+	switch q.Type {
+	case "string", "text":
+		if str, _ := a.(string); strings.TrimSpace(str) != "" {
+			n.values = []string{str}
+		}
+	case "file":
+		if str, _ := a.(string); str != "" {
+			n.values = []string{str}
+			n.documents = true
+		}
+	case "boolean":
+		if b, ok := a.(bool); ok {
+			n.values = []string{"No"}
+			if b {
+				n.values = []string{"Yes"}
+			}
+		}
+	case "select", "radio":
+		if val := extractValue(a, q); val != "" {
+			n.values = []string{val}
+		}
+	case "checkboxes", "tags":
+		vals, _ := a.([]interface{})
+		for _, v := range vals {
+			if val := extractValue(v, q); val != "" {
+				n.values = append(n.values, val)
+			}
+		}
+	case "complex":
+		compl, _ := a.(map[string]interface{})
+		for _, child := range q.Children {
+			if !child.shown(compl) {
+				continue
+			}
+			if cn := buildRenderNode(child, compl[child.ID]); cn != nil {
+				n.children = append(n.children, cn)
+			}
+		}
+		if len(n.children) == 0 {
+			return nil
+		}
+		return n
+	case "list":
+		if q.Child == nil {
+			return nil
+		}
+		list, _ := a.([]interface{})
+		for i, ae := range list {
+			cn := buildRenderNode(*q.Child, ae)
+			if cn == nil {
+				continue
+			}
+			if cn.title == "" {
+				cn.title = n.title
+			}
+			cn.title += " " + strconv.Itoa(i+1)
+			cn.question = ""
+			n.children = append(n.children, cn)
+		}
+		if len(n.children) == 0 {
+			return nil
+		}
+		return n
+	}
+
+	if len(n.values) == 0 {
+		return nil
+	}
+	return n
+}
+
+func (db *DB) renderReport(rp *Report, rev *Revision) renderedReport {
+	st := db.Settings.withDefaults()
+
+	rr := renderedReport{
+		id:        rp.ID,
+		version:   rev.Version,
+		title:     ExtractField(db.questions, rev.Answers, splitField(st.TitleField)),
+		date:      rev.CreatedAt.UTC().Format("2006-01-02"),
+		createdAt: rev.CreatedAt.UTC(),
+		url:       reportURL + rp.ID + "/" + strconv.Itoa(rev.Version),
+	}
+	if rr.title == "" {
+		rr.title = "AIMe report " + rp.ID
+	}
+
+	var ans interface{}
+	json.Unmarshal(rev.Answers, &ans)
+	sections, _ := ans.(map[string]interface{})
+
+	for _, ch := range db.questions.Children {
+		if !ch.shown(sections) {
+			continue
+		}
+		if n := buildRenderNode(ch, sections[ch.ID]); n != nil {
+			rr.sections = append(rr.sections, n)
+		}
+	}
+
+	return rr
+}
+
+// Markdown
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"`", "\\`",
+	"*", `\*`,
+	"_", `\_`,
+	"[", `\[`,
+	"]", `\]`,
+	"<", `\<`,
+	">", `\>`,
+	"#", `\#`,
+	"|", `\|`,
+)
+
+func markdownText(s string) string {
+	var lines []string
+	for _, l := range strings.Split(strings.TrimSpace(s), "\n") {
+		lines = append(lines, markdownEscaper.Replace(strings.TrimRight(l, " \r\t")))
+	}
+	// Keep single line breaks
+	return strings.Join(lines, "  \n")
+}
+
+func (n *renderNode) markdown(b *strings.Builder, level int) {
+	if n.children != nil {
+		if level <= 6 {
+			b.WriteString(strings.Repeat("#", level) + " " + markdownText(n.title) + "\n\n")
+		} else {
+			b.WriteString("**" + markdownText(n.title) + "**\n\n")
+		}
+		if n.question != "" {
+			b.WriteString("*" + markdownText(n.question) + "*\n\n")
+		}
+		for _, ch := range n.children {
+			ch.markdown(b, level+1)
+		}
+		return
+	}
+
+	b.WriteString("**" + markdownText(n.title) + "**  \n")
+	if n.question != "" {
+		b.WriteString("*" + markdownText(n.question) + "*\n")
+	}
+	b.WriteString("\n")
+
+	if len(n.values) == 1 && !n.documents {
+		b.WriteString(markdownText(n.values[0]) + "\n\n")
+		return
+	}
+	for _, v := range n.values {
+		if n.documents {
+			b.WriteString("- [" + markdownText(v) + "](" + apiURL + "documents/" + v + ")\n")
+		} else {
+			b.WriteString("- " + markdownText(v) + "\n")
+		}
+	}
+	b.WriteString("\n")
+}
+
+func (rr renderedReport) markdown() []byte {
+	b := &strings.Builder{}
+	b.WriteString("# " + markdownText(rr.title) + "\n\n")
+	b.WriteString("AIMe report " + rr.id + ", version " + strconv.Itoa(rr.version) + " of " + rr.date + "  \n")
+	b.WriteString("<" + rr.url + ">\n\n")
+	for _, n := range rr.sections {
+		n.markdown(b, 2)
+	}
+	return []byte(strings.TrimRight(b.String(), "\n") + "\n")
+}
+
+// HTML
+
+const renderStyle = `body{font-family:sans-serif;max-width:50em;margin:2em auto;padding:0 1em;line-height:1.5;color:#222}
+h1{margin-bottom:.2em}.meta{color:#666;margin-top:0}.field{margin:0 0 1em}
+.label{font-weight:bold}.question{color:#666;font-style:italic;font-size:.9em}.value{white-space:pre-wrap}
+section section{margin-left:1em}`
+
+func htmlText(s string) string {
+	return html.EscapeString(strings.TrimSpace(s))
+}
+
+func (n *renderNode) html(b *strings.Builder, level int) {
+	if n.children != nil {
+		h := strconv.Itoa(level)
+		if level > 6 {
+			h = "6"
+		}
+		b.WriteString("<section>\n<h" + h + ">" + htmlText(n.title) + "</h" + h + ">\n")
+		if n.question != "" {
+			b.WriteString("<p class=\"question\">" + htmlText(n.question) + "</p>\n")
+		}
+		for _, ch := range n.children {
+			ch.html(b, level+1)
+		}
+		b.WriteString("</section>\n")
+		return
+	}
+
+	b.WriteString("<div class=\"field\">\n<div class=\"label\">" + htmlText(n.title) + "</div>\n")
+	if n.question != "" {
+		b.WriteString("<div class=\"question\">" + htmlText(n.question) + "</div>\n")
+	}
+	if len(n.values) == 1 && !n.documents {
+		b.WriteString("<div class=\"value\">" + htmlText(n.values[0]) + "</div>\n")
+	} else {
+		b.WriteString("<ul class=\"value\">\n")
+		for _, v := range n.values {
+			if n.documents {
+				b.WriteString("<li><a href=\"" + html.EscapeString(apiURL+"documents/"+v) + "\">" + htmlText(v) + "</a></li>\n")
+			} else {
+				b.WriteString("<li>" + htmlText(v) + "</li>\n")
+			}
+		}
+		b.WriteString("</ul>\n")
+	}
+	b.WriteString("</div>\n")
+}
+
+func (rr renderedReport) html() []byte {
+	b := &strings.Builder{}
+	b.WriteString("<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n<meta charset=\"utf-8\">\n")
+	b.WriteString("<title>" + htmlText(rr.title) + "</title>\n")
+	b.WriteString("<link rel=\"canonical\" href=\"" + rr.url + "\">\n")
+	b.WriteString("<style>\n" + renderStyle + "\n</style>\n</head>\n<body>\n")
+	b.WriteString("<header>\n<h1>" + htmlText(rr.title) + "</h1>\n")
+	b.WriteString("<p class=\"meta\">AIMe report " + htmlText(rr.id) + ", version " + strconv.Itoa(rr.version) + " of " + rr.date +
+		"<br><a href=\"" + rr.url + "\">" + rr.url + "</a></p>\n</header>\n")
+	for _, n := range rr.sections {
+		n.html(b, 2)
+	}
+	b.WriteString("</body>\n</html>\n")
+	return []byte(b.String())
+}
+
+// RenderMarkdown renders the answered questions of a revision as Markdown.
+func (db *DB) RenderMarkdown(rp *Report, rev *Revision) []byte {
+	return db.renderReport(rp, rev).markdown()
+}
+
+// RenderHTML renders the answered questions of a revision as standalone HTML
+// document.
+func (db *DB) RenderHTML(rp *Report, rev *Revision) []byte {
+	return db.renderReport(rp, rev).html()
+}
+
+// reportAttachment is the revision rendered as HTML for attaching to mails.
+func (db *DB) reportAttachment(rp *Report, rev *Revision) mailAttachment {
+	return mailAttachment{
+		name:    "aime-report-" + rp.ID + "-v" + strconv.Itoa(rev.Version) + ".html",
+		content: db.RenderHTML(rp, rev),
+	}
+}
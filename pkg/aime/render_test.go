@@ -0,0 +1,84 @@
+package aime
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDB_RenderMarkdown(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	rp := db.CreateReport("", true)
+	jn := "{\"MD\":{\"1\":\"Heart_failure\",\"5\":[{\"custom\":false,\"value\":\"cardiology\"},{\"custom\":true,\"value\":\"ecg\"}],\"6\":[{\"1\":\"Jane Doe\"}]}," +
+		"\"P\":{\"2\":{\"1\":false,\"2\":\"Hidden marker\"},\"3\":{\"1\":{\"custom\":false,\"value\":\"cf\"},\"2\":\"Hidden category\"}}}"
+	rev := db.CreateRevision(rp.ID, "", json.RawMessage(jn), rp.Token, true)
+
+	md := string(db.RenderMarkdown(rp, rev))
+	if !strings.HasPrefix(md, "# Heart\\_failure\n") || !strings.Contains(md, "<"+reportURL+rp.ID+"/1>") {
+		t.Fatal(md)
+	}
+	if !strings.Contains(md, "## Metadata\n") || !strings.Contains(md, "- cardiology\n- ecg\n") {
+		t.Fatal(md)
+	}
+	// Option labels instead of keys
+	if !strings.Contains(md, "Classification") {
+		t.Fatal(md)
+	}
+	// Boolean answers and questions whose condition is not met
+	if !strings.Contains(md, "\nNo\n") || strings.Contains(md, "Hidden") {
+		t.Fatal(md)
+	}
+	// Unanswered sections are left out
+	if strings.Contains(md, "## Dataset") {
+		t.Fatal(md)
+	}
+}
+
+func TestServer_Render(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	rp := db.CreateReport("", true)
+	db.CreateRevision(rp.ID, "", json.RawMessage("{\"MD\":{\"1\":\"<b>Heart failure</b>\"}}"), rp.Token, true)
+
+	srv := Server{DB: db}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, _ := http.Get(ts.URL + "/report/" + rp.ID + "/1.html")
+	respBytes, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Fatal(resp.StatusCode)
+	}
+	if !strings.Contains(string(respBytes), "<h1>&lt;b&gt;Heart failure&lt;/b&gt;</h1>") {
+		t.Fatal(string(respBytes))
+	}
+
+	resp, _ = http.Get(ts.URL + "/report/" + rp.ID + "/1.md")
+	if resp.StatusCode != 200 || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/markdown") {
+		t.Fatal(resp.StatusCode)
+	}
+
+	resp, _ = http.Get(ts.URL + "/report/" + rp.ID + "/2.md")
+	if resp.StatusCode != 404 {
+		t.Fatal(resp.StatusCode)
+	}
+}
+
+func TestNewMail(t *testing.T) {
+	m := newMail("test@test.de", "Subject", "Text", mailAttachment{"report.html", []byte("<p>Report</p>")})
+
+	buf := &bytes.Buffer{}
+	m.WriteTo(buf)
+	if !strings.Contains(buf.String(), "filename=\"report.html\"") {
+		t.Fatal(buf.String())
+	}
+}
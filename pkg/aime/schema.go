@@ -0,0 +1,251 @@
+package aime
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Stored reports, revisions and issues carry the version of their schema in
+// the field "schema", files without it have version 1. Older files are
+// migrated step by step when they are read, `aime migrate` rewrites them.
+// Migrations that depend on when they run only take effect in `aime migrate`,
+// the server does not start while documents are behind (see Outdated).
+// Changing a stored type needs a new migration of its kind below.
+
+// Kinds of stored documents
+const (
+	SchemaReport   = "report"
+	SchemaRevision = "revision"
+	SchemaIssue    = "issue"
+)
+
+var ErrUnknownSchema = errors.New("schema is newer than supported")
+
+type Migration struct {
+	Kind string
+	// To is the version the migration produces from the previous one
+	To          int
+	Description string
+	// Migrate changes doc in place. at is when `aime migrate` started, it is
+	// zero when a document is read.
+	Migrate func(doc map[string]interface{}, at time.Time) error
+}
+
+// Migrations are ordered by kind and version.
+var Migrations = []Migration{
+	{SchemaIssue, 2, "record when issues were published before the migration", migrateIssuePublishedAt},
+}
+
+// migrateIssuePublishedAt sets publishedAt of issues whose publication lies
+// before the migration, so they are not published again. Until then, read
+// issues would be published once more, so the server waits for the migration.
+func migrateIssuePublishedAt(doc map[string]interface{}, at time.Time) error {
+	if at.IsZero() {
+		return nil
+	}
+
+	iss := Issue{}
+	docBytes, _ := json.Marshal(doc)
+	if err := json.Unmarshal(docBytes, (*UnsafeIssue)(&iss)); err != nil {
+		return err
+	}
+	if _, ok := doc["publishedAt"]; ok || !iss.Verified || iss.Deleted || iss.VerifiedAt.IsZero() {
+		return nil
+	}
+	if published := iss.publishedAt(); published.Before(at) {
+		doc["publishedAt"] = published
+	}
+	return nil
+}
+
+// SchemaVersion returns the current version of a kind.
+func SchemaVersion(kind string) int {
+	v := 1
+	for _, m := range Migrations {
+		if m.Kind == kind && m.To > v {
+			v = m.To
+		}
+	}
+	return v
+}
+
+// hasSchema reports whether a JSON object has the field "schema".
+func hasSchema(b []byte) bool {
+	doc := map[string]json.RawMessage{}
+	if json.Unmarshal(b, &doc) != nil {
+		return false
+	}
+	_, ok := doc["schema"]
+	return ok
+}
+
+// stampSchema adds the current version to a JSON object, keeping the order of
+// the fields.
+func stampSchema(kind string, b []byte) []byte {
+	stamp := `{"schema":` + strconv.Itoa(SchemaVersion(kind))
+	if bytes.Equal(b, []byte("{}")) {
+		return []byte(stamp + "}")
+	}
+	return append([]byte(stamp+","), b[1:]...)
+}
+
+// marshalDocument encodes a document of a kind for storing.
+func marshalDocument(kind string, v interface{}) []byte {
+	b, _ := json.Marshal(v)
+	return stampSchema(kind, b)
+}
+
+// migrateDocument brings a stored document to the current version. It returns
+// the document unchanged if it is current, and the applied migrations. at is
+// passed on to the migrations.
+func migrateDocument(kind string, b []byte, at time.Time) ([]byte, []Migration, error) {
+	current := SchemaVersion(kind)
+	if bytes.HasPrefix(b, []byte(`{"schema":`+strconv.Itoa(current)+`,`)) {
+		return b, nil, nil
+	}
+	if current == 1 && !hasSchema(b) {
+		return b, nil, nil
+	}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	doc := map[string]interface{}{}
+	if err := d.Decode(&doc); err != nil {
+		return nil, nil, err
+	}
+
+	version := 1
+	if s, ok := doc["schema"].(json.Number); ok {
+		n, err := s.Int64()
+		if err != nil {
+			return nil, nil, err
+		}
+		version = int(n)
+	}
+	if version > current {
+		return nil, nil, fmt.Errorf("%w: %s version %d", ErrUnknownSchema, kind, version)
+	}
+	if version == current {
+		return b, nil, nil
+	}
+
+	var applied []Migration
+	for _, m := range Migrations {
+		if m.Kind != kind || m.To <= version {
+			continue
+		}
+		if err := m.Migrate(doc, at); err != nil {
+			return nil, applied, fmt.Errorf("migrating %s to version %d: %w", kind, m.To, err)
+		}
+		applied = append(applied, m)
+	}
+
+	delete(doc, "schema")
+	return marshalDocument(kind, doc), applied, nil
+}
+
+// unmarshalDocument decodes a stored document after migrating it.
+func unmarshalDocument(kind string, b []byte, v interface{}) error {
+	b, _, err := migrateDocument(kind, b, time.Time{})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// MigratedFile is a stored document that is behind the current schema or has
+// no version yet.
+type MigratedFile struct {
+	// Path is relative to the DB directory
+	Path       string
+	Kind       string
+	Migrations []Migration
+	Err        error
+}
+
+func (f MigratedFile) String() string {
+	if f.Err != nil {
+		return f.Path + ": " + f.Err.Error()
+	}
+	if len(f.Migrations) == 0 {
+		return fmt.Sprintf("%s: add %s version %d", f.Path, f.Kind, SchemaVersion(f.Kind))
+	}
+	s := f.Path + ":"
+	for _, m := range f.Migrations {
+		s += fmt.Sprintf(" %s %d (%s)", m.Kind, m.To, m.Description)
+	}
+	return s
+}
+
+// Migrate brings all stored documents to the current schema and returns those
+// that were behind. With dryRun, no file is changed.
+func (db *DB) Migrate(dryRun bool) []MigratedFile {
+	var files []MigratedFile
+	at := time.Now()
+
+	migrate := func(kind string, p string) {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return
+		}
+		rel, _ := filepath.Rel(db.Dir, p)
+		mb, applied, err := migrateDocument(kind, b, at)
+		if err == nil && bytes.Equal(mb, b) {
+			// Current documents from before versioning only get their version
+			if hasSchema(b) || !bytes.HasPrefix(b, []byte("{")) {
+				return
+			}
+			mb = stampSchema(kind, b)
+		}
+		f := MigratedFile{Path: filepath.ToSlash(rel), Kind: kind, Migrations: applied, Err: err}
+
+		// The migrated file replaces the old one at once
+		if err == nil && !dryRun {
+			err = ioutil.WriteFile(p+".tmp", mb, os.ModePerm)
+			if err == nil {
+				err = os.Rename(p+".tmp", p)
+			}
+			f.Err = err
+		}
+		files = append(files, f)
+	}
+
+	reports, _ := ioutil.ReadDir(filepath.Join(db.Dir, "reports"))
+	for _, fi := range reports {
+		if !fi.IsDir() {
+			continue
+		}
+		id := fi.Name()
+		migrate(SchemaReport, filepath.Join(db.reportPath(id), "report.json"))
+
+		revs, _ := filepath.Glob(filepath.Join(db.revisionPath(id), "*.json"))
+		for _, p := range revs {
+			migrate(SchemaRevision, p)
+		}
+		comments, _ := filepath.Glob(filepath.Join(db.commentPath(id), "*.json"))
+		for _, p := range comments {
+			migrate(SchemaIssue, p)
+		}
+	}
+
+	return files
+}
+
+// Outdated returns the stored documents that need a migration or cannot be
+// migrated. Documents that only miss their version are up to date.
+func (db *DB) Outdated() []MigratedFile {
+	var files []MigratedFile
+	for _, f := range db.Migrate(true) {
+		if len(f.Migrations) > 0 || f.Err != nil {
+			files = append(files, f)
+		}
+	}
+	return files
+}
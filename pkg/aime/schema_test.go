@@ -0,0 +1,170 @@
+package aime
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMigrations(t *testing.T) {
+	// Versions of a kind follow each other without gaps
+	next := map[string]int{}
+	for _, m := range Migrations {
+		if next[m.Kind] == 0 {
+			next[m.Kind] = 2
+		}
+		if m.To != next[m.Kind] || m.Description == "" || m.Migrate == nil {
+			t.Fatal(m.Kind, m.To)
+		}
+		next[m.Kind]++
+	}
+}
+
+func TestMigrateIssuePublishedAt(t *testing.T) {
+	now := time.Now()
+	long := time.Now().Add(-2 * pendingTime).UTC().Truncate(time.Second)
+	recent := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+
+	cases := []struct {
+		issue     UnsafeIssue
+		published time.Time
+	}{
+		// The pending time ran out
+		{UnsafeIssue{Verified: true, VerifiedAt: long}, long.Add(pendingTime)},
+		// The owner answered
+		{UnsafeIssue{Verified: true, VerifiedAt: recent, Answers: []Answer{{ID: 1, CreatedAt: recent.Add(time.Minute), Owner: true}}}, recent.Add(time.Minute)},
+		// Still pending
+		{UnsafeIssue{Verified: true, VerifiedAt: recent}, time.Time{}},
+		// Not confirmed or deleted
+		{UnsafeIssue{VerifiedAt: long}, time.Time{}},
+		{UnsafeIssue{Verified: true, VerifiedAt: long, Deleted: true}, time.Time{}},
+	}
+
+	for i, c := range cases {
+		issBytes, _ := json.Marshal(c.issue)
+		doc := map[string]interface{}{}
+		json.Unmarshal(issBytes, &doc)
+		delete(doc, "publishedAt")
+
+		// Reading leaves the issue alone
+		if err := migrateIssuePublishedAt(doc, time.Time{}); err != nil || doc["publishedAt"] != nil {
+			t.Fatal(i, err, doc)
+		}
+
+		if err := migrateIssuePublishedAt(doc, now); err != nil {
+			t.Fatal(i, err)
+		}
+		at, _ := doc["publishedAt"].(time.Time)
+		if !at.Equal(c.published) {
+			t.Fatal(i, at, c.published)
+		}
+	}
+
+	// Publications after the migration are left to the server
+	issBytes, _ := json.Marshal(UnsafeIssue{Verified: true, VerifiedAt: long})
+	doc := map[string]interface{}{}
+	json.Unmarshal(issBytes, &doc)
+	delete(doc, "publishedAt")
+	migrateIssuePublishedAt(doc, long.Add(pendingTime-time.Minute))
+	if doc["publishedAt"] != nil {
+		t.Fatal(doc)
+	}
+
+	// Issues stored with publishedAt are left alone
+	doc = map[string]interface{}{"verified": true, "verifiedAt": long, "publishedAt": "0001-01-01T00:00:00Z"}
+	migrateIssuePublishedAt(doc, now)
+	if doc["publishedAt"] != "0001-01-01T00:00:00Z" {
+		t.Fatal(doc)
+	}
+}
+
+func TestMigrateDocument(t *testing.T) {
+	if b := stampSchema(SchemaReport, []byte("{}")); string(b) != `{"schema":1}` {
+		t.Fatal(string(b))
+	}
+
+	// Only the field counts, not the word in a value
+	if !hasSchema([]byte(`{"id":1,"schema":1}`)) || hasSchema([]byte(`{"id":1,"content":"\"schema\""}`)) {
+		t.Fatal("hasSchema")
+	}
+
+	cur := marshalDocument(SchemaIssue, UnsafeIssue{ID: 1})
+	if b, ms, err := migrateDocument(SchemaIssue, cur, time.Time{}); err != nil || len(ms) != 0 || !bytes.Equal(b, cur) {
+		t.Fatal(string(b), ms, err)
+	}
+
+	if _, _, err := migrateDocument(SchemaIssue, []byte(`{"schema":99,"id":1}`), time.Time{}); !errors.Is(err, ErrUnknownSchema) {
+		t.Fatal(err)
+	}
+
+	b, ms, err := migrateDocument(SchemaIssue, []byte(`{"id":1,"reportId":"abc","verified":false}`), time.Time{})
+	if err != nil || len(ms) != 1 || !bytes.HasPrefix(b, []byte(`{"schema":2,`)) {
+		t.Fatal(string(b), ms, err)
+	}
+}
+
+func TestDB_Migrate(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	rp := db.CreateReport("", true)
+	db.CreateRevision(rp.ID, "", json.RawMessage("{}"), rp.Token, true)
+	iss := db.CreateIssue(rp.ID, "Jane", "jane@test.de", []string{"MD"}, "Question", 0)
+
+	// New documents carry the current version
+	for _, p := range []string{filepath.Join(db.reportPath(rp.ID), "report.json"), db.revisionFilePath(rp.ID, 1), db.commentFilePath(rp.ID, iss.ID)} {
+		if b, _ := ioutil.ReadFile(p); !bytes.HasPrefix(b, []byte(`{"schema":`)) {
+			t.Fatal(string(b))
+		}
+	}
+	if ms := db.Migrate(false); len(ms) != 0 {
+		t.Fatal(ms)
+	}
+
+	// Documents from before versioning
+	verifiedAt := time.Now().Add(-2 * pendingTime).UTC().Truncate(time.Second)
+	legacy := `{"id":1,"reportId":"` + rp.ID + `","revisionId":1,"name":"Jane","createdAt":"` + verifiedAt.Format(time.RFC3339) +
+		`","verifiedAt":"` + verifiedAt.Format(time.RFC3339) + `","type":0,"field":["MD"],"content":"Question","answers":null,` +
+		`"verified":true,"deleted":false,"email":"jane@test.de","token":"` + iss.Token + `"}`
+	ioutil.WriteFile(db.commentFilePath(rp.ID, iss.ID), []byte(legacy), os.ModePerm)
+	rpBytes, _ := json.Marshal(UnsafeReport(*db.GetReport(rp.ID)))
+	ioutil.WriteFile(filepath.Join(db.reportPath(rp.ID), "report.json"), rpBytes, os.ModePerm)
+
+	// Reading migrates without writing, the publication is left to aime migrate
+	if miss := db.GetIssue(rp.ID, iss.ID); miss == nil || !miss.PublishedAt.IsZero() {
+		t.Fatal(miss)
+	}
+
+	if ms := db.Outdated(); len(ms) != 1 || ms[0].Kind != SchemaIssue {
+		t.Fatal(ms)
+	}
+	ms := db.Migrate(true)
+	if len(ms) != 2 || ms[0].Kind != SchemaReport || len(ms[0].Migrations) != 0 || ms[1].Kind != SchemaIssue || len(ms[1].Migrations) != 1 {
+		t.Fatal(ms)
+	}
+	if b, _ := ioutil.ReadFile(db.commentFilePath(rp.ID, iss.ID)); string(b) != legacy {
+		t.Fatal("dry run changed the file")
+	}
+
+	if ms := db.Migrate(false); len(ms) != 2 || ms[0].Err != nil || ms[1].Err != nil {
+		t.Fatal(ms)
+	}
+	if ms := db.Migrate(false); len(ms) != 0 || len(db.Outdated()) != 0 {
+		t.Fatal(ms)
+	}
+	if b, _ := ioutil.ReadFile(db.commentFilePath(rp.ID, iss.ID)); !bytes.HasPrefix(b, []byte(`{"schema":2,`)) {
+		t.Fatal(string(b))
+	}
+	if miss := db.GetIssue(rp.ID, iss.ID); miss.Token != iss.Token || !miss.PublishedAt.Equal(verifiedAt.Add(pendingTime)) {
+		t.Fatal(miss)
+	}
+	if db.PublishIssues() != 0 {
+		t.Fatal("migrated issue published again")
+	}
+}
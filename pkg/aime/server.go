@@ -1,27 +1,44 @@
 package aime
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
+	"gopkg.in/yaml.v2"
+	"io"
 	"io/ioutil"
 	"net/http"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
 type Server struct {
-	Port int
-	DB   *DB
-	ES   *emailSender
+	Port       int
+	DB         *DB
+	ES         *emailSender
+	AdminToken string
+	// ReadOnly rejects all requests that would change the DB, e.g. on a mirror
+	ReadOnly bool
 
 	srv *http.Server
 }
 
-func (s *Server) serveRevision(w http.ResponseWriter, id string, ver int) {
+// revisionTypes are the media types a revision is served as.
+var revisionTypes = []string{"application/json", "application/ld+json"}
+
+func (s *Server) serveRevision(w http.ResponseWriter, r *http.Request, id string, ver int) {
+	w.Header().Set("Vary", "Accept")
+
+	// Clients accepting none of the types get the default one
+	mt := negotiate(r.Header.Get("Accept"), revisionTypes)
+	if mt == "" {
+		mt = revisionTypes[0]
+	}
+
 	rp := s.DB.GetReport(id)
 	if rp == nil {
 		w.WriteHeader(404)
@@ -33,6 +50,12 @@ func (s *Server) serveRevision(w http.ResponseWriter, id string, ver int) {
 		return
 	}
 
+	if mt == "application/ld+json" {
+		w.Header().Set("Content-Type", "application/ld+json")
+		w.Write(s.DB.jsonLD(rp, rev))
+		return
+	}
+
 	resp := GetRevisionResponse{
 		Answers:  rev.Answers,
 		Revision: rev.Version,
@@ -62,7 +85,141 @@ func (s *Server) serveRevision(w http.ResponseWriter, id string, ver int) {
 	w.Write(respBytes)
 }
 
-func (s *Server) Start() {
+func (s *Server) serveCitation(w http.ResponseWriter, r *http.Request, id string, ver int, pinned bool) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "bibtex"
+	}
+	cf, ok := citationFormats[format]
+	if !ok {
+		w.WriteHeader(400)
+		return
+	}
+
+	rev := s.DB.GetRevision(id, ver)
+	if rev == nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	c := s.DB.citation(rev, pinned)
+
+	filename := "aime-" + id
+	if pinned {
+		filename += "-v" + strconv.Itoa(ver)
+	}
+	if format == "cff" {
+		filename = "CITATION"
+	}
+
+	w.Header().Set("Content-Type", cf[0]+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", "inline; filename=\""+filename+"."+cf[1]+"\"")
+	w.Write(c.format(format))
+}
+
+func (s *Server) serveFeed(w http.ResponseWriter, f feed, format string) {
+	if format == "rss" {
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		w.Write(f.rss())
+	} else {
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		w.Write(f.atom())
+	}
+}
+
+func (s *Server) isAdmin(r *http.Request) bool {
+	return s.AdminToken != "" && r.URL.Query().Get("p") == s.AdminToken
+}
+
+// visibleIssue reports whether an issue may be read with the password, which is
+// either the token of the issue or of the report.
+func visibleIssue(rp *Report, iss *Issue, pw string) bool {
+	if pw == "" {
+		return iss.Verified && !iss.Pending()
+	}
+	if !iss.Verified && pw != iss.Token {
+		return false
+	}
+	return !iss.Pending() || pw == iss.Token || pw == rp.Token
+}
+
+func (s *Server) result(r *Revision) Result {
+	st := s.DB.Settings.withDefaults()
+
+	title := ExtractField(s.DB.questions, r.Answers, splitField(st.TitleField))
+
+	authors := ExtractFields(s.DB.questions, r.Answers, splitField(st.AuthorsField))
+
+	cc := s.DB.GetReportIssues(r.ReportID, false)
+	comments := 0
+	for range cc {
+		comments++
+	}
+
+	res := Result{
+		ID:        r.ReportID,
+		Title:     title,
+		Authors:   authors,
+		UpdatedAt: r.CreatedAt,
+		Revisions: r.Version,
+		Issues:    comments,
+	}
+
+	if rp := s.DB.GetReport(r.ReportID); rp != nil {
+		res.CreatedAt = rp.CreatedAt
+	}
+
+	return res
+}
+
+// listItems returns the reports with their latest revision, skipping those
+// that are not public (anymore).
+func (s *Server) listItems(reports []string) []listItem {
+	items := []listItem{}
+	for _, k := range reports {
+		rp := s.DB.GetReport(k)
+		if rp == nil {
+			continue
+		}
+		rev := s.DB.GetRevision(k, rp.Revisions)
+		if rev == nil || !rev.Public {
+			continue
+		}
+		items = append(items, listItem{report: rp, revision: rev})
+	}
+	return items
+}
+
+// results returns the count, the results of the requested page and the cursor
+// of the next page for the latest revisions of the given reports.
+func (s *Server) results(reports []string, l *listing) (int, []Result, string) {
+	count, page, next := l.apply(s.listItems(reports), s.DB.GetRevision)
+
+	results := []Result{}
+	for _, it := range page {
+		results = append(results, s.result(it.revision))
+	}
+
+	return count, results, next
+}
+
+func treeNodes(ns []*conceptNode) []TreeNode {
+	tns := []TreeNode{}
+	for _, n := range ns {
+		tn := TreeNode{
+			Label: n.entry.value,
+			Count: len(n.entry.reports),
+		}
+		if n.concept != nil {
+			tn.ID = n.concept.ID
+			tn.Children = treeNodes(n.children)
+		}
+		tns = append(tns, tn)
+	}
+	return tns
+}
+
+func (s *Server) Handler() http.Handler {
 	r := mux.NewRouter()
 
 	r.HandleFunc("/report/{id}/issue", func(w http.ResponseWriter, r *http.Request) {
@@ -102,6 +259,8 @@ func (s *Server) Start() {
 				s.ES.SendIssueConfirmationMail(rp, com)
 			}(*rp, *com)
 
+			s.DB.FireWebhook(EventIssueCreated, com.CreatedAt, newIssueEvent(*com))
+
 			resp := CreateIssueResponse{
 				ID:       com.ID,
 				Password: com.Token,
@@ -149,22 +308,9 @@ func (s *Server) Start() {
 		}
 
 		if r.Method == "GET" {
-			pw := r.URL.Query().Get("p")
-
-			if pw == "" {
-				if !iss.Verified || iss.Pending() {
-					w.WriteHeader(403)
-					return
-				}
-			} else {
-				if !iss.Verified && pw != iss.Token {
-					w.WriteHeader(403)
-					return
-				}
-				if iss.Pending() && pw != iss.Token && pw != rp.Token {
-					w.WriteHeader(403)
-					return
-				}
+			if !visibleIssue(rp, iss, r.URL.Query().Get("p")) {
+				w.WriteHeader(403)
+				return
 			}
 
 			respStruct := struct {
@@ -229,6 +375,13 @@ func (s *Server) Start() {
 				s.ES.SendAnswerMail(rp, com, a)
 			}(*rp, *iss, *a)
 
+			s.DB.FireWebhook(EventAnswerCreated, a.CreatedAt, newAnswerEvent(*iss, *a))
+
+			// The first answer of the owner ends the pending time
+			if pub := s.DB.PublishIssue(id, iid); pub != nil {
+				s.DB.FireWebhook(EventIssuePublished, pub.PublishedAt, newIssueEvent(*pub))
+			}
+
 			resp := CreateAnswerResponse{
 				ID: a.ID,
 			}
@@ -256,6 +409,8 @@ func (s *Server) Start() {
 					s.ES.SendIssueMail(rp, com)
 				}(*rp, *iss)
 
+				s.DB.FireWebhook(EventIssueConfirmed, time.Now(), newIssueEvent(*iss))
+
 				return
 			}
 
@@ -263,6 +418,193 @@ func (s *Server) Start() {
 		}
 	}).Methods("GET", "POST", "PUT")
 
+	r.HandleFunc("/report/{id}/issue/{issue}/events", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		iid, err := strconv.Atoi(vars["issue"])
+		if err != nil {
+			w.WriteHeader(404)
+			return
+		}
+
+		if r.Method == "GET" {
+			rp := s.DB.GetReport(vars["id"])
+			if rp == nil {
+				w.WriteHeader(404)
+				return
+			}
+
+			iss := s.DB.GetIssue(rp.ID, iid)
+			if iss == nil || iss.Deleted {
+				w.WriteHeader(404)
+				return
+			}
+
+			if !visibleIssue(rp, iss, r.URL.Query().Get("p")) {
+				w.WriteHeader(403)
+				return
+			}
+
+			s.serveEvents(w, issueTopic(rp.ID, iss.ID))
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/report/{id}/events", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		if r.Method == "GET" {
+			rp := s.DB.GetReport(vars["id"])
+			if rp == nil {
+				w.WriteHeader(404)
+				return
+			}
+
+			s.serveEvents(w, reportTopic(rp.ID))
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/report/{id}/feed.{format:atom|rss}", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		if r.Method == "GET" {
+			f := s.reportFeed(vars["id"], vars["format"])
+			if f == nil {
+				w.WriteHeader(404)
+				return
+			}
+
+			s.serveFeed(w, *f, vars["format"])
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/report/{id}/archive", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			rp := s.DB.GetReport(mux.Vars(r)["id"])
+			if rp == nil {
+				w.WriteHeader(404)
+				return
+			}
+
+			admin := s.isAdmin(r)
+			if !admin && r.URL.Query().Get("p") != rp.Token {
+				w.WriteHeader(403)
+				return
+			}
+
+			var buf bytes.Buffer
+			if err := s.DB.ExportArchive(&buf, rp.ID, admin); err != nil {
+				w.WriteHeader(500)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/zip")
+			w.Header().Set("Content-Disposition", "attachment; filename=\"aime-report-"+rp.ID+".zip\"")
+			w.Write(buf.Bytes())
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/report/{id}/similar", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		id := vars["id"]
+		if !s.DB.ExistsReport(id) {
+			w.WriteHeader(404)
+			return
+		}
+
+		if r.Method == "GET" {
+			limit, _ := strconv.Atoi(r.URL.Query().Get("l"))
+			if limit <= 0 {
+				limit = 10
+			}
+			if limit > 100 {
+				limit = 100
+			}
+
+			resp := SimilarResponse{
+				Results: []Result{},
+			}
+
+			for _, sr := range s.DB.SimilarReports(id, limit) {
+				rev := s.DB.LatestRevision(sr.id)
+				if rev == nil {
+					continue
+				}
+				res := s.result(rev)
+				res.Score = sr.score
+				resp.Results = append(resp.Results, res)
+			}
+
+			respBytes, _ := json.Marshal(resp)
+
+			w.Write(respBytes)
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/report/{id}/cite", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		if r.Method == "GET" {
+			rp := s.DB.GetReport(vars["id"])
+			if rp == nil {
+				w.WriteHeader(404)
+				return
+			}
+
+			s.serveCitation(w, r, rp.ID, rp.Revisions, false)
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/report/{id}/{version:[0-9]+}/cite", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		ver, err := strconv.Atoi(vars["version"])
+		if err != nil || ver <= 0 {
+			w.WriteHeader(404)
+			return
+		}
+
+		if r.Method == "GET" {
+			s.serveCitation(w, r, vars["id"], ver, true)
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/report/{id}/{version:[0-9]+}.{format:md|html|pdf}", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		ver, err := strconv.Atoi(vars["version"])
+		if err != nil || ver <= 0 {
+			w.WriteHeader(404)
+			return
+		}
+
+		if r.Method == "GET" {
+			rp := s.DB.GetReport(vars["id"])
+			if rp == nil {
+				w.WriteHeader(404)
+				return
+			}
+			rev := s.DB.GetRevision(rp.ID, ver)
+			if rev == nil {
+				w.WriteHeader(404)
+				return
+			}
+
+			switch vars["format"] {
+			case "md":
+				w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+				w.Write(s.DB.RenderMarkdown(rp, rev))
+			case "html":
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.Write(s.DB.RenderHTML(rp, rev))
+			case "pdf":
+				w.Header().Set("Content-Type", "application/pdf")
+				w.Header().Set("Content-Disposition", "inline; filename=\"aime-report-"+rp.ID+"-v"+strconv.Itoa(rev.Version)+".pdf\"")
+				w.Write(s.DB.RenderPDF(rp, rev))
+			}
+		}
+	}).Methods("GET")
+
 	r.HandleFunc("/report/{id}/{version}", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 
@@ -280,7 +622,7 @@ func (s *Server) Start() {
 		}
 
 		if r.Method == "GET" {
-			s.serveRevision(w, id, ver)
+			s.serveRevision(w, r, id, ver)
 			return
 		}
 	}).Methods("GET")
@@ -301,7 +643,7 @@ func (s *Server) Start() {
 				return
 			}
 
-			s.serveRevision(w, id, rp.Revisions)
+			s.serveRevision(w, r, id, rp.Revisions)
 			return
 		} else if r.Method == "PUT" {
 			reqBytes, _ := ioutil.ReadAll(r.Body)
@@ -322,9 +664,15 @@ func (s *Server) Start() {
 				return
 			}
 
-			go func(rp Report, rev Revision) {
-				s.ES.SendRevisionMail(rp, rev)
-			}(*rp, *rev)
+			go func(rp Report, rev Revision, attach bool) {
+				if attach {
+					s.ES.SendRevisionMail(rp, rev, s.DB.reportAttachment(&rp, &rev))
+				} else {
+					s.ES.SendRevisionMail(rp, rev)
+				}
+			}(*rp, *rev, req.AttachReport)
+
+			s.DB.FireWebhook(EventRevisionCreated, rev.CreatedAt, newReportEvent(*rp, *rev))
 
 			resp := CreateRevisionResponse{
 				ID:       rp.ID,
@@ -360,9 +708,15 @@ func (s *Server) Start() {
 				return
 			}
 
-			go func(rp Report) {
-				s.ES.SendReportMail(rp)
-			}(*rp)
+			go func(rp Report, rev Revision, attach bool) {
+				if attach {
+					s.ES.SendReportMail(rp, s.DB.reportAttachment(&rp, &rev))
+				} else {
+					s.ES.SendReportMail(rp)
+				}
+			}(*rp, *rev, req.AttachReport)
+
+			s.DB.FireWebhook(EventReportCreated, rp.CreatedAt, newReportEvent(*rp, *rev))
 
 			resp := CreateRevisionResponse{
 				ID:       rp.ID,
@@ -376,53 +730,266 @@ func (s *Server) Start() {
 		}
 	}).Methods("POST")
 
-	r.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "POST" {
-			fileBytes, _ := ioutil.ReadAll(r.Body)
-
-			fileName := s.DB.UploadDocument(fileBytes)
-
-			respBytes, _ := json.Marshal(UploadFileResponse{File: fileName})
-
-			w.Write(respBytes)
-		}
-	}).Methods("POST")
-
-	r.HandleFunc("/documents/{document}", func(w http.ResponseWriter, r *http.Request) {
+	r.HandleFunc("/changes", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "GET" {
-			vars := mux.Vars(r)
-			fileName := vars["document"]
-			fileBytes := s.DB.ReadDocument(fileName)
-			if fileBytes == nil {
-				w.WriteHeader(404)
-				w.Write([]byte("not found"))
+			q := r.URL.Query()
+
+			since, _ := strconv.ParseInt(q.Get("since"), 10, 64)
+			if since < 0 {
+				w.WriteHeader(400)
 				return
 			}
-			w.Header().Set("Content-Type", "application/pdf")
-			w.Write(fileBytes)
-		}
-	}).Methods("GET")
 
-	r.HandleFunc("/keywords", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "GET" {
-			keywords := s.DB.GetKeywords()
+			limit := 100
+			if l := q.Get("limit"); l != "" {
+				limit, _ = strconv.Atoi(l)
+			}
+			if limit <= 0 || limit > 1000 {
+				w.WriteHeader(400)
+				return
+			}
 
-			if r.URL.Query().Get("format") == "list" {
-				kwResp := KeywordListResponse{}
+			// Long-poll for up to wait seconds if there are no changes yet
+			var out io.Writer = w
+			if wait, _ := strconv.Atoi(q.Get("wait")); wait > 0 {
+				timeout := time.Duration(wait) * time.Second
+				if timeout > maxChangeWait {
+					timeout = maxChangeWait
+				}
 
-				for _, k := range keywords {
-					kwResp = append(kwResp, k.keyword)
+				w.Header().Set("Content-Type", "application/json")
+				st, err := openStream(w, 200)
+				if err != nil {
+					w.WriteHeader(500)
+					return
 				}
+				defer st.Close()
 
-				kwBytes, _ := json.Marshal(kwResp)
+				s.DB.WaitChanges(since, timeout, st.Done())
+				out = st
+			}
 
-				w.Write(kwBytes)
-			} else {
-				kwResp := KeywordsResponse{}
+			resp := ChangesResponse{
+				Changes: []Change{},
+				Last:    since,
+			}
+			// Last moves past the changes that are left out as well
+			for _, c := range s.DB.GetChanges(since, limit) {
+				if s.DB.publicChange(c) {
+					resp.Changes = append(resp.Changes, c)
+				}
+				resp.Last = c.Seq
+			}
+			resp.More = resp.Last < s.DB.LastChange()
+
+			respBytes, _ := json.Marshal(resp)
+
+			out.Write(respBytes)
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/graphql", func(w http.ResponseWriter, r *http.Request) {
+		req := GraphQLRequest{}
+
+		if r.Method == "GET" {
+			req.Query = r.URL.Query().Get("query")
+			req.OperationName = r.URL.Query().Get("operationName")
+			if vs := r.URL.Query().Get("variables"); vs != "" {
+				if err := json.Unmarshal([]byte(vs), &req.Variables); err != nil {
+					w.WriteHeader(400)
+					return
+				}
+			}
+		} else if r.Method == "POST" {
+			reqBytes, _ := ioutil.ReadAll(r.Body)
+
+			if err := json.Unmarshal(reqBytes, &req); err != nil {
+				w.WriteHeader(400)
+				return
+			}
+		}
+
+		resp, err := executeGraphQL(s.graphQLSchema(), req)
+
+		respBytes, _ := json.Marshal(resp)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(400)
+		}
+		w.Write(respBytes)
+	}).Methods("GET", "POST")
+
+	r.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			respBytes, _ := json.Marshal(s.DB.GetStats())
+
+			w.Write(respBytes)
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/stats/question/{path}", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		if r.Method == "GET" {
+			st := s.DB.GetQuestionStats(vars["path"])
+			if st == nil {
+				w.WriteHeader(404)
+				return
+			}
+
+			respBytes, _ := json.Marshal(st)
+
+			w.Write(respBytes)
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/export/reports.{format:jsonl|csv}", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		if r.Method == "GET" {
+			all := r.URL.Query().Get("revisions") == "all"
+
+			if vars["format"] == "csv" {
+				w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+				w.Header().Set("Content-Disposition", "attachment; filename=\"aime-reports.csv\"")
+			} else {
+				w.Header().Set("Content-Type", "application/x-ndjson")
+				w.Header().Set("Content-Disposition", "attachment; filename=\"aime-reports.jsonl\"")
+			}
+			st, err := openStream(w, 200)
+			if err != nil {
+				w.WriteHeader(500)
+				return
+			}
+			defer st.Close()
+
+			flush := func() { st.Flush() }
+			if vars["format"] == "csv" {
+				s.DB.ExportCSV(st, all, flush)
+			} else {
+				s.DB.ExportJSONL(st, all, flush)
+			}
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/feeds/{feed:reports|revisions}.{format:atom|rss}", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		if r.Method == "GET" {
+			if vars["feed"] == "reports" {
+				s.serveFeed(w, s.reportsFeed(vars["format"]), vars["format"])
+			} else {
+				s.serveFeed(w, s.revisionsFeed(vars["format"]), vars["format"])
+			}
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/oai", func(w http.ResponseWriter, r *http.Request) {
+		s.serveOAI(w, r)
+	}).Methods("GET", "POST")
+
+	r.HandleFunc("/oai/aime.xsd", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w.Header().Set("Content-Type", "application/xml")
+			w.Write([]byte(aimeSchema))
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			fileBytes, _ := ioutil.ReadAll(r.Body)
+
+			fileName := s.DB.UploadDocument(fileBytes)
+
+			respBytes, _ := json.Marshal(UploadFileResponse{File: fileName})
+
+			w.Write(respBytes)
+		}
+	}).Methods("POST")
+
+	r.HandleFunc("/documents/{document}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			vars := mux.Vars(r)
+			fileName := vars["document"]
+			fileBytes := s.DB.ReadDocument(fileName)
+			if fileBytes == nil {
+				w.WriteHeader(404)
+				w.Write([]byte("not found"))
+				return
+			}
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write(fileBytes)
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/keywords", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			keywords := s.DB.GetKeywords()
+
+			if r.URL.Query().Get("format") == "tree" {
+				kwBytes, _ := json.Marshal(KeywordTreeResponse{
+					Keywords: treeNodes(s.DB.GetIndexTree("keywords")),
+				})
+
+				w.Write(kwBytes)
+			} else if r.URL.Query().Get("format") == "list" {
+				kwResp := KeywordListResponse{}
+
+				for _, k := range keywords {
+					kwResp = append(kwResp, k.value)
+				}
+
+				kwBytes, _ := json.Marshal(kwResp)
+
+				w.Write(kwBytes)
+			} else {
+				kwResp := KeywordsResponse{}
 
 				for _, k := range keywords {
 					kwResp.Keywords = append(kwResp.Keywords, Keyword{
-						Keyword: k.keyword,
+						Keyword: k.value,
+						Count:   len(k.reports),
+					})
+				}
+
+				kwBytes, _ := json.Marshal(kwResp)
+
+				w.Write(kwBytes)
+			}
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/keywords/suggest", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+			if limit <= 0 {
+				limit = 10
+			}
+			if limit > 100 {
+				limit = 100
+			}
+
+			keywords := s.DB.SuggestKeywords(r.URL.Query().Get("prefix"), limit)
+
+			if r.URL.Query().Get("format") == "list" {
+				kwResp := KeywordListResponse{}
+
+				for _, k := range keywords {
+					kwResp = append(kwResp, k.value)
+				}
+
+				kwBytes, _ := json.Marshal(kwResp)
+
+				w.Write(kwBytes)
+			} else {
+				kwResp := KeywordsResponse{
+					Keywords: []Keyword{},
+				}
+
+				for _, k := range keywords {
+					kwResp.Keywords = append(kwResp.Keywords, Keyword{
+						Keyword: k.value,
 						Count:   len(k.reports),
 					})
 				}
@@ -444,56 +1011,215 @@ func (s *Server) Start() {
 		}
 
 		if r.Method == "GET" {
+			l, err := parseListing(r.URL.Query(), unlimited, 100)
+			if err != nil {
+				w.WriteHeader(400)
+				return
+			}
+
 			keyword := s.DB.GetKeyword(kw)
+			if keyword == nil {
+				w.WriteHeader(404)
+				return
+			}
+
+			count, results, next := s.results(keyword.reports, l)
 
 			kwResp := KeywordResponse{
-				Count:   len(keyword.reports),
-				Keyword: keyword.keyword,
+				Count:   count,
+				Keyword: keyword.value,
+				Results: results,
+				Next:    next,
 			}
 
-			for _, k := range keyword.reports {
-				r := s.DB.LatestRevision(k)
-
-				title := ExtractField(s.DB.questions, r.Answers, []string{"MD", "1"})
+			kwBytes, _ := json.Marshal(kwResp)
 
-				authors := ExtractFields(s.DB.questions, r.Answers, []string{"MD", "6", "*", "1"})
+			w.Write(kwBytes)
+		}
+	}).Methods("GET")
 
-				cc := s.DB.GetReportIssues(r.ReportID, false)
-				comments := 0
-				for range cc {
-					comments++
-				}
+	r.HandleFunc("/categories", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			ctResp := CategoriesResponse{
+				Categories: []Category{},
+			}
 
-				kwResp.Results = append(kwResp.Results, Result{
-					ID:        r.ReportID,
-					Title:     title,
-					Authors:   authors,
-					UpdatedAt: r.CreatedAt,
-					Revisions: r.Version,
-					Issues:    comments,
+			for _, c := range s.DB.GetCategories() {
+				ctResp.Categories = append(ctResp.Categories, Category{
+					Category: c.value,
+					Count:    len(c.reports),
 				})
 			}
 
-			kwBytes, _ := json.Marshal(kwResp)
+			ctBytes, _ := json.Marshal(ctResp)
 
-			w.Write(kwBytes)
+			w.Write(ctBytes)
 		}
 	}).Methods("GET")
 
-	r.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
+	r.HandleFunc("/categories/{category}", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		ct := vars["category"]
+		if ct == "" {
+			w.WriteHeader(404)
+			return
+		}
+
 		if r.Method == "GET" {
-			offset, _ := strconv.Atoi(r.URL.Query().Get("o"))
-			limit, _ := strconv.Atoi(r.URL.Query().Get("l"))
-			if offset < 0 {
-				offset = 0
+			l, err := parseListing(r.URL.Query(), unlimited, 100)
+			if err != nil {
+				w.WriteHeader(400)
+				return
+			}
+
+			category := s.DB.GetCategory(ct)
+			if category == nil {
+				w.WriteHeader(404)
+				return
+			}
+
+			count, results, next := s.results(category.reports, l)
+
+			ctResp := CategoryResponse{
+				Count:    count,
+				Category: category.value,
+				Results:  results,
+				Next:     next,
+			}
+
+			ctBytes, _ := json.Marshal(ctResp)
+
+			w.Write(ctBytes)
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/index/{name}", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		name := vars["name"]
+		if !s.DB.ExistsIndex(name) {
+			w.WriteHeader(404)
+			return
+		}
+
+		if r.Method == "GET" {
+			entries := s.DB.GetIndexEntries(name)
+
+			if r.URL.Query().Get("format") == "tree" {
+				ixBytes, _ := json.Marshal(IndexTreeResponse{
+					Name:    name,
+					Entries: treeNodes(s.DB.GetIndexTree(name)),
+				})
+
+				w.Write(ixBytes)
+			} else if r.URL.Query().Get("format") == "list" {
+				ixResp := IndexListResponse{}
+
+				for _, e := range entries {
+					ixResp = append(ixResp, e.value)
+				}
+
+				ixBytes, _ := json.Marshal(ixResp)
+
+				w.Write(ixBytes)
+			} else {
+				ixResp := IndexResponse{
+					Name:    name,
+					Entries: []IndexEntry{},
+				}
+
+				for _, e := range entries {
+					ixResp.Entries = append(ixResp.Entries, IndexEntry{
+						Value: e.value,
+						Count: len(e.reports),
+					})
+				}
+
+				ixBytes, _ := json.Marshal(ixResp)
+
+				w.Write(ixBytes)
 			}
-			if limit < 0 {
-				limit = 0
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/index/{name}/suggest", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		name := vars["name"]
+		if !s.DB.ExistsIndex(name) {
+			w.WriteHeader(404)
+			return
+		}
+
+		if r.Method == "GET" {
+			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+			if limit <= 0 {
+				limit = 10
 			}
 			if limit > 100 {
 				limit = 100
 			}
 
+			ixResp := IndexResponse{
+				Name:    name,
+				Entries: []IndexEntry{},
+			}
+
+			for _, e := range s.DB.SuggestIndexEntries(name, r.URL.Query().Get("prefix"), limit) {
+				ixResp.Entries = append(ixResp.Entries, IndexEntry{
+					Value: e.value,
+					Count: len(e.reports),
+				})
+			}
+
+			ixBytes, _ := json.Marshal(ixResp)
+
+			w.Write(ixBytes)
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/index/{name}/{value}", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		name := vars["name"]
+		value := vars["value"]
+
+		if r.Method == "GET" {
+			l, err := parseListing(r.URL.Query(), unlimited, 100)
+			if err != nil {
+				w.WriteHeader(400)
+				return
+			}
+
+			entry := s.DB.GetIndexEntry(name, value)
+			if entry == nil {
+				w.WriteHeader(404)
+				return
+			}
+
+			count, results, next := s.results(entry.reports, l)
+
+			ixBytes, _ := json.Marshal(IndexEntryResponse{
+				Count:   count,
+				Results: results,
+				Name:    name,
+				Value:   entry.value,
+				Next:    next,
+			})
+
+			w.Write(ixBytes)
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			l, err := parseListing(r.URL.Query(), 0, 100)
+			if err != nil {
+				w.WriteHeader(400)
+				return
+			}
+
 			originalQuery := r.URL.Query().Get("q")
 			originalCategory := r.URL.Query().Get("c")
 			originalKeyword := r.URL.Query().Get("k")
@@ -526,6 +1252,9 @@ func (s *Server) Start() {
 						for _, k := range kws {
 							rps2 = map[string]bool{}
 							kw := s.DB.GetKeyword(k)
+							if kw == nil {
+								return
+							}
 							for _, repID := range kw.reports {
 								if rps1 == nil || rps1[repID] {
 									rps2[repID] = true
@@ -545,8 +1274,12 @@ func (s *Server) Start() {
 
 			query := strings.ToLower(originalQuery)
 
-			revisions := []*Revision{}
+			items := []listItem{}
 			for r := range rc {
+				if r == nil || !r.Public {
+					continue
+				}
+
 				found := false
 				if query == "" {
 					found = true
@@ -565,52 +1298,390 @@ func (s *Server) Start() {
 				}
 
 				if found {
-					revisions = append(revisions, r)
+					if rp := s.DB.GetReport(r.ReportID); rp != nil {
+						items = append(items, listItem{report: rp, revision: r})
+					}
 				}
 			}
 
-			count := len(revisions)
-
-			sort.Slice(revisions, func(i, j int) bool {
-				return revisions[i].CreatedAt.After(revisions[j].CreatedAt)
-			})
+			count, page, next := l.apply(items, s.DB.GetRevision)
 
-			i := 0
 			results := []Result{}
-			for _, r := range revisions {
-				if i >= offset && len(results) < limit {
-					title := ExtractField(s.DB.questions, r.Answers, []string{"MD", "1"})
-
-					authors := ExtractFields(s.DB.questions, r.Answers, []string{"MD", "6", "*", "1"})
-
-					cc := s.DB.GetReportIssues(r.ReportID, false)
-					comments := 0
-					for range cc {
-						comments++
-					}
-
-					results = append(results, Result{
-						ID:        r.ReportID,
-						Title:     title,
-						Authors:   authors,
-						UpdatedAt: r.CreatedAt,
-						Revisions: r.Version,
-						Issues:    comments,
-					})
-				}
-				i++
+			for _, it := range page {
+				results = append(results, s.result(it.revision))
 			}
 
 			searchBytes, _ := json.Marshal(SearchResponse{
 				Count:   count,
 				Results: results,
 				Query:   originalQuery,
+				Next:    next,
 			})
 
 			w.Write(searchBytes)
 		}
 	}).Methods("GET")
 
+	// Changes of the keyword groups rewrite keyword-groups.yaml, without its
+	// comments and in the order and format of the API
+	r.HandleFunc("/admin/keyword-groups", func(w http.ResponseWriter, r *http.Request) {
+		if !s.isAdmin(r) {
+			w.WriteHeader(403)
+			return
+		}
+
+		if r.Method == "GET" {
+			resp := KeywordGroupsResponse{
+				KeywordGroups: []KeywordGroupResponse{},
+			}
+
+			for _, g := range s.DB.GetKeywordGroups() {
+				resp.KeywordGroups = append(resp.KeywordGroups, KeywordGroupResponse{
+					ID:            g.ID,
+					Sources:       g.Sources,
+					Targets:       g.Targets,
+					CaseSensitive: g.CaseSensitive,
+				})
+			}
+
+			respBytes, _ := json.Marshal(resp)
+
+			w.Write(respBytes)
+		} else if r.Method == "POST" {
+			reqBytes, _ := ioutil.ReadAll(r.Body)
+
+			req := KeywordGroup{}
+
+			err := json.Unmarshal(reqBytes, &req)
+			if err != nil || !req.valid() {
+				w.WriteHeader(400)
+				return
+			}
+
+			id, err := s.DB.CreateKeywordGroup(req)
+			if err != nil {
+				w.WriteHeader(500)
+				return
+			}
+
+			respBytes, _ := json.Marshal(KeywordGroupResponse{
+				ID:            id,
+				Sources:       req.Sources,
+				Targets:       req.Targets,
+				CaseSensitive: req.CaseSensitive,
+			})
+
+			w.Write(respBytes)
+		}
+	}).Methods("GET", "POST")
+
+	r.HandleFunc("/admin/keyword-groups/suggestions", func(w http.ResponseWriter, r *http.Request) {
+		if !s.isAdmin(r) {
+			w.WriteHeader(403)
+			return
+		}
+
+		if r.Method == "GET" {
+			kwgBytes, err := yaml.Marshal(s.DB.SuggestKeywordGroups())
+			if err != nil {
+				w.WriteHeader(500)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/x-yaml")
+			w.Write(kwgBytes)
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/admin/keyword-groups/{group}", func(w http.ResponseWriter, r *http.Request) {
+		if !s.isAdmin(r) {
+			w.WriteHeader(403)
+			return
+		}
+
+		vars := mux.Vars(r)
+
+		id, err := strconv.Atoi(vars["group"])
+		if err != nil {
+			w.WriteHeader(404)
+			return
+		}
+
+		if r.Method == "PUT" {
+			reqBytes, _ := ioutil.ReadAll(r.Body)
+
+			req := KeywordGroup{}
+
+			err := json.Unmarshal(reqBytes, &req)
+			if err != nil || !req.valid() {
+				w.WriteHeader(400)
+				return
+			}
+
+			ok, err := s.DB.UpdateKeywordGroup(id, req)
+			if !ok {
+				w.WriteHeader(404)
+				return
+			}
+			if err != nil {
+				w.WriteHeader(500)
+				return
+			}
+
+			respBytes, _ := json.Marshal(KeywordGroupResponse{
+				ID:            id,
+				Sources:       req.Sources,
+				Targets:       req.Targets,
+				CaseSensitive: req.CaseSensitive,
+			})
+
+			w.Write(respBytes)
+		} else if r.Method == "DELETE" {
+			ok, err := s.DB.DeleteKeywordGroup(id)
+			if !ok {
+				w.WriteHeader(404)
+				return
+			}
+			if err != nil {
+				w.WriteHeader(500)
+				return
+			}
+		}
+	}).Methods("PUT", "DELETE")
+
+	r.HandleFunc("/admin/reports/import", func(w http.ResponseWriter, r *http.Request) {
+		if !s.isAdmin(r) {
+			w.WriteHeader(403)
+			return
+		}
+
+		if r.Method == "POST" {
+			archive, _ := ioutil.ReadAll(r.Body)
+
+			rp, err := s.DB.ImportArchive(archive, r.URL.Query().Get("newId") == "1")
+			if errors.Is(err, ErrInvalidArchive) {
+				w.WriteHeader(400)
+				return
+			}
+			if err == ErrReportExists {
+				w.WriteHeader(409)
+				return
+			}
+			if err != nil {
+				w.WriteHeader(500)
+				return
+			}
+
+			resp := ImportArchiveResponse{
+				ID:        rp.ID,
+				Revisions: rp.Revisions,
+			}
+
+			respBytes, _ := json.Marshal(resp)
+
+			w.Write(respBytes)
+		}
+	}).Methods("POST")
+
+	r.HandleFunc("/admin/backup", func(w http.ResponseWriter, r *http.Request) {
+		if !s.isAdmin(r) {
+			w.WriteHeader(403)
+			return
+		}
+
+		if r.Method == "GET" {
+			w.Header().Set("Content-Type", "application/zip")
+			w.Header().Set("Content-Disposition", "attachment; filename=\"aime-backup-"+time.Now().UTC().Format("20060102T150405Z")+".zip\"")
+			st, err := openStream(w, 200)
+			if err != nil {
+				w.WriteHeader(500)
+				return
+			}
+			defer st.Close()
+
+			// An interrupted backup is a truncated zip, which fails to verify
+			s.DB.Backup(st)
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/admin/webhooks", func(w http.ResponseWriter, r *http.Request) {
+		if !s.isAdmin(r) {
+			w.WriteHeader(403)
+			return
+		}
+
+		if r.Method == "GET" {
+			resp := WebhooksResponse{
+				Webhooks: []*Webhook{},
+			}
+			resp.Webhooks = append(resp.Webhooks, s.DB.GetWebhooks()...)
+
+			respBytes, _ := json.Marshal(resp)
+
+			w.Write(respBytes)
+		} else if r.Method == "POST" {
+			reqBytes, _ := ioutil.ReadAll(r.Body)
+
+			req := WebhookRequest{}
+
+			err := json.Unmarshal(reqBytes, &req)
+			if err != nil {
+				w.WriteHeader(400)
+				return
+			}
+
+			wh, err := s.DB.CreateWebhook(req.webhook())
+			if err == ErrInvalidWebhook {
+				w.WriteHeader(400)
+				return
+			}
+			if err != nil {
+				w.WriteHeader(500)
+				return
+			}
+
+			respBytes, _ := json.Marshal(wh)
+
+			w.Write(respBytes)
+		}
+	}).Methods("GET", "POST")
+
+	r.HandleFunc("/admin/webhooks/{webhook}", func(w http.ResponseWriter, r *http.Request) {
+		if !s.isAdmin(r) {
+			w.WriteHeader(403)
+			return
+		}
+
+		vars := mux.Vars(r)
+
+		if r.Method == "GET" {
+			wh := s.DB.GetWebhook(vars["webhook"])
+			if wh == nil {
+				w.WriteHeader(404)
+				return
+			}
+
+			respBytes, _ := json.Marshal(wh)
+
+			w.Write(respBytes)
+		} else if r.Method == "PUT" {
+			reqBytes, _ := ioutil.ReadAll(r.Body)
+
+			req := WebhookRequest{}
+
+			err := json.Unmarshal(reqBytes, &req)
+			if err != nil {
+				w.WriteHeader(400)
+				return
+			}
+
+			wh, err := s.DB.UpdateWebhook(vars["webhook"], req.webhook())
+			if err == ErrInvalidWebhook {
+				w.WriteHeader(400)
+				return
+			}
+			if err != nil {
+				w.WriteHeader(500)
+				return
+			}
+			if wh == nil {
+				w.WriteHeader(404)
+				return
+			}
+
+			respBytes, _ := json.Marshal(wh)
+
+			w.Write(respBytes)
+		} else if r.Method == "DELETE" {
+			if !s.DB.DeleteWebhook(vars["webhook"]) {
+				w.WriteHeader(404)
+				return
+			}
+		}
+	}).Methods("GET", "PUT", "DELETE")
+
+	r.HandleFunc("/admin/webhooks/{webhook}/deliveries", func(w http.ResponseWriter, r *http.Request) {
+		if !s.isAdmin(r) {
+			w.WriteHeader(403)
+			return
+		}
+
+		vars := mux.Vars(r)
+
+		if r.Method == "GET" {
+			if s.DB.GetWebhook(vars["webhook"]) == nil {
+				w.WriteHeader(404)
+				return
+			}
+
+			ds := s.DB.GetDeliveries(vars["webhook"])
+
+			status := r.URL.Query().Get("status")
+			if status != "" {
+				var filtered []*Delivery
+				for _, d := range ds {
+					if d.Status == status {
+						filtered = append(filtered, d)
+					}
+				}
+				ds = filtered
+			}
+
+			offset, _ := strconv.Atoi(r.URL.Query().Get("o"))
+			limit, err := strconv.Atoi(r.URL.Query().Get("l"))
+			if err != nil || limit <= 0 || limit > 100 {
+				limit = 20
+			}
+			if offset < 0 || offset > len(ds) {
+				offset = len(ds)
+			}
+			end := offset + limit
+			if end > len(ds) {
+				end = len(ds)
+			}
+
+			resp := DeliveriesResponse{
+				Count:      len(ds),
+				Deliveries: append([]*Delivery{}, ds[offset:end]...),
+			}
+
+			respBytes, _ := json.Marshal(resp)
+
+			w.Write(respBytes)
+		}
+	}).Methods("GET")
+
+	r.HandleFunc("/admin/webhooks/{webhook}/deliveries/{delivery}", func(w http.ResponseWriter, r *http.Request) {
+		if !s.isAdmin(r) {
+			w.WriteHeader(403)
+			return
+		}
+
+		vars := mux.Vars(r)
+
+		did, err := strconv.Atoi(vars["delivery"])
+		if err != nil {
+			w.WriteHeader(404)
+			return
+		}
+
+		var d *Delivery
+		if r.Method == "GET" {
+			d = s.DB.GetDelivery(vars["webhook"], did)
+		} else if r.Method == "POST" {
+			// Deliver again, e.g. after the receiver was fixed
+			d = s.DB.Redeliver(vars["webhook"], did)
+		}
+		if d == nil {
+			w.WriteHeader(404)
+			return
+		}
+
+		respBytes, _ := json.Marshal(d)
+
+		w.Write(respBytes)
+	}).Methods("GET", "POST")
+
 	r.HandleFunc("/contribute", func(w http.ResponseWriter, r *http.Request) {
 		survey := struct {
 			Answers json.RawMessage `json:"answers"`
@@ -682,17 +1753,51 @@ func (s *Server) Start() {
 		_, _ = w.Write([]byte("\"OK\""))
 	})
 
+	var h http.Handler = r
+	if s.ReadOnly {
+		h = readOnly(r)
+	}
+
 	c := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
 		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 	})
 
-	s.srv = &http.Server{
+	return c.Handler(h)
+}
+
+// readOnlyPaths are the paths that only read despite accepting POST.
+var readOnlyPaths = map[string]bool{"/graphql": true, "/oai": true}
+
+func readOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS":
+		default:
+			if !readOnlyPaths[r.URL.Path] {
+				w.WriteHeader(403)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// writeTimeout limits how long responses may take, streams take over their
+// connection, see openStream.
+var writeTimeout = 10 * time.Second
+
+func (s *Server) httpServer() *http.Server {
+	return &http.Server{
 		Addr:         "0.0.0.0:" + strconv.Itoa(s.Port),
-		Handler:      c.Handler(r),
+		Handler:      s.Handler(),
 		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		WriteTimeout: writeTimeout,
 	}
+}
+
+func (s *Server) Start() {
+	s.srv = s.httpServer()
 	s.srv.ListenAndServe()
 }
 
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"strconv"
 	"testing"
 	"time"
@@ -231,3 +232,66 @@ func TestServer_CreateAnswer(t *testing.T) {
 		t.Fatal()
 	}
 }
+
+func TestServer_Categories(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	for _, c := range []string{"cf", "cf", "cl"} {
+		r := db.CreateReport("", true)
+		db.CreateRevision(r.ID, "", json.RawMessage("{\"MD\":{\"1\":\"Title\"},\"P\":{\"3\":{\"1\":{\"custom\":false,\"value\":\""+c+"\"}}}}"), r.Token, true)
+	}
+	db.BuildIndexes()
+
+	srv := Server{DB: db}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, _ := http.Get(ts.URL + "/categories")
+	if resp.StatusCode != 200 {
+		t.Fatal()
+	}
+	respBytes, _ := ioutil.ReadAll(resp.Body)
+	ctsResp := CategoriesResponse{}
+	json.Unmarshal(respBytes, &ctsResp)
+	if len(ctsResp.Categories) != 2 {
+		t.Fatal(string(respBytes))
+	}
+	if ctsResp.Categories[0].Category != "Classification" || ctsResp.Categories[0].Count != 2 {
+		t.Fatal(string(respBytes))
+	}
+
+	resp, _ = http.Get(ts.URL + "/categories/Classification")
+	if resp.StatusCode != 200 {
+		t.Fatal()
+	}
+	respBytes, _ = ioutil.ReadAll(resp.Body)
+	ctResp := CategoryResponse{}
+	json.Unmarshal(respBytes, &ctResp)
+	if ctResp.Count != 2 || len(ctResp.Results) != 2 || ctResp.Next != "" || ctResp.Results[0].Title != "Title" {
+		t.Fatal(string(respBytes))
+	}
+
+	resp, _ = http.Get(ts.URL + "/categories/Classification?l=1")
+	respBytes, _ = ioutil.ReadAll(resp.Body)
+	ctResp = CategoryResponse{}
+	json.Unmarshal(respBytes, &ctResp)
+	if ctResp.Count != 2 || len(ctResp.Results) != 1 || ctResp.Next == "" {
+		t.Fatal(string(respBytes))
+	}
+	first := ctResp.Results[0].ID
+
+	resp, _ = http.Get(ts.URL + "/categories/Classification?cursor=" + ctResp.Next)
+	respBytes, _ = ioutil.ReadAll(resp.Body)
+	ctResp = CategoryResponse{}
+	json.Unmarshal(respBytes, &ctResp)
+	if ctResp.Count != 2 || len(ctResp.Results) != 1 || ctResp.Results[0].ID == first || ctResp.Next != "" {
+		t.Fatal(string(respBytes))
+	}
+
+	resp, _ = http.Get(ts.URL + "/categories/Unknown")
+	if resp.StatusCode != 404 {
+		t.Fatal()
+	}
+}
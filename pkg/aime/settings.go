@@ -0,0 +1,107 @@
+package aime
+
+import (
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// IndexDefinition describes a named index over the answers of the latest
+// public revisions. Fields are dot-separated questionnaire paths where "*"
+// expands list elements, e.g. "MD.6.*.1".
+type IndexDefinition struct {
+	Name  string `yaml:"name"`
+	Field string `yaml:"field"`
+	// Other is the field holding the custom value if the option "Other" was selected
+	Other string `yaml:"other"`
+	// Groups applies the keyword groups to the extracted values
+	Groups bool `yaml:"groups"`
+	// Taxonomy maps the values onto the concepts of the taxonomy, so that
+	// broader concepts also match narrower ones
+	Taxonomy bool `yaml:"taxonomy"`
+}
+
+type Settings struct {
+	TitleField       string            `yaml:"titleField"`
+	AuthorsField     string            `yaml:"authorsField"`
+	AffiliationField string            `yaml:"affiliationField"`
+	OrcidField       string            `yaml:"orcidField"`
+	DescriptionField string            `yaml:"descriptionField"`
+	Indexes          []IndexDefinition `yaml:"indexes"`
+	// Taxonomy is the file of an optional hierarchical vocabulary, see ReadTaxonomy
+	Taxonomy string `yaml:"taxonomy"`
+}
+
+// The indexes "keywords" and "categories" back the /keywords and /categories
+// endpoints as well as the k and c parameters of /search.
+var DefaultSettings = Settings{
+	TitleField:       "MD.1",
+	AuthorsField:     "MD.6.*.1",
+	AffiliationField: "MD.6.*.2",
+	OrcidField:       "MD.6.*.4",
+	DescriptionField: "MD.3",
+	Indexes: []IndexDefinition{
+		{Name: "keywords", Field: "MD.5", Groups: true, Taxonomy: true},
+		{Name: "categories", Field: "P.3.1", Other: "P.3.2"},
+		{Name: "authors", Field: "MD.6.*.1"},
+		{Name: "institutions", Field: "MD.6.*.2"},
+		{Name: "licenses", Field: "R.2.1.4"},
+	},
+}
+
+// ReadSettings reads the settings from a YAML file. Missing values fall back
+// to DefaultSettings, a missing file yields the defaults.
+func ReadSettings(filename string) (Settings, error) {
+	var st Settings
+
+	stBytes, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return DefaultSettings, err
+	}
+
+	err = yaml.Unmarshal(stBytes, &st)
+	if err != nil {
+		return DefaultSettings, err
+	}
+
+	return st.withDefaults(), nil
+}
+
+func (st Settings) withDefaults() Settings {
+	if st.TitleField == "" {
+		st.TitleField = DefaultSettings.TitleField
+	}
+	if st.AuthorsField == "" {
+		st.AuthorsField = DefaultSettings.AuthorsField
+	}
+	if st.AffiliationField == "" {
+		st.AffiliationField = DefaultSettings.AffiliationField
+	}
+	if st.OrcidField == "" {
+		st.OrcidField = DefaultSettings.OrcidField
+	}
+	if st.DescriptionField == "" {
+		st.DescriptionField = DefaultSettings.DescriptionField
+	}
+	if st.Indexes == nil {
+		st.Indexes = DefaultSettings.Indexes
+	}
+	return st
+}
+
+func (st Settings) index(name string) IndexDefinition {
+	for _, d := range st.Indexes {
+		if d.Name == name {
+			return d
+		}
+	}
+	return IndexDefinition{Name: name}
+}
+
+func splitField(f string) []string {
+	if f == "" {
+		return nil
+	}
+	return strings.Split(f, ".")
+}
@@ -0,0 +1,54 @@
+package aime
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestReadSettings(t *testing.T) {
+	st, err := ReadSettings("../../config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.TitleField != "MD.1" {
+		t.Fatal()
+	}
+	if st.index("keywords").Field != "MD.5" || !st.index("keywords").Groups {
+		t.Fatal()
+	}
+	if st.index("categories").Other != "P.3.2" {
+		t.Fatal()
+	}
+}
+
+func TestReadSettings__Defaults(t *testing.T) {
+	st, err := ReadSettings("./does-not-exist.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.AuthorsField != "MD.6.*.1" || len(st.Indexes) != len(DefaultSettings.Indexes) {
+		t.Fatal()
+	}
+
+	ioutil.WriteFile("./test-settings.yaml", []byte("indexes:\n  - name: titles\n    field: MD.1\n"), os.ModePerm)
+	defer os.Remove("./test-settings.yaml")
+
+	st, err = ReadSettings("./test-settings.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.TitleField != "MD.1" || len(st.Indexes) != 1 || st.Indexes[0].Name != "titles" {
+		t.Fatal()
+	}
+}
+
+func TestReadSettings__Invalid(t *testing.T) {
+	ioutil.WriteFile("./test-settings.yaml", []byte("indexes: 5"), os.ModePerm)
+	defer os.Remove("./test-settings.yaml")
+
+	_, err := ReadSettings("./test-settings.yaml")
+	if err == nil {
+		t.Fatal()
+	}
+}
@@ -0,0 +1,358 @@
+package aime
+
+import (
+	"encoding/json"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Weights of the parts of the similarity of two reports
+const (
+	similarityText     = 0.5
+	similarityKeywords = 0.2
+	similarityCategory = 0.1
+	similarityURLs     = 0.2
+)
+
+var urlRegexp = regexp.MustCompile(`https?://[^\s"'<>()]+`)
+
+var stopWords = map[string]bool{
+	"and": true, "are": true, "but": true, "can": true, "for": true, "from": true,
+	"has": true, "have": true, "into": true, "not": true, "our": true, "that": true,
+	"the": true, "their": true, "then": true, "there": true, "these": true, "this": true,
+	"was": true, "were": true, "which": true, "with": true, "yes": true, "you": true,
+}
+
+type reportFeatures struct {
+	public   bool
+	terms    map[string]int
+	vector   map[string]float64
+	keywords map[string]bool
+	category string
+	urls     map[string]bool
+}
+
+// similarityRebuild is the share of the reports that may change before all
+// similarities are recomputed.
+const similarityRebuild = 0.1
+
+// similarityIndex keeps the pairwise similarities of the latest revisions.
+// Updating a report only recomputes its own similarities, so the vectors of
+// the other reports keep the document frequencies of their last computation
+// and their scores among each other slowly drift from those of a full build.
+// After a tenth of the reports changed, all of them are recomputed.
+type similarityIndex struct {
+	features map[string]*reportFeatures
+	df       map[string]int
+	scores   map[string]map[string]float64
+	changes  int
+	mutex    sync.Mutex
+}
+
+type similarReport struct {
+	id    string
+	score float64
+}
+
+func tokenize(txt string) map[string]int {
+	terms := map[string]int{}
+	for _, t := range strings.FieldsFunc(foldKeyword(txt), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(t) < 3 || stopWords[t] {
+			continue
+		}
+		terms[t]++
+	}
+	return terms
+}
+
+func normalizeURL(u string) string {
+	u = strings.TrimRight(u, "/.,;:")
+	u = strings.TrimPrefix(strings.TrimPrefix(u, "http://"), "https://")
+	u = strings.TrimPrefix(u, "www.")
+	return strings.ToLower(u)
+}
+
+func (db *DB) reportFeatures(rev *Revision) *reportFeatures {
+	f := &reportFeatures{
+		public:   rev.Public,
+		terms:    map[string]int{},
+		keywords: map[string]bool{},
+		urls:     map[string]bool{},
+	}
+
+	var ans interface{}
+	json.Unmarshal(rev.Answers, &ans)
+	sections, _ := ans.(map[string]interface{})
+
+	for _, ch := range db.questions.Children {
+		txt := extractText(ch, sections[ch.ID], " ")
+		for t, n := range tokenize(txt) {
+			f.terms[t] += n
+		}
+		for _, u := range urlRegexp.FindAllString(txt, -1) {
+			f.urls[normalizeURL(u)] = true
+		}
+	}
+
+	st := db.Settings.withDefaults()
+	db.mutex.Lock()
+	kwg := db.KeywordGroups
+	db.mutex.Unlock()
+
+	kwIndex := &index{definition: st.index("keywords"), entries: map[string]*indexEntry{}}
+	for _, k := range kwIndex.values(db.questions, kwg, rev.Answers) {
+		f.keywords[k] = true
+	}
+	ctIndex := &index{definition: st.index("categories"), entries: map[string]*indexEntry{}}
+	if cs := ctIndex.values(db.questions, kwg, rev.Answers); len(cs) > 0 {
+		f.category = cs[0]
+	}
+
+	return f
+}
+
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for k := range a {
+		if b[k] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+func newSimilarityIndex() *similarityIndex {
+	return &similarityIndex{
+		features: map[string]*reportFeatures{},
+		df:       map[string]int{},
+		scores:   map[string]map[string]float64{},
+	}
+}
+
+// vectorize computes the normalized TF-IDF vector with the current document
+// frequencies.
+func (si *similarityIndex) vectorize(f *reportFeatures) {
+	n := float64(len(si.features))
+	f.vector = map[string]float64{}
+	norm := 0.0
+	for t, c := range f.terms {
+		w := (1 + math.Log(float64(c))) * math.Log(1+n/float64(si.df[t]))
+		f.vector[t] = w
+		norm += w * w
+	}
+	norm = math.Sqrt(norm)
+	for t := range f.vector {
+		if norm > 0 {
+			f.vector[t] /= norm
+		}
+	}
+}
+
+func score(a, b *reportFeatures) float64 {
+	cos := 0.0
+	small, large := a.vector, b.vector
+	if len(small) > len(large) {
+		small, large = large, small
+	}
+	for t, w := range small {
+		cos += w * large[t]
+	}
+
+	s := similarityText*cos +
+		similarityKeywords*jaccard(a.keywords, b.keywords) +
+		similarityURLs*jaccard(a.urls, b.urls)
+	if a.category != "" && a.category == b.category {
+		s += similarityCategory
+	}
+	return s
+}
+
+func (si *similarityIndex) remove(id string) {
+	old, ok := si.features[id]
+	if !ok {
+		return
+	}
+	for t := range old.terms {
+		si.df[t]--
+		if si.df[t] == 0 {
+			delete(si.df, t)
+		}
+	}
+	delete(si.features, id)
+	for other := range si.scores[id] {
+		delete(si.scores[other], id)
+	}
+	delete(si.scores, id)
+}
+
+// rebuild recomputes all vectors and scores with the current document
+// frequencies.
+func (si *similarityIndex) rebuild() {
+	for _, f := range si.features {
+		si.vectorize(f)
+	}
+	si.scores = map[string]map[string]float64{}
+	for id, f := range si.features {
+		si.scores[id] = map[string]float64{}
+		for other, of := range si.features {
+			if other == id {
+				continue
+			}
+			if s := score(f, of); s > 0 {
+				si.scores[id][other] = s
+			}
+		}
+	}
+	si.changes = 0
+}
+
+// changed counts an update or deletion and rebuilds the index once too many
+// reports changed.
+func (si *similarityIndex) changed() bool {
+	si.changes++
+	if float64(si.changes) > similarityRebuild*float64(len(si.features)) {
+		si.rebuild()
+		return true
+	}
+	return false
+}
+
+func (si *similarityIndex) update(id string, f *reportFeatures) {
+	si.mutex.Lock()
+	defer si.mutex.Unlock()
+
+	si.remove(id)
+
+	si.features[id] = f
+	for t := range f.terms {
+		si.df[t]++
+	}
+	if si.changed() {
+		return
+	}
+	si.vectorize(f)
+
+	si.scores[id] = map[string]float64{}
+	for other, of := range si.features {
+		if other == id {
+			continue
+		}
+		s := score(f, of)
+		if s <= 0 {
+			continue
+		}
+		si.scores[id][other] = s
+		if si.scores[other] == nil {
+			si.scores[other] = map[string]float64{}
+		}
+		si.scores[other][id] = s
+	}
+}
+
+func (si *similarityIndex) delete(id string) {
+	si.mutex.Lock()
+	defer si.mutex.Unlock()
+
+	if _, ok := si.features[id]; ok {
+		si.remove(id)
+		si.changed()
+	}
+}
+
+// similar returns the public reports most similar to the given one.
+func (si *similarityIndex) similar(id string, limit int) []similarReport {
+	si.mutex.Lock()
+	defer si.mutex.Unlock()
+
+	var res []similarReport
+	for other, s := range si.scores[id] {
+		if si.features[other].public {
+			res = append(res, similarReport{other, s})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].score != res[j].score {
+			return res[i].score > res[j].score
+		}
+		return res[i].id < res[j].id
+	})
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res
+}
+
+// BuildSimilarities computes the similarities of all latest revisions. Later
+// revisions update them incrementally, see similarityIndex.
+func (db *DB) BuildSimilarities() {
+	revs := []*Revision{}
+	for rev := range db.GetLatestRevisions(true) {
+		revs = append(revs, rev)
+	}
+
+	si := newSimilarityIndex()
+
+	// Compute all document frequencies first, so that early vectors do not
+	// miss the rest of the corpus
+	for _, rev := range revs {
+		f := db.reportFeatures(rev)
+		si.features[rev.ReportID] = f
+		for t := range f.terms {
+			si.df[t]++
+		}
+	}
+	si.rebuild()
+
+	db.similarityMutex.Lock()
+	db.similarity = si
+	db.similarityMutex.Unlock()
+}
+
+func (db *DB) getSimilarity() *similarityIndex {
+	db.similarityMutex.Lock()
+	si := db.similarity
+	db.similarityMutex.Unlock()
+
+	if si == nil {
+		db.BuildSimilarities()
+		return db.getSimilarity()
+	}
+	return si
+}
+
+// updateSimilarity is called for every new revision. Before the first
+// request nothing has been computed yet, so there is nothing to update.
+func (db *DB) updateSimilarity(rev *Revision) {
+	db.similarityMutex.Lock()
+	si := db.similarity
+	db.similarityMutex.Unlock()
+
+	if si != nil {
+		si.update(rev.ReportID, db.reportFeatures(rev))
+	}
+}
+
+func (db *DB) deleteSimilarity(id string) {
+	db.similarityMutex.Lock()
+	si := db.similarity
+	db.similarityMutex.Unlock()
+
+	if si != nil {
+		si.delete(id)
+	}
+}
+
+// SimilarReports returns the IDs and scores of the public reports most
+// similar to the given one.
+func (db *DB) SimilarReports(id string, limit int) []similarReport {
+	return db.getSimilarity().similar(id, limit)
+}
@@ -0,0 +1,176 @@
+package aime
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	terms := tokenize("The tumour classification, and tumour-segmentation of CT")
+	if terms["tumour"] != 2 || terms["classification"] != 1 || terms["segmentation"] != 1 {
+		t.Fatal(terms)
+	}
+	if _, ok := terms["the"]; ok {
+		t.Fatal()
+	}
+	if _, ok := terms["ct"]; ok {
+		t.Fatal()
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	if normalizeURL("https://www.GitHub.com/a/b/") != "github.com/a/b" {
+		t.Fatal()
+	}
+}
+
+func createSimilarityReports(db *DB) []*Report {
+	te := []struct {
+		purpose  string
+		keyword  string
+		category string
+		code     string
+		public   bool
+	}{
+		{"Predict heart failure from electrocardiograms", "cardiology", "cf", "https://github.com/a/ecg", true},
+		{"Detect heart failure in electrocardiograms", "cardiology", "cf", "https://github.com/a/ecg/", true},
+		{"Cluster single cell transcriptomes", "omics", "cl", "https://github.com/b/sc", true},
+		{"Predict heart failure with hidden methods", "cardiology", "cf", "", false},
+	}
+
+	var rps []*Report
+	for _, r := range te {
+		jn := "{\"MD\":{\"1\":\"" + r.purpose + "\",\"5\":[{\"custom\":true,\"value\":\"" + r.keyword + "\"}]}," +
+			"\"P\":{\"1\":\"" + r.purpose + "\",\"3\":{\"1\":{\"custom\":false,\"value\":\"" + r.category + "\"}}}," +
+			"\"R\":{\"2\":{\"1\":{\"1\":{\"custom\":false,\"value\":\"yes\"},\"2\":\"" + r.code + "\"}}}}"
+		rp := db.CreateReport("", r.public)
+		db.CreateRevision(rp.ID, "", json.RawMessage(jn), rp.Token, r.public)
+		rps = append(rps, rp)
+	}
+	return rps
+}
+
+func TestDB_SimilarReports(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	rps := createSimilarityReports(db)
+
+	sim := db.SimilarReports(rps[0].ID, 10)
+	if len(sim) != 2 {
+		t.Fatal(sim)
+	}
+	if sim[0].id != rps[1].ID || sim[0].score <= sim[1].score {
+		t.Fatal(sim)
+	}
+
+	// Updated incrementally
+	jn := "{\"MD\":{\"1\":\"Cluster single cell transcriptomes\"},\"P\":{\"1\":\"Cluster single cell transcriptomes\"}}"
+	db.CreateRevision(rps[1].ID, "", json.RawMessage(jn), rps[1].Token, true)
+
+	sim = db.SimilarReports(rps[2].ID, 1)
+	if len(sim) != 1 || sim[0].id != rps[1].ID {
+		t.Fatal(sim)
+	}
+
+	db.DeleteReport(rps[1].ID)
+
+	sim = db.SimilarReports(rps[2].ID, 10)
+	for _, s := range sim {
+		if s.id == rps[1].ID {
+			t.Fatal()
+		}
+	}
+}
+
+func TestSimilarityIndex_update(t *testing.T) {
+	features := func(i int, words ...string) *reportFeatures {
+		f := &reportFeatures{terms: map[string]int{"common": 1, "word" + strconv.Itoa(i%3): 1}}
+		for _, w := range words {
+			f.terms[w]++
+		}
+		return f
+	}
+
+	si := newSimilarityIndex()
+	full := newSimilarityIndex()
+	for i := 0; i < 20; i++ {
+		si.features[strconv.Itoa(i)] = features(i)
+	}
+	for id, f := range si.features {
+		for t := range f.terms {
+			si.df[t]++
+		}
+		full.features[id] = f
+	}
+	si.rebuild()
+
+	// Two updates stay below the rebuild threshold, the scores of other
+	// reports are not recomputed yet
+	si.update("0", features(0, "rare"))
+	si.update("1", features(1, "rare", "common"))
+	if si.changes != 2 {
+		t.Fatal(si.changes)
+	}
+
+	// The third one rebuilds the index as from scratch
+	si.update("2", features(2, "rare"))
+	if si.changes != 0 {
+		t.Fatal(si.changes)
+	}
+
+	full.features["0"] = features(0, "rare")
+	full.features["1"] = features(1, "rare", "common")
+	full.features["2"] = features(2, "rare")
+	for _, f := range full.features {
+		for t := range f.terms {
+			full.df[t]++
+		}
+	}
+	full.rebuild()
+
+	for id, scores := range full.scores {
+		if len(si.scores[id]) != len(scores) {
+			t.Fatal(id, si.scores[id], scores)
+		}
+		for other, s := range scores {
+			if math.Abs(si.scores[id][other]-s) > 1e-9 {
+				t.Fatal(id, other, si.scores[id][other], s)
+			}
+		}
+	}
+}
+
+func TestServer_SimilarReports(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	rps := createSimilarityReports(db)
+
+	srv := Server{DB: db}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, _ := http.Get(ts.URL + "/report/" + rps[3].ID + "/similar?l=1")
+	if resp.StatusCode != 200 {
+		t.Fatal()
+	}
+	respBytes, _ := ioutil.ReadAll(resp.Body)
+	simResp := SimilarResponse{}
+	json.Unmarshal(respBytes, &simResp)
+	if len(simResp.Results) != 1 || simResp.Results[0].Score <= 0 || simResp.Results[0].Title == "" {
+		t.Fatal(string(respBytes))
+	}
+
+	resp, _ = http.Get(ts.URL + "/report/unknown/similar")
+	if resp.StatusCode != 404 {
+		t.Fatal()
+	}
+}
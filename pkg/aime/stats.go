@@ -0,0 +1,217 @@
+package aime
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// GetStats counts reports, revisions and issues of the whole registry,
+// including hidden reports.
+func (db *DB) GetStats() StatsResponse {
+	st := StatsResponse{Months: []MonthStats{}}
+	months := map[string]*MonthStats{}
+	month := func(m string) *MonthStats {
+		if months[m] == nil {
+			months[m] = &MonthStats{Month: m}
+		}
+		return months[m]
+	}
+
+	files, _ := ioutil.ReadDir(filepath.Join(db.Dir, "reports"))
+	for _, f := range files {
+		if !f.IsDir() {
+			continue
+		}
+		rp := db.GetReport(f.Name())
+		if rp == nil || rp.Revisions == 0 {
+			continue
+		}
+
+		st.Reports++
+		month(rp.CreatedAt.UTC().Format("2006-01")).Reports++
+
+		var latest *Revision
+		for ver := 1; ver <= rp.Revisions; ver++ {
+			rev := db.GetRevision(rp.ID, ver)
+			if rev == nil {
+				continue
+			}
+			st.Revisions++
+			month(rev.CreatedAt.UTC().Format("2006-01")).Revisions++
+			latest = rev
+		}
+		if latest != nil && latest.Public {
+			st.Public++
+		} else {
+			st.Hidden++
+		}
+
+		for iss := range db.GetReportIssues(rp.ID, true) {
+			st.Issues++
+			for _, a := range iss.Answers {
+				if a.Owner {
+					st.AnsweredIssues++
+					break
+				}
+			}
+		}
+	}
+
+	for _, m := range months {
+		st.Months = append(st.Months, *m)
+	}
+	sort.Slice(st.Months, func(i, j int) bool {
+		return st.Months[i].Month < st.Months[j].Month
+	})
+
+	if st.Issues > 0 {
+		st.ResponseRate = float64(st.AnsweredIssues) / float64(st.Issues)
+	}
+
+	return st
+}
+
+// questionAt returns the question at the path, list elements are addressed by
+// "*" or their number.
+func questionAt(q Question, ids []string) *Question {
+	if len(ids) == 0 {
+		return &q
+	}
+	switch q.Type {
+	case "complex":
+		for _, child := range q.Children {
+			if child.ID == ids[0] {
+				return questionAt(child, ids[1:])
+			}
+		}
+	case "list":
+		if q.Child != nil {
+			return questionAt(*q.Child, ids[1:])
+		}
+	}
+	return nil
+}
+
+// collectAnswers returns the answers of the question at the path. Questions
+// whose condition is not met are skipped, as they are not shown.
+func collectAnswers(q Question, a interface{}, ids []string) []interface{} {
+	if a == nil {
+		return nil
+	}
+	if len(ids) == 0 {
+		return []interface{}{a}
+	}
+
+	switch q.Type {
+	case "complex":
+		compl, _ := a.(map[string]interface{})
+		for _, child := range q.Children {
+			if child.ID == ids[0] && child.shown(compl) {
+				return collectAnswers(child, compl[child.ID], ids[1:])
+			}
+		}
+	case "list":
+		if q.Child == nil {
+			return nil
+		}
+		list, _ := a.([]interface{})
+		var as []interface{}
+		for i, ae := range list {
+			if ids[0] == "*" || ids[0] == strconv.Itoa(i+1) {
+				as = append(as, collectAnswers(*q.Child, ae, ids[1:])...)
+			}
+		}
+		return as
+	}
+	return nil
+}
+
+// GetQuestionStats counts the option values of a select, radio, checkboxes or
+// tags question in the latest public revisions. Every value is counted once
+// per report. It returns nil if there is no such question.
+func (db *DB) GetQuestionStats(path string) *QuestionStatsResponse {
+	ids := splitField(path)
+	q := questionAt(db.questions, ids)
+	if q == nil || len(ids) == 0 {
+		return nil
+	}
+	switch q.Type {
+	case "select", "radio", "checkboxes", "tags":
+	default:
+		return nil
+	}
+
+	st := &QuestionStatsResponse{
+		Path:     path,
+		Question: q.Question,
+		Type:     q.Type,
+		Values:   []ValueStats{},
+	}
+
+	counts := map[string]*ValueStats{}
+	var order []string
+	if q.Config != nil {
+		for _, o := range q.Config.Options {
+			counts[o.Key] = &ValueStats{Key: o.Key, Value: o.Value}
+			order = append(order, o.Key)
+		}
+	}
+	var custom []string
+
+	for rev := range db.GetLatestRevisions(false) {
+		st.Reports++
+
+		var ans interface{}
+		json.Unmarshal(rev.Answers, &ans)
+
+		var vals []interface{}
+		for _, a := range collectAnswers(db.questions, ans, ids) {
+			if list, ok := a.([]interface{}); ok {
+				vals = append(vals, list...)
+			} else {
+				vals = append(vals, a)
+			}
+		}
+
+		seen := map[string]bool{}
+		for _, v := range vals {
+			label := extractValue(v, *q)
+			if label == "" {
+				continue
+			}
+			// Custom values must not be mistaken for option keys
+			key := "\x00" + label
+			if m, _ := v.(map[string]interface{}); m["custom"] == false {
+				key, _ = m["value"].(string)
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			if counts[key] == nil {
+				counts[key] = &ValueStats{Value: label, Custom: true}
+				custom = append(custom, key)
+			}
+			counts[key].Count++
+		}
+		if len(seen) > 0 {
+			st.Answered++
+		}
+	}
+
+	for _, k := range order {
+		st.Values = append(st.Values, *counts[k])
+	}
+	sort.SliceStable(custom, func(i, j int) bool {
+		return counts[custom[i]].Count > counts[custom[j]].Count
+	})
+	for _, k := range custom {
+		st.Values = append(st.Values, *counts[k])
+	}
+
+	return st
+}
@@ -0,0 +1,91 @@
+package aime
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer_Stats(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	metrics := func(vals ...string) string {
+		jn := "{\"M\":{\"3\":{\"1\":["
+		for i, v := range vals {
+			if i > 0 {
+				jn += ","
+			}
+			jn += v
+		}
+		return jn + "]}}}"
+	}
+
+	r1 := db.CreateReport("", true)
+	db.CreateRevision(r1.ID, "", json.RawMessage(metrics(`{"custom":false,"value":"acc"}`)), r1.Token, true)
+	db.CreateRevision(r1.ID, "", json.RawMessage(metrics(`{"custom":false,"value":"acc"}`, `{"custom":false,"value":"auc"}`)), r1.Token, true)
+	r2 := db.CreateReport("", true)
+	db.CreateRevision(r2.ID, "", json.RawMessage(metrics(`{"custom":false,"value":"acc"}`, `{"custom":true,"value":"Brier score"}`)), r2.Token, true)
+	r3 := db.CreateReport("", false)
+	db.CreateRevision(r3.ID, "", json.RawMessage(metrics(`{"custom":false,"value":"rt"}`)), r3.Token, false)
+
+	i1 := db.CreateIssue(r1.ID, "Jane", "jane@test.de", []string{"M"}, "Question", 0)
+	db.ValidateIssue(r1.ID, i1.ID, i1.Token)
+	db.CreateAnswer(r1.ID, i1.ID, "Answer", r1.Token)
+	i2 := db.CreateIssue(r2.ID, "Jane", "jane@test.de", []string{"M"}, "Question", 0)
+	db.ValidateIssue(r2.ID, i2.ID, i2.Token)
+
+	srv := Server{DB: db}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, _ := http.Get(ts.URL + "/stats")
+	respBytes, _ := ioutil.ReadAll(resp.Body)
+	st := StatsResponse{}
+	json.Unmarshal(respBytes, &st)
+	if st.Reports != 3 || st.Revisions != 4 || st.Public != 2 || st.Hidden != 1 {
+		t.Fatal(string(respBytes))
+	}
+	if len(st.Months) != 1 || st.Months[0].Revisions != 4 || st.Issues != 2 || st.ResponseRate != 0.5 {
+		t.Fatal(string(respBytes))
+	}
+
+	resp, _ = http.Get(ts.URL + "/stats/question/M.3.1")
+	respBytes, _ = ioutil.ReadAll(resp.Body)
+	qs := QuestionStatsResponse{}
+	json.Unmarshal(respBytes, &qs)
+	if qs.Reports != 2 || qs.Answered != 2 || qs.Values[0].Value != "Accuracy" || qs.Values[0].Count != 2 {
+		t.Fatal(string(respBytes))
+	}
+	counts := map[string]int{}
+	for _, v := range qs.Values {
+		counts[v.Value] = v.Count
+	}
+	// Hidden reports are not counted
+	if counts["AUC (area under curve)"] != 1 || counts["Brier score"] != 1 || counts["Runtime"] != 0 {
+		t.Fatal(string(respBytes))
+	}
+	if last := qs.Values[len(qs.Values)-1]; !last.Custom || last.Value != "Brier score" {
+		t.Fatal(string(respBytes))
+	}
+
+	if resp, _ := http.Get(ts.URL + "/stats/question/MD.1"); resp.StatusCode != 404 {
+		t.Fatal(resp.StatusCode)
+	}
+}
+
+func TestCollectAnswers(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	var a interface{}
+	json.Unmarshal([]byte(`{"M":{"4":{"1":{"custom":false,"value":"no"},"2":[{"custom":false,"value":"cv"}]}}}`), &a)
+	// The methods are not shown if no measures were taken
+	if as := collectAnswers(db.questions, a, []string{"M", "4", "2"}); len(as) != 0 {
+		t.Fatal(as)
+	}
+}
@@ -0,0 +1,78 @@
+package aime
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Responses that take longer than the write timeout of the server, like event
+// streams, long-polls and dumps, take over the connection from the server. The
+// deadline of the connection is cleared and the response is written as is, the
+// end of the body is marked by closing the connection.
+
+var ErrNoStream = errors.New("connection cannot be taken over")
+
+type stream struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+	done chan struct{}
+}
+
+// openStream takes over the connection of a response and writes the status
+// and the headers set so far.
+func openStream(w http.ResponseWriter, status int) (*stream, error) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, ErrNoStream
+	}
+
+	header := w.Header().Clone()
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	header.Set("Connection", "close")
+	header.Del("Content-Length")
+	fmt.Fprintf(rw, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	header.Write(rw)
+	rw.WriteString("\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	st := &stream{conn: conn, rw: rw, done: make(chan struct{})}
+	// Clients send nothing more, reading only ends when they go away
+	go func() {
+		io.Copy(ioutil.Discard, rw)
+		close(st.done)
+	}()
+	return st, nil
+}
+
+func (st *stream) Write(b []byte) (int, error) {
+	return st.rw.Write(b)
+}
+
+func (st *stream) Flush() error {
+	return st.rw.Flush()
+}
+
+// Done is closed when the client goes away.
+func (st *stream) Done() <-chan struct{} {
+	return st.done
+}
+
+// Close ends the response.
+func (st *stream) Close() error {
+	st.rw.Flush()
+	return st.conn.Close()
+}
@@ -0,0 +1,141 @@
+package aime
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var diacriticFolds = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'æ': "ae",
+	'ç': "c", 'ć': "c", 'ĉ': "c", 'ċ': "c", 'č': "c",
+	'ď': "d", 'đ': "d", 'ð': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ĕ': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ĝ': "g", 'ğ': "g", 'ġ': "g", 'ģ': "g",
+	'ĥ': "h", 'ħ': "h",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ĩ': "i", 'ī': "i", 'ĭ': "i", 'į': "i", 'ı': "i",
+	'ĵ': "j",
+	'ķ': "k",
+	'ĺ': "l", 'ļ': "l", 'ľ': "l", 'ŀ': "l", 'ł': "l",
+	'ñ': "n", 'ń': "n", 'ņ': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ŏ': "o", 'ő': "o",
+	'œ': "oe",
+	'ŕ': "r", 'ŗ': "r", 'ř': "r",
+	'ś': "s", 'ŝ': "s", 'ş': "s", 'š': "s", 'ß': "ss",
+	'ţ': "t", 'ť': "t", 'ŧ': "t",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ũ': "u", 'ū': "u", 'ŭ': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'ŵ': "w",
+	'ý': "y", 'ÿ': "y", 'ŷ': "y",
+	'ź': "z", 'ż': "z", 'ž': "z",
+	'þ': "th",
+}
+
+// foldKeyword lower-cases a keyword and strips diacritics, so that "Über" and
+// "uber" end up under the same key.
+func foldKeyword(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if f, ok := diacriticFolds[r]; ok {
+			b.WriteString(f)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// maxSuggestions is the most suggestions a lookup returns.
+const maxSuggestions = 100
+
+type suggestNode struct {
+	children map[rune]*suggestNode
+	// top holds the best ranked entries below the node, so that lookups do
+	// not walk the subtree on every keystroke
+	top []*indexEntry
+}
+
+// suggestLess ranks entries by their number of reports, then by value.
+func suggestLess(a *indexEntry, b *indexEntry) bool {
+	if len(a.reports) != len(b.reports) {
+		return len(b.reports) < len(a.reports)
+	}
+	return a.value < b.value
+}
+
+// add ranks an entry among the top entries of the node.
+func (n *suggestNode) add(e *indexEntry) {
+	if len(n.top) == maxSuggestions && !suggestLess(e, n.top[maxSuggestions-1]) {
+		return
+	}
+	for _, t := range n.top {
+		if t == e {
+			return
+		}
+	}
+	i := sort.Search(len(n.top), func(i int) bool {
+		return suggestLess(e, n.top[i])
+	})
+	n.top = append(n.top, nil)
+	copy(n.top[i+1:], n.top[i:])
+	n.top[i] = e
+	if len(n.top) > maxSuggestions {
+		n.top = n.top[:maxSuggestions]
+	}
+}
+
+// suggestIndex is a prefix tree over folded index values. Every value is
+// reachable from the start of each of its words, so "learn" finds
+// "deep learning".
+type suggestIndex struct {
+	root *suggestNode
+}
+
+func newSuggestIndex() *suggestIndex {
+	return &suggestIndex{root: &suggestNode{}}
+}
+
+func (si *suggestIndex) insert(key string, e *indexEntry) {
+	folded := []rune(foldKeyword(key))
+	for i := range folded {
+		if i > 0 && folded[i-1] != ' ' && folded[i-1] != '-' {
+			continue
+		}
+		n := si.root
+		n.add(e)
+		for _, r := range folded[i:] {
+			if n.children == nil {
+				n.children = map[rune]*suggestNode{}
+			}
+			c, ok := n.children[r]
+			if !ok {
+				c = &suggestNode{}
+				n.children[r] = c
+			}
+			n = c
+			n.add(e)
+		}
+	}
+}
+
+// lookup returns the best ranked entries starting with the prefix, at most
+// limit or maxSuggestions.
+func (si *suggestIndex) lookup(prefix string, limit int) []*indexEntry {
+	n := si.root
+	for _, r := range foldKeyword(prefix) {
+		c, ok := n.children[r]
+		if !ok {
+			return nil
+		}
+		n = c
+	}
+
+	es := n.top
+	if limit > 0 && len(es) > limit {
+		es = es[:limit]
+	}
+	return append([]*indexEntry(nil), es...)
+}
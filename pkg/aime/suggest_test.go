@@ -0,0 +1,147 @@
+package aime
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestFoldKeyword(t *testing.T) {
+	if foldKeyword("Über") != "uber" {
+		t.Fatal(foldKeyword("Über"))
+	}
+	if foldKeyword("Straße") != "strasse" {
+		t.Fatal(foldKeyword("Straße"))
+	}
+	if foldKeyword("école") != "ecole" {
+		t.Fatal(foldKeyword("école"))
+	}
+}
+
+func TestSuggestIndex(t *testing.T) {
+	a := &indexEntry{value: "deep learning", reports: []string{"1", "2"}}
+	b := &indexEntry{value: "Deep-Q network", reports: []string{"1", "2", "3"}}
+	c := &indexEntry{value: "Ökologie", reports: []string{"1"}}
+
+	si := newSuggestIndex()
+	si.insert(a.value, a)
+	si.insert(b.value, b)
+	si.insert(c.value, c)
+
+	kws := si.lookup("DEEP", 10)
+	if len(kws) != 2 || kws[0] != b || kws[1] != a {
+		t.Fatal(kws)
+	}
+
+	kws = si.lookup("deep", 1)
+	if len(kws) != 1 || kws[0] != b {
+		t.Fatal(kws)
+	}
+
+	kws = si.lookup("learn", 10)
+	if len(kws) != 1 || kws[0] != a {
+		t.Fatal(kws)
+	}
+
+	kws = si.lookup("q net", 10)
+	if len(kws) != 1 || kws[0] != b {
+		t.Fatal(kws)
+	}
+
+	kws = si.lookup("oko", 10)
+	if len(kws) != 1 || kws[0] != c {
+		t.Fatal(kws)
+	}
+
+	if len(si.lookup("earn", 10)) != 0 {
+		t.Fatal()
+	}
+
+	for i := 0; i < 2*maxSuggestions; i++ {
+		si.insert("deep"+strconv.Itoa(i), &indexEntry{value: "deep" + strconv.Itoa(i)})
+	}
+	kws = si.lookup("deep", 0)
+	if len(kws) != maxSuggestions || kws[0] != b || kws[1] != a {
+		t.Fatal(len(kws))
+	}
+}
+
+func TestDB_SuggestKeywords(t *testing.T) {
+	db := DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	db.KeywordGroups = KeywordGroups{
+		{
+			Sources: []string{"gene expressions"},
+			Targets: []string{"gene expression", "GE"},
+		},
+	}
+
+	for _, kw := range []string{"gene expression", "genomics", "genomics"} {
+		r := db.CreateReport("", true)
+		db.CreateRevision(r.ID, "", json.RawMessage("{\"MD\":{\"5\":[{\"custom\":true,\"value\":\""+kw+"\"}]}}"), r.Token, true)
+	}
+
+	db.BuildIndexes()
+
+	kws := db.SuggestKeywords("gen", 10)
+	if len(kws) != 2 || kws[0].value != "genomics" || kws[1].value != "gene expression" {
+		t.Fatal(kws)
+	}
+
+	kws = db.SuggestKeywords("gene expressions", 10)
+	if len(kws) != 1 || kws[0].value != "gene expression" {
+		t.Fatal(kws)
+	}
+}
+
+func TestServer_SuggestKeywords(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	for _, kw := range []string{"cardiology", "cancer", "cancer"} {
+		r := db.CreateReport("", true)
+		db.CreateRevision(r.ID, "", json.RawMessage("{\"MD\":{\"5\":[{\"custom\":true,\"value\":\""+kw+"\"}]}}"), r.Token, true)
+	}
+	db.BuildIndexes()
+
+	srv := Server{DB: db}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, _ := http.Get(ts.URL + "/keywords/suggest?prefix=CA&limit=1")
+	if resp.StatusCode != 200 {
+		t.Fatal()
+	}
+	respBytes, _ := ioutil.ReadAll(resp.Body)
+	respStruct := KeywordsResponse{}
+	json.Unmarshal(respBytes, &respStruct)
+	if len(respStruct.Keywords) != 1 || respStruct.Keywords[0].Keyword != "cancer" || respStruct.Keywords[0].Count != 2 {
+		t.Fatal(string(respBytes))
+	}
+
+	resp, _ = http.Get(ts.URL + "/keywords/suggest?prefix=card&format=list")
+	respBytes, _ = ioutil.ReadAll(resp.Body)
+	list := KeywordListResponse{}
+	json.Unmarshal(respBytes, &list)
+	if len(list) != 1 || list[0] != "cardiology" {
+		t.Fatal(string(respBytes))
+	}
+
+	// The suggestion URL of the questionnaire gets the typed text appended,
+	// without any it lists the most used keywords
+	for prefix, first := range map[string]string{"": "cancer", "car": "cardiology"} {
+		resp, _ = http.Get(ts.URL + "/keywords/suggest?format=list&limit=20&prefix=" + prefix)
+		respBytes, _ = ioutil.ReadAll(resp.Body)
+		list = KeywordListResponse{}
+		json.Unmarshal(respBytes, &list)
+		if len(list) == 0 || list[0] != first {
+			t.Fatal(prefix, string(respBytes))
+		}
+	}
+}
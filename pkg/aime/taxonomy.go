@@ -0,0 +1,292 @@
+package aime
+
+import (
+	"bufio"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type Concept struct {
+	ID       string
+	Label    string
+	Synonyms []string
+	Parents  []string
+
+	children []string
+}
+
+// Taxonomy is a hierarchical vocabulary which keywords are mapped onto. A
+// concept can have several parents.
+type Taxonomy struct {
+	concepts map[string]*Concept
+	labels   map[string]string
+}
+
+func newTaxonomy() *Taxonomy {
+	return &Taxonomy{
+		concepts: map[string]*Concept{},
+		labels:   map[string]string{},
+	}
+}
+
+// ReadTaxonomy reads a vocabulary from a local file. The format is derived
+// from the extension: ".obo" for OBO, ".rdf" or ".xml" for SKOS in RDF/XML
+// and MeSH-like trees ("Label;A01.123" per line) otherwise.
+func ReadTaxonomy(filename string) (*Taxonomy, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var tx *Taxonomy
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".obo":
+		tx, err = parseOBO(f)
+	case ".rdf", ".xml":
+		tx, err = parseSKOS(f)
+	default:
+		tx, err = parseTree(f)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tx.link()
+
+	return tx, nil
+}
+
+func (tx *Taxonomy) add(c *Concept) {
+	if c.ID == "" {
+		return
+	}
+	if c.Label == "" {
+		c.Label = c.ID
+	}
+	tx.concepts[c.ID] = c
+}
+
+// link resolves children and the label lookup after all concepts were read.
+func (tx *Taxonomy) link() {
+	for _, c := range tx.concepts {
+		var parents []string
+		for _, p := range c.Parents {
+			if pc, ok := tx.concepts[p]; ok && p != c.ID {
+				pc.children = append(pc.children, c.ID)
+				parents = append(parents, p)
+			}
+		}
+		c.Parents = parents
+	}
+	for _, c := range tx.concepts {
+		sort.Strings(c.children)
+		for _, s := range c.Synonyms {
+			if _, ok := tx.labels[foldKeyword(s)]; !ok {
+				tx.labels[foldKeyword(s)] = c.ID
+			}
+		}
+	}
+	// Preferred labels take precedence over synonyms
+	for _, c := range tx.concepts {
+		tx.labels[foldKeyword(c.Label)] = c.ID
+	}
+}
+
+// Find returns the concept with the given ID, label or synonym.
+func (tx *Taxonomy) Find(k string) *Concept {
+	if tx == nil {
+		return nil
+	}
+	if c, ok := tx.concepts[k]; ok {
+		return c
+	}
+	if id, ok := tx.labels[foldKeyword(k)]; ok {
+		return tx.concepts[id]
+	}
+	return nil
+}
+
+func (tx *Taxonomy) Get(id string) *Concept {
+	return tx.concepts[id]
+}
+
+// Roots returns all concepts without parents, sorted by label.
+func (tx *Taxonomy) Roots() []*Concept {
+	var roots []*Concept
+	for _, c := range tx.concepts {
+		if len(c.Parents) == 0 {
+			roots = append(roots, c)
+		}
+	}
+	sort.Slice(roots, func(i, j int) bool {
+		return roots[i].Label < roots[j].Label
+	})
+	return roots
+}
+
+func (tx *Taxonomy) Children(c *Concept) []*Concept {
+	var cs []*Concept
+	for _, id := range c.children {
+		cs = append(cs, tx.concepts[id])
+	}
+	return cs
+}
+
+// ancestors returns the IDs of the concept and all of its broader concepts.
+func (tx *Taxonomy) ancestors(c *Concept) []string {
+	seen := map[string]bool{c.ID: true}
+	ids := []string{c.ID}
+	for i := 0; i < len(ids); i++ {
+		for _, p := range tx.concepts[ids[i]].Parents {
+			if !seen[p] {
+				seen[p] = true
+				ids = append(ids, p)
+			}
+		}
+	}
+	return ids
+}
+
+func parseTree(r io.Reader) (*Taxonomy, error) {
+	tx := newTaxonomy()
+
+	type node struct {
+		label  string
+		number string
+	}
+	var nodes []node
+	labelOf := map[string]string{}
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, ";")
+		if i < 0 {
+			continue
+		}
+		n := node{strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])}
+		nodes = append(nodes, n)
+		labelOf[n.number] = n.label
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	// A label can appear at several tree numbers, each adding a parent
+	for _, n := range nodes {
+		c, ok := tx.concepts[n.label]
+		if !ok {
+			c = &Concept{ID: n.label, Label: n.label}
+			tx.add(c)
+		}
+		if i := strings.LastIndex(n.number, "."); i >= 0 {
+			if p, ok := labelOf[n.number[:i]]; ok {
+				c.Parents = append(c.Parents, p)
+			}
+		}
+	}
+
+	return tx, nil
+}
+
+func parseOBO(r io.Reader) (*Taxonomy, error) {
+	tx := newTaxonomy()
+
+	var c *Concept
+	obsolete := false
+	flush := func() {
+		if c != nil && !obsolete {
+			tx.add(c)
+		}
+		c = nil
+		obsolete = false
+	}
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "[") {
+			flush()
+			if line == "[Term]" {
+				c = &Concept{}
+			}
+			continue
+		}
+		if c == nil {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		key, val := line[:i], strings.TrimSpace(line[i+1:])
+		// Strip trailing comments
+		if j := strings.Index(val, " !"); j >= 0 {
+			val = strings.TrimSpace(val[:j])
+		}
+		switch key {
+		case "id":
+			c.ID = val
+		case "name":
+			c.Label = val
+		case "synonym":
+			if parts := strings.SplitN(val, "\"", 3); len(parts) == 3 {
+				c.Synonyms = append(c.Synonyms, parts[1])
+			}
+		case "is_a":
+			c.Parents = append(c.Parents, val)
+		case "is_obsolete":
+			obsolete = val == "true"
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+
+	return tx, nil
+}
+
+func parseSKOS(r io.Reader) (*Taxonomy, error) {
+	doc := struct {
+		Concepts []struct {
+			About      string   `xml:"about,attr"`
+			PrefLabels []string `xml:"prefLabel"`
+			AltLabels  []string `xml:"altLabel"`
+			Broader    []struct {
+				Resource string `xml:"resource,attr"`
+			} `xml:"broader"`
+		} `xml:"Concept"`
+	}{}
+
+	err := xml.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := newTaxonomy()
+	for _, sc := range doc.Concepts {
+		c := &Concept{
+			ID:       sc.About,
+			Synonyms: sc.AltLabels,
+		}
+		if len(sc.PrefLabels) > 0 {
+			c.Label = sc.PrefLabels[0]
+			c.Synonyms = append(c.Synonyms, sc.PrefLabels[1:]...)
+		}
+		for _, b := range sc.Broader {
+			c.Parents = append(c.Parents, b.Resource)
+		}
+		tx.add(c)
+	}
+
+	return tx, nil
+}
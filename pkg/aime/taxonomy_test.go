@@ -0,0 +1,168 @@
+package aime
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+const testTree = `Medicine;H02
+Cardiology;H02.100
+Cardiac Imaging;H02.100.1
+Oncology;H02.200
+Imaging;X01
+Cardiac Imaging;X01.1
+`
+
+const testOBO = `format-version: 1.2
+
+[Term]
+id: T:1
+name: medicine
+
+[Term]
+id: T:2
+name: cardiology
+synonym: "heart medicine" EXACT []
+is_a: T:1 ! medicine
+
+[Term]
+id: T:3
+name: old term
+is_obsolete: true
+
+[Typedef]
+id: part_of
+name: part of
+`
+
+const testSKOS = `<?xml version="1.0"?>
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns:skos="http://www.w3.org/2004/02/skos/core#">
+  <skos:Concept rdf:about="http://ex.org/medicine">
+    <skos:prefLabel xml:lang="en">medicine</skos:prefLabel>
+  </skos:Concept>
+  <skos:Concept rdf:about="http://ex.org/cardiology">
+    <skos:prefLabel xml:lang="en">cardiology</skos:prefLabel>
+    <skos:altLabel xml:lang="en">heart medicine</skos:altLabel>
+    <skos:broader rdf:resource="http://ex.org/medicine"/>
+  </skos:Concept>
+</rdf:RDF>
+`
+
+func writeTestTaxonomy(t *testing.T, name string, content string) *Taxonomy {
+	ioutil.WriteFile(name, []byte(content), os.ModePerm)
+	defer os.Remove(name)
+
+	tx, err := ReadTaxonomy(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestReadTaxonomy__Tree(t *testing.T) {
+	tx := writeTestTaxonomy(t, "./test-taxonomy.txt", testTree)
+
+	c := tx.Find("cardiac imaging")
+	if c == nil || len(c.Parents) != 2 {
+		t.Fatal(c)
+	}
+	if len(tx.ancestors(c)) != 4 {
+		t.Fatal(tx.ancestors(c))
+	}
+	roots := tx.Roots()
+	if len(roots) != 2 || roots[0].Label != "Imaging" {
+		t.Fatal(roots)
+	}
+	if len(tx.Children(tx.Find("Medicine"))) != 2 {
+		t.Fatal()
+	}
+}
+
+func TestReadTaxonomy__OBO(t *testing.T) {
+	tx := writeTestTaxonomy(t, "./test-taxonomy.obo", testOBO)
+
+	c := tx.Find("Heart Medicine")
+	if c == nil || c.ID != "T:2" || c.Parents[0] != "T:1" {
+		t.Fatal(c)
+	}
+	if tx.Find("T:1").Label != "medicine" {
+		t.Fatal()
+	}
+	if tx.Find("old term") != nil || tx.Find("part of") != nil {
+		t.Fatal()
+	}
+}
+
+func TestReadTaxonomy__SKOS(t *testing.T) {
+	tx := writeTestTaxonomy(t, "./test-taxonomy.rdf", testSKOS)
+
+	c := tx.Find("heart medicine")
+	if c == nil || c.Label != "cardiology" || c.Parents[0] != "http://ex.org/medicine" {
+		t.Fatal(c)
+	}
+}
+
+func TestDB_Taxonomy(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	db.Taxonomy = writeTestTaxonomy(t, "./test-taxonomy.txt", testTree)
+	db.KeywordGroups = KeywordGroups{
+		{Sources: []string{"echo"}, Targets: []string{"Cardiac Imaging"}},
+	}
+
+	for _, k := range []string{"echo", "cardiology", "oncology", "statistics"} {
+		r := db.CreateReport("", true)
+		db.CreateRevision(r.ID, "", json.RawMessage("{\"MD\":{\"1\":\"Title\",\"5\":[{\"custom\":true,\"value\":\""+k+"\"}]}}"), r.Token, true)
+	}
+	db.BuildIndexes()
+
+	if len(db.GetKeyword("Medicine").reports) != 3 {
+		t.Fatal()
+	}
+	if len(db.GetKeyword("cardiology").reports) != 2 {
+		t.Fatal()
+	}
+	if len(db.GetKeyword("Imaging").reports) != 1 {
+		t.Fatal()
+	}
+	if len(db.GetKeyword("statistics").reports) != 1 {
+		t.Fatal()
+	}
+
+	tree := db.GetIndexTree("keywords")
+	if len(tree) != 3 {
+		t.Fatal(len(tree))
+	}
+	if tree[0].concept.Label != "Medicine" || len(tree[0].entry.reports) != 3 || len(tree[0].children) != 2 {
+		t.Fatal()
+	}
+	if tree[2].concept != nil || tree[2].entry.value != "statistics" {
+		t.Fatal()
+	}
+
+	srv := Server{DB: db}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, _ := http.Get(ts.URL + "/keywords?format=tree")
+	respBytes, _ := ioutil.ReadAll(resp.Body)
+	treeResp := KeywordTreeResponse{}
+	json.Unmarshal(respBytes, &treeResp)
+	if len(treeResp.Keywords) != 3 || treeResp.Keywords[0].Children[0].Label != "Cardiology" || treeResp.Keywords[0].Children[0].Count != 2 {
+		t.Fatal(string(respBytes))
+	}
+
+	resp, _ = http.Get(ts.URL + "/search?k=medicine&l=10")
+	respBytes, _ = ioutil.ReadAll(resp.Body)
+	searchResp := SearchResponse{}
+	json.Unmarshal(respBytes, &searchResp)
+	if searchResp.Count != 3 {
+		t.Fatal(string(respBytes))
+	}
+}
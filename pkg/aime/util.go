@@ -10,6 +10,12 @@ import (
 	"strings"
 )
 
+const (
+	reportURL   = "https://aime.report/"
+	registryURL = "https://aime-registry.org/"
+	apiURL      = "https://aime-registry.org/api/"
+)
+
 type Config struct {
 	Options []struct {
 		Key   string `json:"key"`
@@ -25,6 +31,9 @@ type Question struct {
 	Config   *Config    `json:"config"`
 	Children []Question `json:"children"`
 	Child    *Question  `json:"child"`
+	// Condition is a JavaScript expression over the answer of the parent
+	// question, see evalCondition
+	Condition string `json:"condition"`
 }
 
 func IsJSON(str string) bool {
@@ -94,7 +103,8 @@ func extractField(q Question, a interface{}, ids []string, sep string) string {
 		if len(ids) != 0 {
 			return ""
 		}
-		return a.(string)
+		str, _ := a.(string)
+		return str
 	}
 
 	if q.Type == "select" || q.Type == "radio" {
@@ -174,13 +184,41 @@ func extractField(q Question, a interface{}, ids []string, sep string) string {
 	return ""
 }
 
-func extractText(q Question, a interface{}) string {
+// extractList returns the question of the elements and the elements of the
+// list answer at the path ids.
+func extractList(q Question, a interface{}, ids []string) (*Question, []interface{}) {
+	for _, id := range ids {
+		if q.Type != "complex" {
+			return nil, nil
+		}
+		compl, _ := a.(map[string]interface{})
+		found := false
+		for _, child := range q.Children {
+			if child.ID == id {
+				q, a, found = child, compl[id], true
+				break
+			}
+		}
+		if !found {
+			return nil, nil
+		}
+	}
+
+	list, _ := a.([]interface{})
+	if q.Type != "list" || q.Child == nil {
+		return nil, nil
+	}
+	return q.Child, list
+}
+
+func extractText(q Question, a interface{}, sep string) string {
 	if a == nil {
 		return ""
 	}
 
 	if q.Type == "string" || q.Type == "text" {
-		return a.(string)
+		str, _ := a.(string)
+		return str
 	}
 
 	if q.Type == "select" || q.Type == "radio" {
@@ -195,6 +233,9 @@ func extractText(q Question, a interface{}) string {
 		txt := ""
 		for _, v := range vals {
 			val := extractValue(v, q)
+			if txt != "" && val != "" {
+				txt += sep
+			}
 			txt += val
 		}
 		return txt
@@ -210,7 +251,11 @@ func extractText(q Question, a interface{}) string {
 		}
 		txt := ""
 		for _, child := range q.Children {
-			txt += extractText(child, compl[child.ID])
+			val := extractText(child, compl[child.ID], sep)
+			if txt != "" && val != "" {
+				txt += sep
+			}
+			txt += val
 		}
 		return txt
 	}
@@ -225,7 +270,11 @@ func extractText(q Question, a interface{}) string {
 		}
 		txt := ""
 		for _, ae := range list {
-			txt += extractText(*q.Child, ae)
+			val := extractText(*q.Child, ae, sep)
+			if txt != "" && val != "" {
+				txt += sep
+			}
+			txt += val
 		}
 		return txt
 	}
@@ -233,6 +282,125 @@ func extractText(q Question, a interface{}) string {
 	return ""
 }
 
+type answerField struct {
+	path  string
+	value string
+}
+
+func joinPath(path string, id string) string {
+	if path == "" {
+		return id
+	}
+	return path + "." + id
+}
+
+// flattenAnswers lists the answered leaf fields with their dot-separated
+// paths, e.g. "MD.6.1.1" for the name of the first contact. List elements are
+// numbered from 1 and every selected option is a field of its own.
+func flattenAnswers(q Question, a interface{}, path string) []answerField {
+	if a == nil {
+		return nil
+	}
+
+	var fs []answerField
+
+	switch q.Type {
+	case "string", "text", "file":
+		if str, _ := a.(string); str != "" {
+			fs = append(fs, answerField{path, str})
+		}
+	case "boolean":
+		if b, ok := a.(bool); ok {
+			fs = append(fs, answerField{path, strconv.FormatBool(b)})
+		}
+	case "select", "radio":
+		if val := extractValue(a, q); val != "" {
+			fs = append(fs, answerField{path, val})
+		}
+	case "checkboxes", "tags":
+		vals, _ := a.([]interface{})
+		for _, v := range vals {
+			if val := extractValue(v, q); val != "" {
+				fs = append(fs, answerField{path, val})
+			}
+		}
+	case "complex":
+		compl, _ := a.(map[string]interface{})
+		for _, child := range q.Children {
+			fs = append(fs, flattenAnswers(child, compl[child.ID], joinPath(path, child.ID))...)
+		}
+	case "list":
+		if q.Child == nil {
+			return nil
+		}
+		list, _ := a.([]interface{})
+		for i, ae := range list {
+			fs = append(fs, flattenAnswers(*q.Child, ae, joinPath(path, strconv.Itoa(i+1)))...)
+		}
+	}
+
+	return fs
+}
+
+// negotiate picks the offered media type the Accept header prefers. The
+// quality of an offer is the one of the most specific media range matching
+// it, so "*/*, application/json;q=0" excludes JSON. The first offer is the
+// default for a missing header or wildcards. It returns "" if none of the
+// offers is acceptable.
+func negotiate(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	qs := make([]float64, len(offers))
+	specifics := make([]int, len(offers))
+	for i := range specifics {
+		specifics[i] = -1
+	}
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mt := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		for i, o := range offers {
+			specific := -1
+			if mt == o {
+				specific = 2
+			} else if strings.HasSuffix(mt, "/*") && strings.HasPrefix(o, strings.TrimSuffix(mt, "*")) {
+				specific = 1
+			} else if mt == "*/*" {
+				specific = 0
+			}
+			if specific > specifics[i] {
+				qs[i], specifics[i] = q, specific
+			}
+		}
+	}
+
+	// Equal qualities prefer the more specific match, then the earlier offer
+	best := -1
+	for i := range offers {
+		if qs[i] <= 0 {
+			continue
+		}
+		if best < 0 || qs[i] > qs[best] || (qs[i] == qs[best] && specifics[i] > specifics[best]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+	return offers[best]
+}
+
 func LoadQuestions(filename string) Question {
 	q := Question{}
 	qBytes, _ := ioutil.ReadFile(filename)
@@ -276,7 +444,7 @@ func ExtractSectionText(question Question, answers json.RawMessage, section stri
 
 	for _, ch := range question.Children {
 		if ch.ID == section {
-			return extractText(ch, sections[section])
+			return extractText(ch, sections[section], "")
 		}
 	}
 
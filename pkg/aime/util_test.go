@@ -140,3 +140,60 @@ func TestExtractFields(t *testing.T) {
 		t.Fatal()
 	}
 }
+
+func TestFlattenAnswers(t *testing.T) {
+	q := LoadQuestions("../../questionnaire.yaml")
+
+	var ans interface{}
+	json.Unmarshal([]byte("{\"MD\":{\"1\":\"MyMetadata\",\"5\":[{\"custom\":false,\"value\":\"omics\"},{\"custom\":true,\"value\":\"b\"}],\"6\":[{\"1\":\"Name1\"},{\"1\":\"Name2\"}]},\"P\":{\"2\":{\"1\":true}}}"), &ans)
+
+	fs := flattenAnswers(q, ans, "")
+	exp := []answerField{
+		{"MD.1", "MyMetadata"},
+		{"MD.5", "omics"},
+		{"MD.5", "b"},
+		{"MD.6.1.1", "Name1"},
+		{"MD.6.2.1", "Name2"},
+		{"P.2.1", "true"},
+	}
+	if len(fs) != len(exp) {
+		t.Fatal(fs)
+	}
+	for i := range exp {
+		if fs[i] != exp[i] {
+			t.Fatal(fs)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{"application/json", "application/ld+json"}
+
+	if negotiate("", offers) != "application/json" {
+		t.Fatal()
+	}
+	if negotiate("text/html,application/xhtml+xml,*/*;q=0.8", offers) != "application/json" {
+		t.Fatal()
+	}
+	if negotiate("application/ld+json", offers) != "application/ld+json" {
+		t.Fatal()
+	}
+	if negotiate("application/json;q=0.5, application/ld+json", offers) != "application/ld+json" {
+		t.Fatal()
+	}
+	if negotiate("application/ld+json;q=0, */*", offers) != "application/json" {
+		t.Fatal()
+	}
+	if negotiate("text/html", offers) != "" {
+		t.Fatal()
+	}
+	if negotiate("*/*, application/json;q=0", offers) != "application/ld+json" {
+		t.Fatal()
+	}
+	if negotiate("application/*, application/json;q=0, application/ld+json;q=0", offers) != "" {
+		t.Fatal()
+	}
+	if negotiate("application/ld+json, */*", offers) != "application/ld+json" {
+		t.Fatal()
+	}
+}
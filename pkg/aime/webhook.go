@@ -0,0 +1,642 @@
+package aime
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Webhook events
+const (
+	EventReportCreated   = "report.created"
+	EventRevisionCreated = "revision.created"
+	EventIssueCreated    = "issue.created"
+	EventIssueConfirmed  = "issue.confirmed"
+	EventAnswerCreated   = "answer.created"
+	EventIssuePublished  = "issue.published"
+)
+
+var WebhookEvents = []string{
+	EventReportCreated,
+	EventRevisionCreated,
+	EventIssueCreated,
+	EventIssueConfirmed,
+	EventAnswerCreated,
+	EventIssuePublished,
+}
+
+// Delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+var ErrInvalidWebhook = errors.New("webhook needs an http(s) URL and known events")
+
+// Waiting times before the retries of a failed delivery. A delivery is given
+// up after all retries failed.
+var webhookBackoff = []time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	30 * time.Minute,
+	2 * time.Hour,
+	12 * time.Hour,
+}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// Maximum number of response bytes kept per delivery attempt
+const webhookResponseSize = 1024
+
+// Webhook is a subscription to registry events. Every event is posted as JSON
+// to URL, signed with Secret in the X-AIMe-Signature header.
+type Webhook struct {
+	ID         string    `json:"id"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"secret"`
+	Events     []string  `json:"events"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"createdAt"`
+	Deliveries int       `json:"deliveries"`
+}
+
+type DeliveryAttempt struct {
+	CreatedAt  time.Time `json:"createdAt"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Response   string    `json:"response,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type Delivery struct {
+	ID            int               `json:"id"`
+	WebhookID     string            `json:"webhookId"`
+	Event         string            `json:"event"`
+	Payload       json.RawMessage   `json:"payload"`
+	Status        string            `json:"status"`
+	Attempts      []DeliveryAttempt `json:"attempts"`
+	CreatedAt     time.Time         `json:"createdAt"`
+	NextAttemptAt time.Time         `json:"nextAttemptAt"`
+}
+
+type WebhookPayload struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Data      interface{} `json:"data"`
+}
+
+func validEvent(e string) bool {
+	for _, ve := range WebhookEvents {
+		if e == ve {
+			return true
+		}
+	}
+	return false
+}
+
+func (wh Webhook) valid() bool {
+	u, err := url.Parse(wh.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return false
+	}
+	if len(wh.Events) == 0 {
+		return false
+	}
+	for _, e := range wh.Events {
+		if !validEvent(e) {
+			return false
+		}
+	}
+	return true
+}
+
+func (wh Webhook) subscribed(event string) bool {
+	for _, e := range wh.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// signPayload returns the hex encoded HMAC-SHA256 of the payload.
+func signPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Webhooks
+
+func (db *DB) CreateWebhook(wh Webhook) (*Webhook, error) {
+	if !wh.valid() {
+		return nil, ErrInvalidWebhook
+	}
+
+	wh.ID = generateRandomString(8)
+	if wh.Secret == "" {
+		wh.Secret = generateRandomString(32)
+	}
+	wh.CreatedAt = time.Now()
+	wh.Deliveries = 0
+
+	db.writeMutex.RLock()
+	defer db.writeMutex.RUnlock()
+
+	err := os.MkdirAll(db.deliveryPath(wh.ID), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wh, db.SetWebhook(wh)
+}
+
+func (db *DB) GetWebhook(id string) *Webhook {
+	whBytes, err := ioutil.ReadFile(filepath.Join(db.webhookPath(id), "webhook.json"))
+	if err != nil {
+		return nil
+	}
+	wh := Webhook{}
+	err = json.Unmarshal(whBytes, &wh)
+	if err != nil {
+		return nil
+	}
+	return &wh
+}
+
+func (db *DB) SetWebhook(wh Webhook) error {
+	whBytes, _ := json.Marshal(wh)
+	return ioutil.WriteFile(filepath.Join(db.webhookPath(wh.ID), "webhook.json"), whBytes, os.ModePerm)
+}
+
+// UpdateWebhook replaces URL, events and the active flag of a webhook. The
+// secret is only replaced if a new one is given.
+func (db *DB) UpdateWebhook(id string, upd Webhook) (*Webhook, error) {
+	if !upd.valid() {
+		return nil, ErrInvalidWebhook
+	}
+
+	db.writeMutex.RLock()
+	defer db.writeMutex.RUnlock()
+	db.webhookMutex.Lock()
+	defer db.webhookMutex.Unlock()
+
+	wh := db.GetWebhook(id)
+	if wh == nil {
+		return nil, nil
+	}
+
+	wh.URL = upd.URL
+	wh.Events = upd.Events
+	wh.Active = upd.Active
+	if upd.Secret != "" {
+		wh.Secret = upd.Secret
+	}
+
+	return wh, db.SetWebhook(*wh)
+}
+
+// DeleteWebhook removes a webhook with its deliveries. Deliveries in progress
+// are not recorded any more.
+func (db *DB) DeleteWebhook(id string) bool {
+	db.writeMutex.RLock()
+	defer db.writeMutex.RUnlock()
+	db.webhookMutex.Lock()
+	defer db.webhookMutex.Unlock()
+
+	if db.GetWebhook(id) == nil {
+		return false
+	}
+	os.RemoveAll(db.webhookPath(id))
+	return true
+}
+
+// GetWebhooks returns all webhooks, oldest first.
+func (db *DB) GetWebhooks() []*Webhook {
+	var whs []*Webhook
+	files, err := ioutil.ReadDir(filepath.Join(db.Dir, "webhooks"))
+	if err != nil {
+		return whs
+	}
+	for _, f := range files {
+		if !f.IsDir() {
+			continue
+		}
+		if wh := db.GetWebhook(f.Name()); wh != nil {
+			whs = append(whs, wh)
+		}
+	}
+	sort.Slice(whs, func(i, j int) bool {
+		return whs[i].CreatedAt.Before(whs[j].CreatedAt)
+	})
+	return whs
+}
+
+// Deliveries
+
+func (db *DB) GetDelivery(webhookID string, id int) *Delivery {
+	dBytes, err := ioutil.ReadFile(db.deliveryFilePath(webhookID, id))
+	if err != nil {
+		return nil
+	}
+	d := Delivery{}
+	err = json.Unmarshal(dBytes, &d)
+	if err != nil {
+		return nil
+	}
+	return &d
+}
+
+func (db *DB) SetDelivery(d Delivery) {
+	dBytes, _ := json.Marshal(d)
+	ioutil.WriteFile(db.deliveryFilePath(d.WebhookID, d.ID), dBytes, os.ModePerm)
+}
+
+// GetDeliveries returns the deliveries of a webhook, newest first.
+func (db *DB) GetDeliveries(webhookID string) []*Delivery {
+	var ds []*Delivery
+	files, err := ioutil.ReadDir(db.deliveryPath(webhookID))
+	if err != nil {
+		return ds
+	}
+	for _, f := range files {
+		id, err := strconv.Atoi(strings.Split(f.Name(), ".")[0])
+		if err != nil {
+			continue
+		}
+		if d := db.GetDelivery(webhookID, id); d != nil {
+			ds = append(ds, d)
+		}
+	}
+	sort.Slice(ds, func(i, j int) bool {
+		return ds[i].ID > ds[j].ID
+	})
+	return ds
+}
+
+// updateDelivery records a delivery unless its webhook was deleted.
+func (db *DB) updateDelivery(d Delivery) bool {
+	db.writeMutex.RLock()
+	defer db.writeMutex.RUnlock()
+	db.webhookMutex.Lock()
+	defer db.webhookMutex.Unlock()
+
+	if db.GetWebhook(d.WebhookID) == nil {
+		return false
+	}
+	db.SetDelivery(d)
+	return true
+}
+
+func (db *DB) createDelivery(webhookID string, event string, payload []byte) *Delivery {
+	db.writeMutex.RLock()
+	defer db.writeMutex.RUnlock()
+	db.webhookMutex.Lock()
+	defer db.webhookMutex.Unlock()
+
+	wh := db.GetWebhook(webhookID)
+	if wh == nil {
+		return nil
+	}
+
+	wh.Deliveries++
+	db.SetWebhook(*wh)
+
+	d := Delivery{
+		ID:            wh.Deliveries,
+		WebhookID:     wh.ID,
+		Event:         event,
+		Payload:       payload,
+		Status:        DeliveryPending,
+		CreatedAt:     time.Now(),
+		NextAttemptAt: time.Now(),
+	}
+	db.SetDelivery(d)
+
+	return &d
+}
+
+// webhookQueue holds the fired events whose deliveries are not created yet.
+// They are processed in order by one goroutine, which runs while the queue is
+// not empty.
+type webhookQueue struct {
+	mutex   sync.Mutex
+	events  []queuedEvent
+	running bool
+}
+
+type queuedEvent struct {
+	event   string
+	at      time.Time
+	payload []byte
+}
+
+// FireWebhook queues an event. A delivery is created for every active webhook
+// subscribed to the event and delivered in the background. Webhooks created
+// after the event happened at the given time are skipped.
+func (db *DB) FireWebhook(event string, at time.Time, data interface{}) {
+	payload, err := json.Marshal(WebhookPayload{
+		ID:        generateRandomString(16),
+		Event:     event,
+		CreatedAt: at,
+		Data:      data,
+	})
+	if err != nil {
+		log.Printf("Could not encode %s event: %v\n", event, err)
+		return
+	}
+
+	q := &db.webhooks
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.events = append(q.events, queuedEvent{event, at, payload})
+	if !q.running {
+		q.running = true
+		go db.processWebhooks()
+	}
+}
+
+func (db *DB) processWebhooks() {
+	q := &db.webhooks
+	for {
+		q.mutex.Lock()
+		if len(q.events) == 0 {
+			q.running = false
+			q.mutex.Unlock()
+			return
+		}
+		e := q.events[0]
+		q.events = q.events[1:]
+		q.mutex.Unlock()
+
+		for _, wh := range db.GetWebhooks() {
+			if !wh.Active || !wh.subscribed(e.event) || wh.CreatedAt.After(e.at) {
+				continue
+			}
+			if d := db.createDelivery(wh.ID, e.event, e.payload); d != nil {
+				go db.deliver(*d)
+			}
+		}
+	}
+}
+
+// Redeliver starts a new series of attempts for a delivery.
+func (db *DB) Redeliver(webhookID string, id int) *Delivery {
+	d := db.GetDelivery(webhookID, id)
+	if d == nil || d.Status == DeliveryPending {
+		return d
+	}
+
+	d.Status = DeliveryPending
+	d.NextAttemptAt = time.Now()
+	if !db.updateDelivery(*d) {
+		return nil
+	}
+
+	go db.deliver(*d)
+
+	return d
+}
+
+// ResumeDeliveries continues the pending deliveries, e.g. after a restart.
+func (db *DB) ResumeDeliveries() int {
+	n := 0
+	for _, wh := range db.GetWebhooks() {
+		for _, d := range db.GetDeliveries(wh.ID) {
+			if d.Status == DeliveryPending {
+				go db.deliver(*d)
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func (db *DB) attempt(wh *Webhook, d Delivery) DeliveryAttempt {
+	at := DeliveryAttempt{CreatedAt: time.Now()}
+
+	req, err := http.NewRequest("POST", wh.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		at.Error = err.Error()
+		return at
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "AIMe-Registry-Webhook")
+	req.Header.Set("X-AIMe-Event", d.Event)
+	req.Header.Set("X-AIMe-Delivery", fmt.Sprintf("%s-%d", d.WebhookID, d.ID))
+	req.Header.Set("X-AIMe-Signature", "sha256="+signPayload(wh.Secret, d.Payload))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		at.Error = err.Error()
+		return at
+	}
+	defer resp.Body.Close()
+
+	respBytes, _ := ioutil.ReadAll(io.LimitReader(resp.Body, webhookResponseSize))
+	at.StatusCode = resp.StatusCode
+	at.Response = string(respBytes)
+
+	return at
+}
+
+// deliver posts the payload until the receiver answers with a 2xx status or
+// all retries failed. Every attempt is recorded.
+func (db *DB) deliver(d Delivery) {
+	// Retries count from the start of the current series of attempts
+	first := len(d.Attempts)
+	for {
+		if wait := time.Until(d.NextAttemptAt); wait > 0 {
+			time.Sleep(wait)
+		}
+
+		wh := db.GetWebhook(d.WebhookID)
+		if wh == nil {
+			return
+		}
+
+		at := db.attempt(wh, d)
+		d.Attempts = append(d.Attempts, at)
+
+		retry := len(d.Attempts) - first - 1
+		if at.StatusCode >= 200 && at.StatusCode < 300 {
+			d.Status = DeliveryDelivered
+			d.NextAttemptAt = time.Time{}
+		} else if retry >= len(webhookBackoff) {
+			d.Status = DeliveryFailed
+			d.NextAttemptAt = time.Time{}
+		} else {
+			d.NextAttemptAt = time.Now().Add(webhookBackoff[retry])
+		}
+
+		if !db.updateDelivery(d) || d.Status != DeliveryPending {
+			return
+		}
+	}
+}
+
+// Issue publication
+
+// PublishIssue marks an issue as published once it is no longer pending. It
+// returns the issue if it was published just now.
+func (db *DB) PublishIssue(id string, issue int) *Issue {
+	db.writeMutex.RLock()
+	defer db.writeMutex.RUnlock()
+	db.issueMutex.Lock()
+	defer db.issueMutex.Unlock()
+
+	iss := db.GetIssue(id, issue)
+	if iss == nil || iss.Deleted || !iss.Verified || iss.Pending() || !iss.PublishedAt.IsZero() {
+		return nil
+	}
+
+	iss.PublishedAt = time.Now()
+	db.SetIssue(*iss)
+
+	db.publishStatus(*iss)
+
+	db.recordChange(Change{Type: EventIssuePublished, ReportID: id, IssueID: issue})
+
+	return iss
+}
+
+// publishedAt returns when the issue stopped being pending: at the first
+// answer of the owner or at the end of the pending time.
+func (c Issue) publishedAt() time.Time {
+	end := c.VerifiedAt.Add(pendingTime)
+	for _, a := range c.Answers {
+		if a.Owner && a.CreatedAt.Before(end) {
+			return a.CreatedAt
+		}
+	}
+	return end
+}
+
+// PublishIssues publishes all issues whose pending time ran out and fires
+// their issue.published events.
+func (db *DB) PublishIssues() int {
+	n := 0
+	files, err := ioutil.ReadDir(filepath.Join(db.Dir, "reports"))
+	if err != nil {
+		return n
+	}
+	for _, f := range files {
+		if !f.IsDir() {
+			continue
+		}
+		for iss := range db.GetReportIssues(f.Name(), true) {
+			if !iss.PublishedAt.IsZero() || iss.Pending() {
+				continue
+			}
+			if pub := db.PublishIssue(iss.ReportID, iss.ID); pub != nil {
+				db.FireWebhook(EventIssuePublished, pub.publishedAt(), newIssueEvent(*pub))
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// WatchIssues periodically publishes issues whose pending time ran out. The
+// returned function stops watching.
+func (db *DB) WatchIssues(interval time.Duration) func() {
+	stop := make(chan bool)
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				db.PublishIssues()
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+	}
+}
+
+func (req WebhookRequest) webhook() Webhook {
+	wh := Webhook{
+		URL:    req.URL,
+		Secret: req.Secret,
+		Events: req.Events,
+		Active: true,
+	}
+	if req.Active != nil {
+		wh.Active = *req.Active
+	}
+	return wh
+}
+
+// Event data
+
+func newReportEvent(rp Report, rev Revision) ReportEvent {
+	return ReportEvent{
+		ReportID:  rp.ID,
+		Version:   rev.Version,
+		Public:    rev.Public,
+		CreatedAt: rev.CreatedAt,
+		URL:       reportURL + rp.ID + "/" + strconv.Itoa(rev.Version),
+	}
+}
+
+func newIssueEvent(iss Issue) IssueEvent {
+	return IssueEvent{
+		ReportID:   iss.ReportID,
+		IssueID:    iss.ID,
+		RevisionID: iss.RevisionID,
+		Type:       iss.Type,
+		Field:      iss.Field,
+		CreatedAt:  iss.CreatedAt,
+		URL:        registryURL + "report/" + iss.ReportID + "/issue/" + strconv.Itoa(iss.ID),
+	}
+}
+
+func newAnswerEvent(iss Issue, a Answer) AnswerEvent {
+	return AnswerEvent{
+		ReportID:  iss.ReportID,
+		IssueID:   iss.ID,
+		AnswerID:  a.ID,
+		Owner:     a.Owner,
+		CreatedAt: a.CreatedAt,
+		URL:       registryURL + "report/" + iss.ReportID + "/issue/" + strconv.Itoa(iss.ID),
+	}
+}
+
+// Private functions
+
+func (db *DB) webhookPath(id string) string {
+	return filepath.Join(db.Dir, "webhooks", id)
+}
+
+func (db *DB) deliveryPath(id string) string {
+	return filepath.Join(db.webhookPath(id), "deliveries")
+}
+
+func (db *DB) deliveryFilePath(id string, delivery int) string {
+	return filepath.Join(db.deliveryPath(id), fmt.Sprintf("%04d.json", delivery))
+}
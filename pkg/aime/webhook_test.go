@@ -0,0 +1,295 @@
+package aime
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type webhookReceiver struct {
+	fail     int
+	mutex    sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	wr.mutex.Lock()
+	defer wr.mutex.Unlock()
+
+	wr.requests = append(wr.requests, r)
+	wr.bodies = append(wr.bodies, body)
+	if wr.fail > 0 {
+		wr.fail--
+		w.WriteHeader(500)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+func waitForDelivery(db *DB, webhookID string, id int, status string) *Delivery {
+	for i := 0; i < 200; i++ {
+		if d := db.GetDelivery(webhookID, id); d != nil && d.Status == status {
+			return d
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return db.GetDelivery(webhookID, id)
+}
+
+func TestWebhook_valid(t *testing.T) {
+	if !(Webhook{URL: "https://example.org/hook", Events: []string{EventReportCreated}}).valid() {
+		t.Fatal()
+	}
+	if (Webhook{URL: "ftp://example.org/hook", Events: []string{EventReportCreated}}).valid() {
+		t.Fatal()
+	}
+	if (Webhook{URL: "https://example.org/hook", Events: []string{"report.deleted"}}).valid() {
+		t.Fatal()
+	}
+	if (Webhook{URL: "https://example.org/hook"}).valid() {
+		t.Fatal()
+	}
+}
+
+func TestDB_FireWebhook(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	wr := &webhookReceiver{}
+	ts := httptest.NewServer(wr)
+	defer ts.Close()
+
+	wh, err := db.CreateWebhook(Webhook{URL: ts.URL, Events: []string{EventIssueCreated}, Active: true})
+	if err != nil || wh.Secret == "" {
+		t.Fatal(err)
+	}
+	_, err = db.CreateWebhook(Webhook{URL: ts.URL, Events: []string{EventReportCreated}, Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.FireWebhook(EventIssueCreated, time.Now(), IssueEvent{ReportID: "abc", IssueID: 1})
+
+	d := waitForDelivery(db, wh.ID, 1, DeliveryDelivered)
+	if d == nil || d.Status != DeliveryDelivered || len(d.Attempts) != 1 || d.Attempts[0].StatusCode != 200 {
+		t.Fatal(d)
+	}
+
+	wr.mutex.Lock()
+	defer wr.mutex.Unlock()
+	if len(wr.requests) != 1 {
+		t.Fatal(len(wr.requests))
+	}
+	if wr.requests[0].Header.Get("X-AIMe-Event") != EventIssueCreated {
+		t.Fatal()
+	}
+	if wr.requests[0].Header.Get("X-AIMe-Signature") != "sha256="+signPayload(wh.Secret, wr.bodies[0]) {
+		t.Fatal()
+	}
+	payload := struct {
+		Event string     `json:"event"`
+		Data  IssueEvent `json:"data"`
+	}{}
+	json.Unmarshal(wr.bodies[0], &payload)
+	if payload.Event != EventIssueCreated || payload.Data.ReportID != "abc" {
+		t.Fatal(string(wr.bodies[0]))
+	}
+}
+
+func TestDB_FireWebhook_Retry(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	backoff := webhookBackoff
+	webhookBackoff = []time.Duration{time.Millisecond, time.Millisecond}
+	defer func() {
+		webhookBackoff = backoff
+	}()
+
+	wr := &webhookReceiver{fail: 2}
+	ts := httptest.NewServer(wr)
+	defer ts.Close()
+
+	wh, _ := db.CreateWebhook(Webhook{URL: ts.URL, Events: []string{EventReportCreated}, Active: true})
+
+	db.FireWebhook(EventReportCreated, time.Now(), ReportEvent{ReportID: "abc", Version: 1})
+
+	d := waitForDelivery(db, wh.ID, 1, DeliveryDelivered)
+	if d.Status != DeliveryDelivered || len(d.Attempts) != 3 || d.Attempts[0].StatusCode != 500 {
+		t.Fatal(d)
+	}
+
+	// Give up after all retries
+	wr.mutex.Lock()
+	wr.fail = 3
+	wr.mutex.Unlock()
+
+	db.FireWebhook(EventReportCreated, time.Now(), ReportEvent{ReportID: "abc", Version: 2})
+
+	d = waitForDelivery(db, wh.ID, 2, DeliveryFailed)
+	if d.Status != DeliveryFailed || len(d.Attempts) != 3 {
+		t.Fatal(d)
+	}
+
+	db.Redeliver(wh.ID, 2)
+	d = waitForDelivery(db, wh.ID, 2, DeliveryDelivered)
+	if d.Status != DeliveryDelivered || len(d.Attempts) != 4 {
+		t.Fatal(d)
+	}
+
+	// Deliveries in progress do not bring back deleted webhooks
+	wr.mutex.Lock()
+	wr.fail = 3
+	wr.mutex.Unlock()
+	webhookBackoff = []time.Duration{50 * time.Millisecond, 50 * time.Millisecond}
+
+	db.FireWebhook(EventReportCreated, time.Now(), ReportEvent{ReportID: "abc", Version: 3})
+	waitForDelivery(db, wh.ID, 3, DeliveryPending)
+	if !db.DeleteWebhook(wh.ID) {
+		t.Fatal()
+	}
+	time.Sleep(150 * time.Millisecond)
+	if _, err := os.Stat(db.webhookPath(wh.ID)); !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	if upd, err := db.UpdateWebhook(wh.ID, Webhook{URL: ts.URL, Events: []string{EventReportCreated}}); upd != nil || err != nil {
+		t.Fatal(upd, err)
+	}
+}
+
+func TestDB_PublishIssues(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	wr := &webhookReceiver{}
+	ts := httptest.NewServer(wr)
+	defer ts.Close()
+
+	rp := db.CreateReport("", true)
+	db.CreateRevision(rp.ID, "", json.RawMessage("{}"), rp.Token, true)
+
+	old := db.CreateIssue(rp.ID, "John", "john@example.org", nil, "Old", 0)
+	db.ValidateIssue(rp.ID, old.ID, old.Token)
+	old = db.GetIssue(rp.ID, old.ID)
+	old.VerifiedAt = time.Now().Add(-pendingTime - 2*time.Hour)
+	db.SetIssue(*old)
+
+	wh, _ := db.CreateWebhook(Webhook{URL: ts.URL, Events: []string{EventIssuePublished}, Active: true})
+	wh.CreatedAt = time.Now().Add(-time.Hour)
+	db.SetWebhook(*wh)
+
+	recent := db.CreateIssue(rp.ID, "Jane", "jane@example.org", nil, "Recent", 0)
+	db.ValidateIssue(rp.ID, recent.ID, recent.Token)
+	recent = db.GetIssue(rp.ID, recent.ID)
+	recent.VerifiedAt = time.Now().Add(-pendingTime - time.Minute)
+	db.SetIssue(*recent)
+
+	pending := db.CreateIssue(rp.ID, "Joe", "joe@example.org", nil, "Pending", 0)
+	db.ValidateIssue(rp.ID, pending.ID, pending.Token)
+
+	// The old issue was published before the webhook existed
+	if n := db.PublishIssues(); n != 2 {
+		t.Fatal(n)
+	}
+	if n := db.PublishIssues(); n != 0 {
+		t.Fatal(n)
+	}
+
+	d := waitForDelivery(db, wh.ID, 1, DeliveryDelivered)
+	if d == nil || d.Status != DeliveryDelivered {
+		t.Fatal(d)
+	}
+	if len(db.GetDeliveries(wh.ID)) != 1 {
+		t.Fatal()
+	}
+	if !db.GetIssue(rp.ID, pending.ID).PublishedAt.IsZero() {
+		t.Fatal()
+	}
+}
+
+func TestServer_Webhooks(t *testing.T) {
+	db := &DB{Dir: "./test"}
+	db.Create("../../questionnaire.yaml")
+	defer db.Delete()
+
+	wr := &webhookReceiver{}
+	rs := httptest.NewServer(wr)
+	defer rs.Close()
+
+	es := NewEmailSender("<EMAIL HOST>", 587, "<EMAIL USERNAME>", "<EMAIL PASSWORD>")
+	es.LoadTemplates("../../templates/")
+
+	srv := Server{DB: db, ES: es, AdminToken: "admin"}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	reqBytes, _ := json.Marshal(WebhookRequest{URL: rs.URL, Events: []string{EventReportCreated, EventRevisionCreated}})
+
+	resp, _ := http.Post(ts.URL+"/admin/webhooks", "application/json", bytes.NewReader(reqBytes))
+	if resp.StatusCode != 403 {
+		t.Fatal()
+	}
+
+	resp, _ = http.Post(ts.URL+"/admin/webhooks?p=admin", "application/json", bytes.NewReader([]byte("{\"url\":\"nope\"}")))
+	if resp.StatusCode != 400 {
+		t.Fatal()
+	}
+
+	resp, _ = http.Post(ts.URL+"/admin/webhooks?p=admin", "application/json", bytes.NewReader(reqBytes))
+	if resp.StatusCode != 200 {
+		t.Fatal()
+	}
+	respBytes, _ := ioutil.ReadAll(resp.Body)
+	wh := Webhook{}
+	json.Unmarshal(respBytes, &wh)
+	if wh.ID == "" || !wh.Active || wh.Secret == "" {
+		t.Fatal(string(respBytes))
+	}
+
+	reqBytes, _ = json.Marshal(CreateReportRequest{Answers: json.RawMessage("{}"), Public: true})
+	resp, _ = http.Post(ts.URL+"/report", "application/json", bytes.NewReader(reqBytes))
+	if resp.StatusCode != 200 {
+		t.Fatal()
+	}
+
+	waitForDelivery(db, wh.ID, 1, DeliveryDelivered)
+
+	resp, _ = http.Get(ts.URL + "/admin/webhooks/" + wh.ID + "/deliveries?p=admin&status=delivered")
+	if resp.StatusCode != 200 {
+		t.Fatal()
+	}
+	respBytes, _ = ioutil.ReadAll(resp.Body)
+	dsResp := DeliveriesResponse{}
+	json.Unmarshal(respBytes, &dsResp)
+	if dsResp.Count != 1 || dsResp.Deliveries[0].Event != EventReportCreated {
+		t.Fatal(string(respBytes))
+	}
+
+	resp, _ = http.Get(ts.URL + "/admin/webhooks/" + wh.ID + "/deliveries/1?p=admin")
+	if resp.StatusCode != 200 {
+		t.Fatal()
+	}
+
+	req, _ := http.NewRequest("DELETE", ts.URL+"/admin/webhooks/"+wh.ID+"?p=admin", nil)
+	resp, _ = http.DefaultClient.Do(req)
+	if resp.StatusCode != 200 {
+		t.Fatal()
+	}
+
+	resp, _ = http.Get(ts.URL + "/admin/webhooks/" + wh.ID + "?p=admin")
+	if resp.StatusCode != 404 {
+		t.Fatal()
+	}
+}